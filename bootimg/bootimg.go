@@ -21,93 +21,317 @@ package bootimg
 
 import (
 	"bytes"
-	"encoding/binary"
-	"errors"
-	"io/ioutil"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"os"
 )
 
-type bootHeader map[uint]uint32
-
 const BOOT_MAGIC = "ANDROID!"
+
+// section identifies each of the images a boot image can hold
+type section int
+
 const (
-	kernelSize = iota
-	kernelAddr
-	ramdiskSize
-	ramdiskAddr
-	secondSize
-	secondAddr
-	tagsAddr
-	pageSize
+	kernelSection section = iota
+	ramdiskSection
+	secondSection
+	recoveryDtboSection
+	dtbSection
+	signatureSection
 )
 
+var sectionNames = map[section]string{
+	kernelSection:       "kernel",
+	ramdiskSection:      "ramdisk",
+	secondSection:       "second stage",
+	recoveryDtboSection: "recovery dtbo",
+	dtbSection:          "dtb",
+	signatureSection:    "boot signature",
+}
+
+func (s section) String() string {
+	return sectionNames[s]
+}
+
 type AndroidBootImg struct {
-	kernelOffset, ramdiskOffset, secondOffset uint32
-	hdr                                       bootHeader
-	img                                       []byte
+	offsets map[section]uint64
+	mtk     map[section]*MtkHeader
+	hdr     Header
+	r       io.ReaderAt
+	size    int64
 }
 
-// readChunk reads a chunk o b []bytes returns it's Little Endian
-// unsigned size(4) value
-func readChunk(b []byte) (value uint32, err error) {
-	if len(b) != 4 {
-		return value, errors.New("Reading an incorrect unsigned chunk of bytes")
-	}
-	buf := bytes.NewReader(b)
-	err = binary.Read(buf, binary.LittleEndian, &value)
-	return value, err
+// New reads a sequence of []byte corresponding to an android boot
+// image returning an AndroidBootImg which holds most the parsed headers
+// which are relevant to retrieve the contained images
+func New(img []byte) (AndroidBootImg, error) {
+	return Open(bytes.NewReader(img), int64(len(img)))
 }
 
-//New reads a sequence of []byte corresponding to an android boot
-//image returning an AndroidBootImg which holds most the parsed headers
-//which are relevant to retrieve the contained images
-func New(img []byte) (boot AndroidBootImg, err error) {
-	boot.img = img
-	magic := img[:len(BOOT_MAGIC)]
-	if BOOT_MAGIC != string(magic) {
-		return boot, errors.New("This is not on an android bootimg")
+// Open reads the header of the size bytes long android boot image in r,
+// every section is checked to lie within size so it can later be read
+// from r through the section readers
+func Open(r io.ReaderAt, size int64) (boot AndroidBootImg, err error) {
+	boot.r, boot.size = r, size
+
+	buf := make([]byte, bootHeaderSizeV2)
+	if size < int64(len(buf)) {
+		buf = buf[:size]
+	}
+	if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return boot, err
+	}
+	if len(buf) < len(BOOT_MAGIC) || BOOT_MAGIC != string(buf[:len(BOOT_MAGIC)]) {
+		return boot, ErrBadMagic{"boot", BOOT_MAGIC}
 	}
 
-	boot.hdr = make(bootHeader)
-	for i, start := kernelSize, len(BOOT_MAGIC); i <= pageSize; i++ {
-		if boot.hdr[uint(i)], err = readChunk(img[start : start+4]); err != nil {
-			return boot, err
+	if boot.hdr, err = parseHeader(buf); err != nil {
+		return boot, err
+	}
+	if err := validPageSize(boot.hdr.PageSize); err != nil {
+		return boot, err
+	}
+	boot.offsets = boot.hdr.layout()
+	for _, s := range boot.hdr.sections() {
+		if end := boot.offsets[s] + uint64(boot.hdr.sectionSize(s)); end > uint64(size) {
+			return boot, ErrTruncated{s.String(), end, size}
+		}
+	}
+	boot.mtk = make(map[section]*MtkHeader)
+	for _, s := range []section{kernelSection, ramdiskSection} {
+		if mtk := readMtkHeader(boot.rawSection(s)); mtk != nil {
+			boot.mtk[s] = mtk
 		}
-		// sizeof(unsigned)
-		start += 4
-	}
-	n := (boot.hdr[kernelSize] + boot.hdr[pageSize] - 1) / boot.hdr[pageSize]
-	m := (boot.hdr[ramdiskSize] + boot.hdr[pageSize] - 1) / boot.hdr[pageSize]
-	//o := (boot.hdr[secondSize] + boot.hdr[pageSize] - 1) / boot.hdr[pageSize]
-	boot.kernelOffset = boot.hdr[pageSize]
-	boot.ramdiskOffset = boot.kernelOffset + (n * boot.hdr[pageSize])
-	boot.secondOffset = boot.ramdiskOffset + (m * boot.hdr[pageSize])
+	}
 	return boot, nil
 }
 
-//WriteRamdisk writes the ramdisk contained in AndroidBootImg to filepath
+// Info returns the parsed header of the boot image
+func (boot *AndroidBootImg) Info() Header {
+	return boot.hdr
+}
+
+// VerifyChecksum recomputes the SHA1 over the contained images and
+// compares it to the one stored in the header id
+func (boot *AndroidBootImg) VerifyChecksum() error {
+	if boot.hdr.Version() >= 3 {
+		return fmt.Errorf("boot image header version %d does not carry an id", boot.hdr.HeaderVersion)
+	}
+	var sections []*io.SectionReader
+	for _, s := range boot.hdr.sections() {
+		sections = append(sections, boot.rawSection(s))
+	}
+	sum, err := computeId(sections...)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(sum, boot.hdr.Id[:len(sum)]) != 1 {
+		return fmt.Errorf("boot image id %x does not match its contents (%x)", boot.hdr.Id[:len(sum)], sum)
+	}
+	return nil
+}
+
+// sections returns the sections a boot image with hdr carries in the
+// order they are laid out
+func (hdr Header) sections() []section {
+	switch hdr.Version() {
+	case 1:
+		return []section{kernelSection, ramdiskSection, secondSection, recoveryDtboSection}
+	case 2:
+		return []section{kernelSection, ramdiskSection, secondSection, recoveryDtboSection, dtbSection}
+	case 3:
+		return []section{kernelSection, ramdiskSection}
+	case 4:
+		return []section{kernelSection, ramdiskSection, signatureSection}
+	}
+	return []section{kernelSection, ramdiskSection, secondSection}
+}
+
+// hasSection returns true if s is part of the layout for hdr
+func (hdr Header) hasSection(s section) bool {
+	for _, sec := range hdr.sections() {
+		if sec == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (hdr Header) sectionSize(s section) uint32 {
+	switch s {
+	case kernelSection:
+		return hdr.KernelSize
+	case ramdiskSection:
+		return hdr.RamdiskSize
+	case secondSection:
+		return hdr.SecondSize
+	case recoveryDtboSection:
+		return hdr.RecoveryDtboSize
+	case dtbSection:
+		return hdr.DtbSize
+	case signatureSection:
+		return hdr.SignatureSize
+	}
+	return 0
+}
+
+func (hdr *Header) setSectionSize(s section, size uint32) {
+	switch s {
+	case kernelSection:
+		hdr.KernelSize = size
+	case ramdiskSection:
+		hdr.RamdiskSize = size
+	case secondSection:
+		hdr.SecondSize = size
+	case recoveryDtboSection:
+		hdr.RecoveryDtboSize = size
+	case dtbSection:
+		hdr.DtbSize = size
+	case signatureSection:
+		hdr.SignatureSize = size
+	}
+}
+
+// layout returns the offset of each section, the header takes the
+// first page and every section starts on a page boundary
+func (hdr Header) layout() map[section]uint64 {
+	offsets := make(map[section]uint64)
+	offset := alignTo(uint64(hdr.size()), hdr.PageSize)
+	for _, s := range hdr.sections() {
+		offsets[s] = offset
+		offset += alignTo(uint64(hdr.sectionSize(s)), hdr.PageSize)
+	}
+	return offsets
+}
+
+// imageSize returns the size of the page aligned header and sections
+func (hdr Header) imageSize() uint64 {
+	size := alignTo(uint64(hdr.size()), hdr.PageSize)
+	for _, s := range hdr.sections() {
+		size += alignTo(uint64(hdr.sectionSize(s)), hdr.PageSize)
+	}
+	return size
+}
+
+// rawSection returns a reader for s as stored in the image, which is
+// empty if not part of the image
+func (boot *AndroidBootImg) rawSection(s section) *io.SectionReader {
+	offset, ok := boot.offsets[s]
+	if !ok {
+		return io.NewSectionReader(boot.r, 0, 0)
+	}
+	return io.NewSectionReader(boot.r, int64(offset), int64(boot.hdr.sectionSize(s)))
+}
+
+// section returns a reader for the contents of s skipping its MediaTek
+// header if it has one
+func (boot *AndroidBootImg) section(s section) *io.SectionReader {
+	if mtk, ok := boot.mtk[s]; ok {
+		return io.NewSectionReader(boot.r, int64(boot.offsets[s])+mtkHeaderSize, int64(mtk.Size))
+	}
+	return boot.rawSection(s)
+}
+
+// Trailer returns a reader for the data stored after the last section,
+// such as AVB metadata or a SEAndroid signature
+func (boot *AndroidBootImg) Trailer() *io.SectionReader {
+	end := int64(boot.hdr.imageSize())
+	if end >= boot.size {
+		return io.NewSectionReader(boot.r, 0, 0)
+	}
+	return io.NewSectionReader(boot.r, end, boot.size-end)
+}
+
+// Kernel returns a reader for the kernel contained in AndroidBootImg
+// without the MediaTek header some devices wrap it in
+func (boot *AndroidBootImg) Kernel() *io.SectionReader {
+	return boot.section(kernelSection)
+}
+
+// Ramdisk returns a reader for the ramdisk contained in AndroidBootImg
+// without the MediaTek header some devices wrap it in
+func (boot *AndroidBootImg) Ramdisk() *io.SectionReader {
+	return boot.section(ramdiskSection)
+}
+
+// Second returns a reader for the second stage image, its Size is 0 if
+// the image does not carry one
+func (boot *AndroidBootImg) Second() *io.SectionReader {
+	return boot.section(secondSection)
+}
+
+// RecoveryDtbo returns a reader for the recovery DTBO of version 1 and 2
+// images, its Size is 0 if the image does not carry one
+func (boot *AndroidBootImg) RecoveryDtbo() *io.SectionReader {
+	return boot.section(recoveryDtboSection)
+}
+
+// Dtb returns a reader for the DTB of version 2 images, its Size is 0 if
+// the image does not carry one
+func (boot *AndroidBootImg) Dtb() *io.SectionReader {
+	return boot.section(dtbSection)
+}
+
+// Signature returns a reader for the boot signature of version 4 images,
+// its Size is 0 if the image does not carry one
+func (boot *AndroidBootImg) Signature() *io.SectionReader {
+	return boot.section(signatureSection)
+}
+
+// writeSection writes s to filePath failing if the image does not carry it
+func (boot *AndroidBootImg) writeSection(s section, filePath string) error {
+	if !boot.hdr.hasSection(s) || boot.hdr.sectionSize(s) == 0 {
+		return fmt.Errorf("%s does not exist in this boot image", s)
+	}
+	return writeFile(filePath, boot.section(s))
+}
+
+// WriteRamdisk writes the ramdisk contained in AndroidBootImg to filepath
 func (boot *AndroidBootImg) WriteRamdisk(filePath string) error {
-	begin := boot.ramdiskOffset
-	end := boot.ramdiskOffset + boot.hdr[ramdiskSize]
-	err := ioutil.WriteFile(filePath, boot.img[begin:end], 0644)
-	return err
+	return writeFile(filePath, boot.Ramdisk())
 }
 
-//WriteKernel writes the kernel contained in AndroidBootImg to filepath
+// WriteKernel writes the kernel contained in AndroidBootImg to filepath
 func (boot *AndroidBootImg) WriteKernel(filePath string) error {
-	begin := boot.kernelOffset
-	end := boot.kernelOffset + boot.hdr[kernelSize]
-	err := ioutil.WriteFile(filePath, boot.img[begin:end], 0644)
-	return err
+	return writeFile(filePath, boot.Kernel())
 }
 
-//WriteSecond writes the second image contained in AndroidBootImg to filepath,
-//as this image is not mandatory it returns error if not found.
+// WriteSecond writes the second image contained in AndroidBootImg to filepath,
+// as this image is not mandatory it returns error if not found.
 func (boot *AndroidBootImg) WriteSecond(filePath string) error {
-	if boot.hdr[secondSize] == 0 {
-		return errors.New("Second size does not exist in this boot image")
+	return boot.writeSection(secondSection, filePath)
+}
+
+// WriteRecoveryDtbo writes the recovery DTBO contained in version 1 and 2
+// images to filePath, it returns error if not found.
+func (boot *AndroidBootImg) WriteRecoveryDtbo(filePath string) error {
+	return boot.writeSection(recoveryDtboSection, filePath)
+}
+
+// WriteDtb writes the DTB contained in version 2 images to filePath, it
+// returns error if not found.
+func (boot *AndroidBootImg) WriteDtb(filePath string) error {
+	return boot.writeSection(dtbSection, filePath)
+}
+
+// WriteSignature writes the boot signature contained in version 4 images
+// to filePath, it returns error if not found.
+func (boot *AndroidBootImg) WriteSignature(filePath string) error {
+	return boot.writeSection(signatureSection, filePath)
+}
+
+// writeFile creates filePath with the contents of r
+func writeFile(filePath string, r io.Reader) (err error) {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
 	}
-	begin := boot.secondOffset
-	end := boot.secondOffset + boot.hdr[secondSize]
-	err := ioutil.WriteFile(filePath, boot.img[begin:end], 0644)
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = io.Copy(f, r)
 	return err
 }
@@ -0,0 +1,219 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type BootImgTestSuite struct {
+	img []byte
+}
+
+var _ = Suite(&BootImgTestSuite{})
+
+func (s *BootImgTestSuite) SetUpTest(c *C) {
+	b := NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.Name = "generic"
+	b.Cmdline = "console=ttyS0 androidboot.hardware=goldfish"
+	// 7.1.2 with a 2016-06 patch level
+	b.OSVersion = (7<<14|1<<7|2)<<11 | 16<<4 | 6
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	s.img = img
+}
+
+func (s *BootImgTestSuite) TestNotABootImage(c *C) {
+	_, err := New([]byte("ANDROID"))
+	c.Check(err, NotNil)
+
+	_, err = New(make([]byte, DefaultPageSize))
+	c.Check(err, NotNil)
+
+	_, err = New(nil)
+	c.Check(err, FitsTypeOf, ErrBadMagic{})
+}
+
+func (s *BootImgTestSuite) TestTruncated(c *C) {
+	// cut in the middle of the header
+	_, err := New(s.img[:100])
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+
+	// the header points past the end of the ramdisk
+	_, err = New(s.img[:2*DefaultPageSize+3])
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+	c.Check(err, ErrorMatches, "ramdisk ends at byte 4103 .*")
+
+	// a kernel size close to what 32 bits can hold
+	s.img[len(BOOT_MAGIC)+3] = 0xff
+	_, err = New(s.img)
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+}
+
+func (s *BootImgTestSuite) TestOpenFile(c *C) {
+	imgPath := filepath.Join(c.MkDir(), "boot.img")
+	c.Assert(ioutil.WriteFile(imgPath, s.img, 0644), IsNil)
+	f, err := os.Open(imgPath)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	boot, err := Open(f, int64(len(s.img)))
+	c.Assert(err, IsNil)
+	c.Check(boot.Kernel().Size(), Equals, int64(len("kernel")))
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, []byte("ramdisk"))
+	c.Check(boot.Second().Size(), Equals, int64(0))
+	c.Check(boot.Dtb().Size(), Equals, int64(0))
+	c.Check(boot.VerifyChecksum(), IsNil)
+}
+
+func (s *BootImgTestSuite) TestInfo(c *C) {
+	boot, err := New(s.img)
+	c.Assert(err, IsNil)
+
+	hdr := boot.Info()
+	c.Check(hdr.KernelSize, Equals, uint32(len("kernel")))
+	c.Check(hdr.RamdiskSize, Equals, uint32(len("ramdisk")))
+	c.Check(hdr.SecondSize, Equals, uint32(0))
+	c.Check(hdr.HeaderVersion, Equals, uint32(0))
+	c.Check(hdr.Name, Equals, "generic")
+	c.Check(hdr.Cmdline, Equals, "console=ttyS0 androidboot.hardware=goldfish")
+	c.Check(hdr.ExtraCmdline, Equals, "")
+	c.Check(hdr.Release(), Equals, "7.1.2")
+	c.Check(hdr.PatchLevel(), Equals, "2016-06")
+}
+
+func (s *BootImgTestSuite) TestNoOSVersion(c *C) {
+	var hdr Header
+	c.Check(hdr.Release(), Equals, "")
+	c.Check(hdr.PatchLevel(), Equals, "")
+}
+
+func (s *BootImgTestSuite) TestVerifyChecksum(c *C) {
+	boot, err := New(s.img)
+	c.Assert(err, IsNil)
+	c.Assert(boot.VerifyChecksum(), IsNil)
+
+	// corrupt the kernel
+	s.img[DefaultPageSize] = 'K'
+	boot, err = New(s.img)
+	c.Assert(err, IsNil)
+	c.Check(boot.VerifyChecksum(), NotNil)
+}
+
+func (s *BootImgTestSuite) TestInvalidPageSize(c *C) {
+	// page_size lives after the magic and seven other header fields
+	s.img[len(BOOT_MAGIC)+7*4] = 0x10
+	_, err := New(s.img)
+	c.Check(err, FitsTypeOf, ErrPageSize{})
+}
+
+func (s *BootImgTestSuite) TestHeaderVersion2(c *C) {
+	b := NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.HeaderVersion = 2
+	b.RecoveryDtbo = []byte("dtbo")
+	b.Dtb = []byte("dtb")
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	hdr := boot.Info()
+	c.Check(hdr.HeaderVersion, Equals, uint32(2))
+	c.Check(hdr.HeaderSize, Equals, uint32(bootHeaderSizeV2))
+	c.Check(hdr.RecoveryDtboSize, Equals, uint32(4))
+	c.Check(hdr.RecoveryDtboOffset, Equals, uint64(3*DefaultPageSize))
+	c.Check(hdr.DtbAddr, Equals, uint64(DefaultBase+DefaultDtbOffset))
+	c.Check(readAll(c, boot.RecoveryDtbo()), DeepEquals, []byte("dtbo"))
+	c.Check(readAll(c, boot.Dtb()), DeepEquals, []byte("dtb"))
+	c.Check(boot.VerifyChecksum(), IsNil)
+
+	c.Assert(boot.ReplaceDtb([]byte("new dtb")), IsNil)
+	c.Check(readAll(c, boot.Dtb()), DeepEquals, []byte("new dtb"))
+	c.Check(readAll(c, boot.RecoveryDtbo()), DeepEquals, []byte("dtbo"))
+	c.Check(boot.VerifyChecksum(), IsNil)
+}
+
+func (s *BootImgTestSuite) TestHeaderVersion1NoDtb(c *C) {
+	b := NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.HeaderVersion = 1
+	b.Dtb = []byte("dtb")
+	_, err := b.Bytes()
+	c.Check(err, NotNil)
+
+	b.Dtb = nil
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	c.Check(boot.Info().RecoveryDtboOffset, Equals, uint64(0))
+	c.Check(boot.ReplaceDtb([]byte("dtb")), NotNil)
+	c.Check(boot.WriteRecoveryDtbo(c.MkDir()+"/dtbo"), NotNil)
+}
+
+func (s *BootImgTestSuite) TestHeaderVersion4(c *C) {
+	b := NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.HeaderVersion = 4
+	b.Cmdline = "console=ttyS0"
+	b.Signature = []byte("signature")
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	c.Check(len(img), Equals, 4*pageSizeV3)
+
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	hdr := boot.Info()
+	c.Check(hdr.HeaderVersion, Equals, uint32(4))
+	c.Check(hdr.PageSize, Equals, uint32(pageSizeV3))
+	c.Check(hdr.HeaderSize, Equals, uint32(bootHeaderSizeV4))
+	c.Check(hdr.Cmdline, Equals, "console=ttyS0")
+	c.Check(readAll(c, boot.Kernel()), DeepEquals, []byte("kernel"))
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, []byte("ramdisk"))
+	c.Check(readAll(c, boot.Signature()), DeepEquals, []byte("signature"))
+	c.Check(boot.VerifyChecksum(), NotNil)
+	c.Check(boot.ReplaceSecond([]byte("second")), NotNil)
+}
+
+func (s *BootImgTestSuite) TestLegacyPaddingIsVersion0(c *C) {
+	// the dt_size of a legacy Qualcomm image
+	binary.LittleEndian.PutUint32(s.img[headerVersionOffset:], 0x1e000)
+	boot, err := New(s.img)
+	c.Assert(err, IsNil)
+	c.Check(boot.Info().HeaderVersion, Equals, uint32(0x1e000))
+	c.Check(boot.Info().Version(), Equals, uint32(0))
+	c.Check(readAll(c, boot.Kernel()), DeepEquals, []byte("kernel"))
+
+	// the padding survives a round trip
+	c.Assert(boot.ReplaceRamdisk([]byte("new")), IsNil)
+	c.Check(boot.Info().HeaderVersion, Equals, uint32(0x1e000))
+}
+
+func readAll(c *C, r io.Reader) []byte {
+	b, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	return b
+}
@@ -0,0 +1,324 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Defaults used by mkbootimg when assembling a boot image
+const (
+	DefaultBase          = 0x10000000
+	DefaultKernelOffset  = 0x00008000
+	DefaultRamdiskOffset = 0x01000000
+	DefaultSecondOffset  = 0x00f00000
+	DefaultTagsOffset    = 0x00000100
+	DefaultDtbOffset     = 0x01f00000
+	DefaultPageSize      = 2048
+)
+
+// Builder holds the pieces required to assemble an android boot image,
+// HeaderVersion selects which of the optional images can be used and is
+// written as is, values above MaxHeaderVersion lay out a version 0 image
+type Builder struct {
+	Kernel, Ramdisk, Second []byte
+	RecoveryDtbo, Dtb       []byte
+	Signature               []byte
+	Cmdline, Name           string
+	Base                    uint32
+	KernelOffset            uint32
+	RamdiskOffset           uint32
+	SecondOffset            uint32
+	TagsOffset              uint32
+	DtbOffset               uint64
+	PageSize                uint32
+	OSVersion               uint32
+	HeaderVersion           uint32
+	// MediaTek headers to wrap the kernel and ramdisk in
+	KernelMtkHeader  *MtkHeader
+	RamdiskMtkHeader *MtkHeader
+	// Trailer is stored as is after the last section
+	Trailer []byte
+}
+
+// NewBuilder returns a Builder for kernel and ramdisk using the same
+// defaults mkbootimg does
+func NewBuilder(kernel, ramdisk []byte) *Builder {
+	return &Builder{
+		Kernel:        kernel,
+		Ramdisk:       ramdisk,
+		Base:          DefaultBase,
+		KernelOffset:  DefaultKernelOffset,
+		RamdiskOffset: DefaultRamdiskOffset,
+		SecondOffset:  DefaultSecondOffset,
+		TagsOffset:    DefaultTagsOffset,
+		DtbOffset:     DefaultDtbOffset,
+		PageSize:      DefaultPageSize,
+	}
+}
+
+// Bytes assembles the boot image described by Builder
+func (b *Builder) Bytes() ([]byte, error) {
+	if len(b.Kernel) == 0 {
+		return nil, errors.New("a kernel is required to create a boot image")
+	}
+
+	hdr := Header{HeaderVersion: b.HeaderVersion, OSVersion: b.OSVersion}
+	if hdr.Version() >= 3 {
+		hdr.PageSize = pageSizeV3
+		hdr.Cmdline = b.Cmdline
+	} else {
+		if err := validPageSize(b.PageSize); err != nil {
+			return nil, err
+		}
+		hdr.KernelAddr = b.Base + b.KernelOffset
+		hdr.RamdiskAddr = b.Base + b.RamdiskOffset
+		hdr.SecondAddr = b.Base + b.SecondOffset
+		hdr.TagsAddr = b.Base + b.TagsOffset
+		hdr.PageSize = b.PageSize
+		hdr.Name = b.Name
+		// mkbootimg spills whatever does not fit in cmdline over to extra_cmdline
+		hdr.Cmdline = b.Cmdline
+		if len(hdr.Cmdline) >= bootArgsSize {
+			hdr.Cmdline, hdr.ExtraCmdline = b.Cmdline[:bootArgsSize-1], b.Cmdline[bootArgsSize-1:]
+		}
+	}
+	if hdr.Version() >= 1 {
+		hdr.HeaderSize = uint32(hdr.size())
+	}
+	if hdr.Version() >= 2 {
+		hdr.DtbAddr = uint64(b.Base) + b.DtbOffset
+	}
+
+	kernel, ramdisk := b.Kernel, b.Ramdisk
+	if b.KernelMtkHeader != nil {
+		kernel = b.KernelMtkHeader.wrap(kernel)
+	}
+	if b.RamdiskMtkHeader != nil {
+		ramdisk = b.RamdiskMtkHeader.wrap(ramdisk)
+	}
+	data := map[section][]byte{
+		kernelSection:       kernel,
+		ramdiskSection:      ramdisk,
+		secondSection:       b.Second,
+		recoveryDtboSection: b.RecoveryDtbo,
+		dtbSection:          b.Dtb,
+		signatureSection:    b.Signature,
+	}
+	for s, d := range data {
+		if len(d) != 0 && !hdr.hasSection(s) {
+			return nil, fmt.Errorf("a %s is not supported by boot image header version %d", s, hdr.Version())
+		}
+	}
+	img, err := pack(hdr, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(img, b.Trailer...), nil
+}
+
+// WriteImage assembles the boot image and writes it to filePath
+func (b *Builder) WriteImage(filePath string) error {
+	img, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, img, 0644)
+}
+
+// ReplaceKernel swaps the kernel in AndroidBootImg leaving everything
+// else in the image untouched
+func (boot *AndroidBootImg) ReplaceKernel(kernel []byte) error {
+	if len(kernel) == 0 {
+		return errors.New("a kernel is required to create a boot image")
+	}
+	return boot.update(kernelSection, kernel)
+}
+
+// ReplaceRamdisk swaps the ramdisk in AndroidBootImg leaving everything
+// else in the image untouched
+func (boot *AndroidBootImg) ReplaceRamdisk(ramdisk []byte) error {
+	return boot.update(ramdiskSection, ramdisk)
+}
+
+// ReplaceSecond swaps the second stage image in AndroidBootImg leaving
+// everything else in the image untouched
+func (boot *AndroidBootImg) ReplaceSecond(second []byte) error {
+	return boot.update(secondSection, second)
+}
+
+// ReplaceRecoveryDtbo swaps the recovery DTBO of a version 1 or 2 image
+// leaving everything else in the image untouched
+func (boot *AndroidBootImg) ReplaceRecoveryDtbo(dtbo []byte) error {
+	return boot.update(recoveryDtboSection, dtbo)
+}
+
+// ReplaceDtb swaps the DTB of a version 2 image leaving everything else
+// in the image untouched
+func (boot *AndroidBootImg) ReplaceDtb(dtb []byte) error {
+	return boot.update(dtbSection, dtb)
+}
+
+// WriteTo writes the complete boot image to w
+func (boot *AndroidBootImg) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(boot.r, 0, boot.size))
+}
+
+// WriteImage writes the complete boot image to filePath
+func (boot *AndroidBootImg) WriteImage(filePath string) error {
+	return writeFile(filePath, io.NewSectionReader(boot.r, 0, boot.size))
+}
+
+// update reassembles the image around the original header replacing s
+// with contents so only the section sizes and the id change
+func (boot *AndroidBootImg) update(s section, contents []byte) error {
+	if !boot.hdr.hasSection(s) {
+		return fmt.Errorf("a %s is not supported by boot image header version %d", s, boot.hdr.Version())
+	}
+	data := make(map[section][]byte)
+	for _, sec := range boot.hdr.sections() {
+		d, err := ioutil.ReadAll(boot.rawSection(sec))
+		if err != nil {
+			return err
+		}
+		data[sec] = d
+	}
+	data[s] = contents
+	if mtk, ok := boot.mtk[s]; ok {
+		data[s] = mtk.wrap(contents)
+	}
+
+	img, err := pack(boot.hdr, data)
+	if err != nil {
+		return err
+	}
+	if img, err = boot.appendTrailer(img); err != nil {
+		return err
+	}
+	updated, err := New(img)
+	if err != nil {
+		return err
+	}
+	*boot = updated
+	return nil
+}
+
+// appendTrailer adds the trailer of boot to img keeping it at the same
+// offset when img did not grow, so the vbmeta an AVB footer points to
+// can still be found
+func (boot *AndroidBootImg) appendTrailer(img []byte) ([]byte, error) {
+	trailer, err := ioutil.ReadAll(boot.Trailer())
+	if err != nil || len(trailer) == 0 {
+		return img, err
+	}
+	oldEnd, newEnd := boot.hdr.imageSize(), uint64(len(img))
+	if newEnd <= oldEnd {
+		img = append(img, make([]byte, oldEnd-newEnd)...)
+		return append(img, trailer...), nil
+	}
+
+	footer, err := boot.AvbFooter()
+	if err != nil {
+		return nil, err
+	}
+	if footer == nil {
+		return append(img, trailer...), nil
+	}
+	// the image can only grow into the padding in front of vbmeta
+	if newEnd > footer.VbmetaOffset {
+		return nil, fmt.Errorf("boot image grew to %d bytes overlapping its AVB metadata at %d", newEnd, footer.VbmetaOffset)
+	}
+	return append(img, trailer[newEnd-oldEnd:]...), nil
+}
+
+// pack sets up the sizes, id and offsets in hdr for data and lays out
+// the resulting image
+func pack(hdr Header, data map[section][]byte) ([]byte, error) {
+	var sections [][]byte
+	var readers []*io.SectionReader
+	for _, s := range hdr.sections() {
+		hdr.setSectionSize(s, uint32(len(data[s])))
+		sections = append(sections, data[s])
+		readers = append(readers, io.NewSectionReader(bytes.NewReader(data[s]), 0, int64(len(data[s]))))
+	}
+	if hdr.Version() < 3 {
+		id, err := computeId(readers...)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Id = [bootIdSize]byte{}
+		copy(hdr.Id[:], id)
+	}
+	if hdr.hasSection(recoveryDtboSection) {
+		hdr.RecoveryDtboOffset = 0
+		if hdr.RecoveryDtboSize != 0 {
+			hdr.RecoveryDtboOffset = hdr.layout()[recoveryDtboSection]
+		}
+	}
+
+	raw, err := hdr.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return assemble(raw, hdr.PageSize, sections...), nil
+}
+
+// computeId calculates the SHA1 mkbootimg stores in the id field, each
+// section is hashed followed by its size
+func computeId(sections ...*io.SectionReader) ([]byte, error) {
+	h := sha1.New()
+	size := make([]byte, 4)
+	for _, section := range sections {
+		if _, err := io.Copy(h, section); err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint32(size, uint32(section.Size()))
+		h.Write(size)
+	}
+	return h.Sum(nil), nil
+}
+
+// assemble lays out hdr and each section starting on a pageSize boundary
+func assemble(hdr []byte, pageSize uint32, sections ...[]byte) []byte {
+	img := make([]byte, alignTo(uint64(len(hdr)), pageSize))
+	copy(img, hdr)
+	for _, section := range sections {
+		padded := make([]byte, alignTo(uint64(len(section)), pageSize))
+		copy(padded, section)
+		img = append(img, padded...)
+	}
+	return img
+}
+
+// alignTo rounds size up to the next multiple of pageSize
+func alignTo(size uint64, pageSize uint32) uint64 {
+	return (size + uint64(pageSize) - 1) / uint64(pageSize) * uint64(pageSize)
+}
+
+func validPageSize(size uint32) error {
+	if size < 2048 || size&(size-1) != 0 {
+		return ErrPageSize{size}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type BuilderTestSuite struct {
+	tmpdir  string
+	kernel  []byte
+	ramdisk []byte
+}
+
+var _ = Suite(&BuilderTestSuite{})
+
+func (s *BuilderTestSuite) SetUpTest(c *C) {
+	s.tmpdir = c.MkDir()
+	s.kernel = bytes.Repeat([]byte("k"), 3000)
+	s.ramdisk = bytes.Repeat([]byte("r"), 100)
+}
+
+func (s *BuilderTestSuite) TestBuildDefaults(c *C) {
+	b := NewBuilder(s.kernel, s.ramdisk)
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	// header page + 2 kernel pages + 1 ramdisk page
+	c.Assert(len(img), Equals, 4*DefaultPageSize)
+
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	hdr := boot.Info()
+	c.Check(hdr.PageSize, Equals, uint32(DefaultPageSize))
+	c.Check(hdr.KernelAddr, Equals, uint32(DefaultBase+DefaultKernelOffset))
+	c.Check(hdr.RamdiskAddr, Equals, uint32(DefaultBase+DefaultRamdiskOffset))
+	c.Check(hdr.TagsAddr, Equals, uint32(DefaultBase+DefaultTagsOffset))
+	c.Check(readAll(c, boot.Kernel()), DeepEquals, s.kernel)
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, s.ramdisk)
+	c.Check(boot.VerifyChecksum(), IsNil)
+}
+
+func (s *BuilderTestSuite) TestBuildSecondAndNames(c *C) {
+	b := NewBuilder(s.kernel, s.ramdisk)
+	b.Second = []byte("second")
+	b.Name = "mako"
+	b.Cmdline = "console=ttyS0"
+	b.PageSize = 4096
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	c.Check(readAll(c, boot.Second()), DeepEquals, []byte("second"))
+	c.Check(boot.Info().Name, Equals, "mako")
+	c.Check(boot.Info().Cmdline, Equals, "console=ttyS0")
+}
+
+func (s *BuilderTestSuite) TestBuildLegacyPadding(c *C) {
+	b := NewBuilder(s.kernel, s.ramdisk)
+	b.HeaderVersion = 0x1e000
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	c.Check(boot.Info().HeaderVersion, Equals, uint32(0x1e000))
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, s.ramdisk)
+}
+
+func (s *BuilderTestSuite) TestBuildLongCmdlineSpills(c *C) {
+	b := NewBuilder(s.kernel, s.ramdisk)
+	b.Cmdline = strings.Repeat("a", bootArgsSize+10)
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	c.Check(len(boot.Info().Cmdline), Equals, bootArgsSize-1)
+	c.Check(boot.Info().ExtraCmdline, Equals, strings.Repeat("a", 11))
+	c.Check(boot.Info().FullCmdline(), Equals, b.Cmdline)
+}
+
+func (s *BuilderTestSuite) TestBuildFailures(c *C) {
+	_, err := NewBuilder(nil, s.ramdisk).Bytes()
+	c.Check(err, NotNil)
+
+	b := NewBuilder(s.kernel, s.ramdisk)
+	b.PageSize = 3000
+	_, err = b.Bytes()
+	c.Check(err, NotNil)
+
+	b = NewBuilder(s.kernel, s.ramdisk)
+	b.Name = strings.Repeat("n", bootNameSize)
+	_, err = b.Bytes()
+	c.Check(err, NotNil)
+}
+
+func (s *BuilderTestSuite) TestReplaceRamdisk(c *C) {
+	b := NewBuilder(s.kernel, s.ramdisk)
+	b.Cmdline = "quiet"
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+
+	ramdisk := bytes.Repeat([]byte("R"), 5000)
+	c.Assert(boot.ReplaceRamdisk(ramdisk), IsNil)
+	c.Check(readAll(c, boot.Kernel()), DeepEquals, s.kernel)
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, ramdisk)
+	c.Check(boot.Info().Cmdline, Equals, "quiet")
+	c.Check(boot.VerifyChecksum(), IsNil)
+
+	imgPath := filepath.Join(s.tmpdir, "boot.img")
+	c.Assert(boot.WriteImage(imgPath), IsNil)
+	written, err := ioutil.ReadFile(imgPath)
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	_, err = boot.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Check(written, DeepEquals, buf.Bytes())
+}
+
+func (s *BuilderTestSuite) TestReplaceKernel(c *C) {
+	img, err := NewBuilder(s.kernel, s.ramdisk).Bytes()
+	c.Assert(err, IsNil)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+
+	c.Assert(boot.ReplaceKernel([]byte("tiny")), IsNil)
+	c.Check(readAll(c, boot.Kernel()), DeepEquals, []byte("tiny"))
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, s.ramdisk)
+	n, err := boot.WriteTo(ioutil.Discard)
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, int64(3*DefaultPageSize))
+
+	c.Check(boot.ReplaceKernel(nil), NotNil)
+}
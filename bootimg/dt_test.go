@@ -0,0 +1,104 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"encoding/binary"
+
+	. "launchpad.net/gocheck"
+)
+
+type DtTestSuite struct{}
+
+var _ = Suite(&DtTestSuite{})
+
+func (s *DtTestSuite) TestQcdtRoundTrip(c *C) {
+	a, b := fakeDtb(100), fakeDtb(3000)
+	b[50] = 1
+	for _, version := range []uint32{1, 2, 3} {
+		dt := &Qcdt{Version: version, PageSize: DefaultPageSize, Entries: []QcdtEntry{
+			{PlatformId: 109, VariantId: 8, SocRev: 0x10000, Dtb: a},
+			{PlatformId: 109, VariantId: 8, SocRev: 0x10001, Dtb: a},
+			{PlatformId: 206, VariantId: 11, SocRev: 0x20000, Dtb: b},
+		}}
+		if version >= 2 {
+			dt.Entries[2].SubtypeId = 3
+		}
+		if version >= 3 {
+			dt.Entries[2].PmicRev = [4]uint32{1, 2, 3, 4}
+		}
+		img, err := dt.Bytes()
+		c.Assert(err, IsNil)
+		// table page + a single copy of a + 2 pages for b
+		c.Check(len(img), Equals, 4*DefaultPageSize)
+		c.Check(IsQcdt(img), Equals, true)
+
+		parsed, err := ParseQcdt(img)
+		c.Assert(err, IsNil)
+		c.Check(parsed, DeepEquals, dt)
+
+		e, ok := parsed.Lookup(206, 11, 0x20000)
+		c.Assert(ok, Equals, true)
+		c.Check(e.Dtb, DeepEquals, b)
+		_, ok = parsed.Lookup(206, 11, 0x10000)
+		c.Check(ok, Equals, false)
+	}
+}
+
+func (s *DtTestSuite) TestQcdtBadImages(c *C) {
+	_, err := ParseQcdt([]byte("ANDROID!"))
+	c.Check(err, FitsTypeOf, ErrBadMagic{})
+
+	dt := &Qcdt{Version: 2, Entries: []QcdtEntry{{Dtb: fakeDtb(100)}}}
+	img, err := dt.Bytes()
+	c.Assert(err, IsNil)
+	_, err = ParseQcdt(img[:2000])
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+	_, err = ParseQcdt(img[:20])
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+
+	binary.LittleEndian.PutUint32(img[4:], 7)
+	_, err = ParseQcdt(img)
+	c.Check(err, FitsTypeOf, ErrHeaderVersion{})
+}
+
+func (s *DtTestSuite) TestDtboRoundTrip(c *C) {
+	a, b := fakeDtb(100), fakeDtb(200)
+	dt := &Dtbo{Version: 1, PageSize: 4096, Entries: []DtboEntry{
+		{Id: 1, Rev: 0, Dtb: a},
+		{Id: 2, Rev: 1, Custom: [4]uint32{0, 5, 6, 7}, Dtb: b},
+		{Id: 3, Rev: 0, Dtb: a},
+	}}
+	img, err := dt.Bytes()
+	c.Assert(err, IsNil)
+	c.Check(len(img), Equals, dtboHeaderSize+3*dtboEntrySize+300)
+	c.Check(IsDtbo(img), Equals, true)
+	c.Check(binary.BigEndian.Uint32(img[4:]), Equals, uint32(len(img)))
+
+	parsed, err := ParseDtbo(img)
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, dt)
+	e, ok := parsed.Lookup(2, 1)
+	c.Assert(ok, Equals, true)
+	c.Check(e.Custom[3], Equals, uint32(7))
+
+	_, err = ParseDtbo(img[:len(img)-1])
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+	_, err = ParseDtbo(a)
+	c.Check(err, FitsTypeOf, ErrBadMagic{})
+}
@@ -0,0 +1,188 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const DTBO_MAGIC = 0xd7b7ab1e
+
+// Sizes of the DTBO table header and entries
+const (
+	dtboHeaderSize = 32
+	dtboEntrySize  = 32
+)
+
+// DtboEntry is a DTB or DT overlay in a DTBO table, in version 1 tables
+// the lower 4 bits of Custom[0] hold the compression of Dtb
+type DtboEntry struct {
+	Id     uint32
+	Rev    uint32
+	Custom [4]uint32
+	Dtb    []byte
+}
+
+// Dtbo is an android device tree table as used in dtbo.img and the
+// recovery DTBO of boot images
+type Dtbo struct {
+	Version  uint32
+	PageSize uint32
+	Entries  []DtboEntry
+}
+
+// dtboHeader is the on disk representation of the table header, unlike
+// the rest of the android images it is big endian
+type dtboHeader struct {
+	Magic         uint32
+	TotalSize     uint32
+	HeaderSize    uint32
+	EntrySize     uint32
+	EntryCount    uint32
+	EntriesOffset uint32
+	PageSize      uint32
+	Version       uint32
+}
+
+type dtboEntry struct {
+	DtSize   uint32
+	DtOffset uint32
+	Id       uint32
+	Rev      uint32
+	Custom   [4]uint32
+}
+
+// IsDtbo returns true if img starts with a DTBO table
+func IsDtbo(img []byte) bool {
+	return len(img) >= 4 && binary.BigEndian.Uint32(img) == DTBO_MAGIC
+}
+
+// ParseDtbo reads the entries in the DTBO table img
+func ParseDtbo(img []byte) (*Dtbo, error) {
+	if !IsDtbo(img) {
+		return nil, ErrBadMagic{"DTBO", fmt.Sprintf("%08x", DTBO_MAGIC)}
+	}
+	var hdr dtboHeader
+	if err := binary.Read(bytes.NewReader(img), binary.BigEndian, &hdr); err != nil {
+		return nil, ErrTruncated{"DTBO header", dtboHeaderSize, int64(len(img))}
+	}
+	if hdr.Version > 1 {
+		return nil, ErrHeaderVersion{"DTBO", hdr.Version}
+	}
+	if hdr.EntrySize < dtboEntrySize {
+		return nil, fmt.Errorf("invalid DTBO entry size %d", hdr.EntrySize)
+	}
+	if uint64(hdr.TotalSize) > uint64(len(img)) {
+		return nil, ErrTruncated{"DTBO table", uint64(hdr.TotalSize), int64(len(img))}
+	}
+	end := uint64(hdr.EntriesOffset) + uint64(hdr.EntryCount)*uint64(hdr.EntrySize)
+	if end > uint64(len(img)) {
+		return nil, ErrTruncated{"DTBO entries", end, int64(len(img))}
+	}
+
+	dt := &Dtbo{Version: hdr.Version, PageSize: hdr.PageSize}
+	for i := uint32(0); i < hdr.EntryCount; i++ {
+		var e dtboEntry
+		offset := int64(hdr.EntriesOffset) + int64(i)*int64(hdr.EntrySize)
+		r := bytes.NewReader(img[offset:])
+		if err := binary.Read(r, binary.BigEndian, &e); err != nil {
+			return nil, err
+		}
+		dtbEnd := uint64(e.DtOffset) + uint64(e.DtSize)
+		if dtbEnd > uint64(len(img)) {
+			return nil, ErrTruncated{fmt.Sprintf("DTBO entry %d", i), dtbEnd, int64(len(img))}
+		}
+		dt.Entries = append(dt.Entries, DtboEntry{
+			Id:     e.Id,
+			Rev:    e.Rev,
+			Custom: e.Custom,
+			Dtb:    img[e.DtOffset:dtbEnd],
+		})
+	}
+	return dt, nil
+}
+
+// Lookup returns the first entry with id and rev
+func (dt *Dtbo) Lookup(id, rev uint32) (*DtboEntry, bool) {
+	for i, e := range dt.Entries {
+		if e.Id == id && e.Rev == rev {
+			return &dt.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Bytes lays out the table the way mkdtimg does, the DTBs follow the
+// entries unpadded and entries sharing the same DTB point to a single
+// copy of it
+func (dt *Dtbo) Bytes() ([]byte, error) {
+	if dt.Version > 1 {
+		return nil, ErrHeaderVersion{"DTBO", dt.Version}
+	}
+	pageSize := dt.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+
+	offset := uint64(dtboHeaderSize + len(dt.Entries)*dtboEntrySize)
+	offsets := make(map[string]uint64)
+	var entries []dtboEntry
+	var dtbs [][]byte
+	for _, e := range dt.Entries {
+		dtbOffset, ok := offsets[string(e.Dtb)]
+		if !ok {
+			dtbOffset = offset
+			offsets[string(e.Dtb)] = offset
+			offset += uint64(len(e.Dtb))
+			dtbs = append(dtbs, e.Dtb)
+		}
+		entries = append(entries, dtboEntry{
+			DtSize:   uint32(len(e.Dtb)),
+			DtOffset: uint32(dtbOffset),
+			Id:       e.Id,
+			Rev:      e.Rev,
+			Custom:   e.Custom,
+		})
+	}
+	if offset > 1<<32-1 {
+		return nil, fmt.Errorf("DTBO table does not fit in %d bytes", uint64(1<<32-1))
+	}
+
+	hdr := dtboHeader{
+		Magic:         DTBO_MAGIC,
+		TotalSize:     uint32(offset),
+		HeaderSize:    dtboHeaderSize,
+		EntrySize:     dtboEntrySize,
+		EntryCount:    uint32(len(entries)),
+		EntriesOffset: dtboHeaderSize,
+		PageSize:      pageSize,
+		Version:       dt.Version,
+	}
+	var buf bytes.Buffer
+	for _, field := range []interface{}{hdr, entries} {
+		if err := binary.Write(&buf, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+	for _, dtb := range dtbs {
+		buf.Write(dtb)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+)
+
+// ErrBadMagic represents an image which does not start with the magic
+// of the kind of image being opened
+type ErrBadMagic struct {
+	kind  string
+	magic string
+}
+
+func (e ErrBadMagic) Error() string {
+	return fmt.Sprintf("this is not an android %s image, %q magic not found", e.kind, e.magic)
+}
+
+// ErrTruncated represents part of an image which would be read past the
+// end of the image
+type ErrTruncated struct {
+	part string
+	end  uint64
+	size int64
+}
+
+func (e ErrTruncated) Error() string {
+	return fmt.Sprintf("%s ends at byte %d but the image is only %d bytes long", e.part, e.end, e.size)
+}
+
+// ErrHeaderVersion represents a header version that cannot be handled
+type ErrHeaderVersion struct {
+	kind    string
+	version uint32
+}
+
+func (e ErrHeaderVersion) Error() string {
+	return fmt.Sprintf("unsupported %s image header version %d", e.kind, e.version)
+}
+
+// ErrPageSize represents a page size that is not a power of two of at
+// least 2048 bytes
+type ErrPageSize struct {
+	pageSize uint32
+}
+
+func (e ErrPageSize) Error() string {
+	return fmt.Sprintf("invalid page size %d", e.pageSize)
+}
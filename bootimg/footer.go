@@ -0,0 +1,317 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	AVB_FOOTER_MAGIC = "AVBf"
+	AVB_MAGIC        = "AVB0"
+	SEANDROID_MAGIC  = "SEANDROIDENFORCE"
+)
+
+// Tags of the vbmeta descriptors
+const (
+	AvbDescriptorProperty = iota
+	AvbDescriptorHashtree
+	AvbDescriptorHash
+	AvbDescriptorKernelCmdline
+	AvbDescriptorChainPartition
+)
+
+const (
+	avbFooterSize       = 64
+	avbVbmetaHeaderSize = 256
+	avbReleaseSize      = 48
+	avbHashAlgSize      = 32
+)
+
+// AvbFooter is found at the end of partitions verified by Android
+// Verified Boot, it points to the vbmeta data describing the image
+type AvbFooter struct {
+	VersionMajor      uint32
+	VersionMinor      uint32
+	OriginalImageSize uint64
+	VbmetaOffset      uint64
+	VbmetaSize        uint64
+	Vbmeta            Vbmeta
+}
+
+// Vbmeta holds the fields of a vbmeta header and its descriptors
+type Vbmeta struct {
+	RequiredLibavbVersionMajor uint32
+	RequiredLibavbVersionMinor uint32
+	AlgorithmType              uint32
+	RollbackIndex              uint64
+	Flags                      uint32
+	ReleaseString              string
+	Descriptors                []AvbDescriptor
+}
+
+// AvbDescriptor is a vbmeta descriptor, the fields that apply to Tag are
+// decoded and Data holds the complete descriptor contents
+type AvbDescriptor struct {
+	Tag           uint64
+	PartitionName string
+	HashAlgorithm string
+	ImageSize     uint64
+	Salt          []byte
+	Digest        []byte
+	Key, Value    string
+	Cmdline       string
+	Data          []byte
+}
+
+type avbFooter struct {
+	Magic             [4]byte
+	VersionMajor      uint32
+	VersionMinor      uint32
+	OriginalImageSize uint64
+	VbmetaOffset      uint64
+	VbmetaSize        uint64
+	Reserved          [28]byte
+}
+
+type avbVbmetaHeader struct {
+	Magic                      [4]byte
+	RequiredLibavbVersionMajor uint32
+	RequiredLibavbVersionMinor uint32
+	AuthenticationDataSize     uint64
+	AuxiliaryDataSize          uint64
+	AlgorithmType              uint32
+	HashOffset                 uint64
+	HashSize                   uint64
+	SignatureOffset            uint64
+	SignatureSize              uint64
+	PublicKeyOffset            uint64
+	PublicKeySize              uint64
+	PublicKeyMetadataOffset    uint64
+	PublicKeyMetadataSize      uint64
+	DescriptorsOffset          uint64
+	DescriptorsSize            uint64
+	RollbackIndex              uint64
+	Flags                      uint32
+	RollbackIndexLocation      uint32
+	ReleaseString              [avbReleaseSize]byte
+	Reserved                   [80]byte
+}
+
+type avbHashDescriptor struct {
+	ImageSize        uint64
+	HashAlgorithm    [avbHashAlgSize]byte
+	PartitionNameLen uint32
+	SaltLen          uint32
+	DigestLen        uint32
+	Flags            uint32
+	Reserved         [60]byte
+}
+
+type avbHashtreeDescriptor struct {
+	DmVerityVersion  uint32
+	ImageSize        uint64
+	TreeOffset       uint64
+	TreeSize         uint64
+	DataBlockSize    uint32
+	HashBlockSize    uint32
+	FecNumRoots      uint32
+	FecOffset        uint64
+	FecSize          uint64
+	HashAlgorithm    [avbHashAlgSize]byte
+	PartitionNameLen uint32
+	SaltLen          uint32
+	RootDigestLen    uint32
+	Flags            uint32
+	Reserved         [60]byte
+}
+
+type avbChainPartitionDescriptor struct {
+	RollbackIndexLocation uint32
+	PartitionNameLen      uint32
+	PublicKeyLen          uint32
+	Flags                 uint32
+	Reserved              [60]byte
+}
+
+// SEAndroid returns true if the image carries the SEAndroid signature
+// Samsung devices check for after the last section
+func (boot *AndroidBootImg) SEAndroid() bool {
+	magic := make([]byte, len(SEANDROID_MAGIC))
+	_, err := boot.Trailer().ReadAt(magic, 0)
+	return err == nil && string(magic) == SEANDROID_MAGIC
+}
+
+// AvbFooter returns the AVB footer at the end of the image and the vbmeta
+// it points to, it returns nil if the image has no footer
+func (boot *AndroidBootImg) AvbFooter() (*AvbFooter, error) {
+	if boot.Trailer().Size() < avbFooterSize {
+		return nil, nil
+	}
+	var raw avbFooter
+	r := io.NewSectionReader(boot.r, boot.size-avbFooterSize, avbFooterSize)
+	if err := binary.Read(r, binary.BigEndian, &raw); err != nil {
+		return nil, err
+	}
+	if string(raw.Magic[:]) != AVB_FOOTER_MAGIC {
+		return nil, nil
+	}
+	footer := &AvbFooter{
+		VersionMajor:      raw.VersionMajor,
+		VersionMinor:      raw.VersionMinor,
+		OriginalImageSize: raw.OriginalImageSize,
+		VbmetaOffset:      raw.VbmetaOffset,
+		VbmetaSize:        raw.VbmetaSize,
+	}
+	if end := raw.VbmetaOffset + raw.VbmetaSize; end < raw.VbmetaOffset || end > uint64(boot.size-avbFooterSize) {
+		return nil, ErrTruncated{"vbmeta", end, boot.size}
+	}
+	vbmeta := make([]byte, raw.VbmetaSize)
+	if _, err := boot.r.ReadAt(vbmeta, int64(raw.VbmetaOffset)); err != nil {
+		return nil, err
+	}
+	var err error
+	if footer.Vbmeta, err = parseVbmeta(vbmeta); err != nil {
+		return nil, err
+	}
+	return footer, nil
+}
+
+// parseVbmeta decodes the vbmeta header in b and its descriptors
+func parseVbmeta(b []byte) (vbmeta Vbmeta, err error) {
+	if len(b) < avbVbmetaHeaderSize || string(b[:len(AVB_MAGIC)]) != AVB_MAGIC {
+		return vbmeta, ErrBadMagic{"vbmeta", AVB_MAGIC}
+	}
+	var hdr avbVbmetaHeader
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &hdr); err != nil {
+		return vbmeta, err
+	}
+	vbmeta = Vbmeta{
+		RequiredLibavbVersionMajor: hdr.RequiredLibavbVersionMajor,
+		RequiredLibavbVersionMinor: hdr.RequiredLibavbVersionMinor,
+		AlgorithmType:              hdr.AlgorithmType,
+		RollbackIndex:              hdr.RollbackIndex,
+		Flags:                      hdr.Flags,
+		ReleaseString:              cString(hdr.ReleaseString[:]),
+	}
+
+	start := avbVbmetaHeaderSize + hdr.AuthenticationDataSize + hdr.DescriptorsOffset
+	end := start + hdr.DescriptorsSize
+	if start < avbVbmetaHeaderSize || end < start || end > uint64(len(b)) {
+		return vbmeta, ErrTruncated{"vbmeta descriptors", end, int64(len(b))}
+	}
+	descriptors := b[start:end]
+	for len(descriptors) > 0 {
+		if len(descriptors) < 16 {
+			return vbmeta, errors.New("truncated vbmeta descriptor")
+		}
+		tag := binary.BigEndian.Uint64(descriptors)
+		n := binary.BigEndian.Uint64(descriptors[8:])
+		if n > uint64(len(descriptors)-16) {
+			return vbmeta, fmt.Errorf("vbmeta descriptor with tag %d is truncated", tag)
+		}
+		d, err := parseAvbDescriptor(tag, descriptors[16:16+n])
+		if err != nil {
+			return vbmeta, err
+		}
+		vbmeta.Descriptors = append(vbmeta.Descriptors, d)
+		descriptors = descriptors[16+n:]
+	}
+	return vbmeta, nil
+}
+
+func parseAvbDescriptor(tag uint64, data []byte) (d AvbDescriptor, err error) {
+	d = AvbDescriptor{Tag: tag, Data: data}
+	r := bytes.NewReader(data)
+	// fields splits the variable length data after the fixed part
+	fields := func(fixed int, sizes ...uint32) ([][]byte, error) {
+		rest := data[fixed:]
+		var out [][]byte
+		for _, size := range sizes {
+			if uint64(size) > uint64(len(rest)) {
+				return nil, fmt.Errorf("vbmeta descriptor with tag %d is truncated", tag)
+			}
+			out = append(out, rest[:size])
+			rest = rest[size:]
+		}
+		return out, nil
+	}
+
+	switch tag {
+	case AvbDescriptorProperty:
+		var sizes struct{ Key, Value uint64 }
+		if err := binary.Read(r, binary.BigEndian, &sizes); err != nil {
+			return d, err
+		}
+		if sizes.Key > uint64(len(data)) || sizes.Value > uint64(len(data)) {
+			return d, errors.New("vbmeta property descriptor is truncated")
+		}
+		f, err := fields(16, uint32(sizes.Key)+1, uint32(sizes.Value))
+		if err != nil {
+			return d, err
+		}
+		d.Key, d.Value = cString(f[0]), string(f[1])
+	case AvbDescriptorHash:
+		var hdr avbHashDescriptor
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return d, err
+		}
+		f, err := fields(binary.Size(hdr), hdr.PartitionNameLen, hdr.SaltLen, hdr.DigestLen)
+		if err != nil {
+			return d, err
+		}
+		d.ImageSize, d.HashAlgorithm = hdr.ImageSize, cString(hdr.HashAlgorithm[:])
+		d.PartitionName, d.Salt, d.Digest = string(f[0]), f[1], f[2]
+	case AvbDescriptorHashtree:
+		var hdr avbHashtreeDescriptor
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return d, err
+		}
+		f, err := fields(binary.Size(hdr), hdr.PartitionNameLen, hdr.SaltLen, hdr.RootDigestLen)
+		if err != nil {
+			return d, err
+		}
+		d.ImageSize, d.HashAlgorithm = hdr.ImageSize, cString(hdr.HashAlgorithm[:])
+		d.PartitionName, d.Salt, d.Digest = string(f[0]), f[1], f[2]
+	case AvbDescriptorKernelCmdline:
+		var hdr struct{ Flags, CmdlineLen uint32 }
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return d, err
+		}
+		f, err := fields(8, hdr.CmdlineLen)
+		if err != nil {
+			return d, err
+		}
+		d.Cmdline = string(f[0])
+	case AvbDescriptorChainPartition:
+		var hdr avbChainPartitionDescriptor
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return d, err
+		}
+		f, err := fields(binary.Size(hdr), hdr.PartitionNameLen)
+		if err != nil {
+			return d, err
+		}
+		d.PartitionName = string(f[0])
+	}
+	return d, nil
+}
@@ -0,0 +1,171 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	. "launchpad.net/gocheck"
+)
+
+type FooterTestSuite struct {
+	img []byte
+}
+
+var _ = Suite(&FooterTestSuite{})
+
+func (s *FooterTestSuite) SetUpTest(c *C) {
+	img, err := NewBuilder([]byte("kernel"), []byte("ramdisk")).Bytes()
+	c.Assert(err, IsNil)
+	s.img = img
+}
+
+func avbDescriptor(c *C, tag uint64, fields ...interface{}) []byte {
+	var body bytes.Buffer
+	for _, f := range fields {
+		c.Assert(binary.Write(&body, binary.BigEndian, f), IsNil)
+	}
+	for body.Len()%8 != 0 {
+		body.WriteByte(0)
+	}
+	var d bytes.Buffer
+	binary.Write(&d, binary.BigEndian, []uint64{tag, uint64(body.Len())})
+	d.Write(body.Bytes())
+	return d.Bytes()
+}
+
+// avbImage appends vbmeta and an AVB footer to img the way avbtool
+// add_hash_footer lays them out in a partitionSize partition
+func (s *FooterTestSuite) avbImage(c *C, img []byte, partitionSize int) []byte {
+	hash := avbHashDescriptor{ImageSize: uint64(len(img)), PartitionNameLen: 4, SaltLen: 2, DigestLen: 3}
+	copy(hash.HashAlgorithm[:], "sha256")
+	descriptors := append(
+		avbDescriptor(c, AvbDescriptorHash, hash, []byte("boot"), []byte{1, 2}, []byte{3, 4, 5}),
+		avbDescriptor(c, AvbDescriptorProperty, []uint64{3, 5}, []byte("key\x00value\x00"))...)
+
+	hdr := avbVbmetaHeader{
+		RequiredLibavbVersionMajor: 1,
+		AuxiliaryDataSize:          uint64(len(descriptors)),
+		DescriptorsSize:            uint64(len(descriptors)),
+		RollbackIndex:              7,
+	}
+	copy(hdr.Magic[:], AVB_MAGIC)
+	copy(hdr.ReleaseString[:], "avbtool 1.1.0")
+	var vbmeta bytes.Buffer
+	c.Assert(binary.Write(&vbmeta, binary.BigEndian, hdr), IsNil)
+	vbmeta.Write(descriptors)
+
+	out := append([]byte(nil), img...)
+	out = append(out, vbmeta.Bytes()...)
+	out = append(out, make([]byte, partitionSize-len(out)-avbFooterSize)...)
+	footer := avbFooter{
+		VersionMajor:      1,
+		OriginalImageSize: uint64(len(img)),
+		VbmetaOffset:      uint64(len(img)),
+		VbmetaSize:        uint64(vbmeta.Len()),
+	}
+	copy(footer.Magic[:], AVB_FOOTER_MAGIC)
+	var buf bytes.Buffer
+	c.Assert(binary.Write(&buf, binary.BigEndian, footer), IsNil)
+	return append(out, buf.Bytes()...)
+}
+
+func (s *FooterTestSuite) TestNoFooter(c *C) {
+	boot, err := New(s.img)
+	c.Assert(err, IsNil)
+	c.Check(boot.Trailer().Size(), Equals, int64(0))
+	c.Check(boot.SEAndroid(), Equals, false)
+	footer, err := boot.AvbFooter()
+	c.Check(err, IsNil)
+	c.Check(footer, IsNil)
+}
+
+func (s *FooterTestSuite) TestAvbFooter(c *C) {
+	img := s.avbImage(c, s.img, 8*DefaultPageSize)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	c.Check(boot.Trailer().Size(), Equals, int64(5*DefaultPageSize))
+
+	footer, err := boot.AvbFooter()
+	c.Assert(err, IsNil)
+	c.Assert(footer, NotNil)
+	c.Check(footer.OriginalImageSize, Equals, uint64(len(s.img)))
+	c.Check(footer.VbmetaOffset, Equals, uint64(len(s.img)))
+	c.Check(footer.Vbmeta.RequiredLibavbVersionMajor, Equals, uint32(1))
+	c.Check(footer.Vbmeta.RollbackIndex, Equals, uint64(7))
+	c.Check(footer.Vbmeta.ReleaseString, Equals, "avbtool 1.1.0")
+	c.Assert(footer.Vbmeta.Descriptors, HasLen, 2)
+	hash := footer.Vbmeta.Descriptors[0]
+	c.Check(hash.Tag, Equals, uint64(AvbDescriptorHash))
+	c.Check(hash.PartitionName, Equals, "boot")
+	c.Check(hash.HashAlgorithm, Equals, "sha256")
+	c.Check(hash.ImageSize, Equals, uint64(len(s.img)))
+	c.Check(hash.Salt, DeepEquals, []byte{1, 2})
+	c.Check(hash.Digest, DeepEquals, []byte{3, 4, 5})
+	prop := footer.Vbmeta.Descriptors[1]
+	c.Check(prop.Key, Equals, "key")
+	c.Check(prop.Value, Equals, "value")
+
+	// corrupt the vbmeta size
+	img[len(img)-avbFooterSize+28] = 0xff
+	boot, err = New(img)
+	c.Assert(err, IsNil)
+	_, err = boot.AvbFooter()
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+}
+
+func (s *FooterTestSuite) TestAvbUpdateKeepsLayout(c *C) {
+	img := s.avbImage(c, s.img, 8*DefaultPageSize)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+
+	// shrinking keeps everything in place
+	c.Assert(boot.ReplaceRamdisk([]byte("r")), IsNil)
+	c.Check(boot.Trailer().Size(), Equals, int64(5*DefaultPageSize))
+	footer, err := boot.AvbFooter()
+	c.Assert(err, IsNil)
+	c.Assert(footer, NotNil)
+	c.Check(footer.Vbmeta.Descriptors, HasLen, 2)
+
+	// growing into vbmeta is refused
+	c.Check(boot.ReplaceRamdisk(make([]byte, 2*DefaultPageSize)), NotNil)
+}
+
+func (s *FooterTestSuite) TestSEAndroid(c *C) {
+	img := append(s.img, SEANDROID_MAGIC...)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	c.Check(boot.SEAndroid(), Equals, true)
+
+	// the signature follows the resized image
+	c.Assert(boot.ReplaceKernel(make([]byte, 3*DefaultPageSize)), IsNil)
+	c.Check(boot.SEAndroid(), Equals, true)
+	c.Check(readAll(c, boot.Trailer()), DeepEquals, []byte(SEANDROID_MAGIC))
+	c.Check(boot.VerifyChecksum(), IsNil)
+
+	// and survives a repack
+	b := NewBuilder(readAll(c, boot.Kernel()), readAll(c, boot.Ramdisk()))
+	b.Trailer = readAll(c, boot.Trailer())
+	repacked, err := b.Bytes()
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	_, err = boot.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(repacked, buf.Bytes()), Equals, true)
+}
@@ -0,0 +1,331 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// MaxHeaderVersion is the newest boot image header version this package
+// knows how to handle
+const MaxHeaderVersion = 4
+
+// Sizes of the boot image header fields
+const (
+	bootNameSize      = 16
+	bootArgsSize      = 512
+	bootExtraArgsSize = 1024
+	bootIdSize        = 32
+	bootArgsSizeV3    = bootArgsSize + bootExtraArgsSize
+)
+
+// Sizes of each of the boot image header versions
+const (
+	bootHeaderSize   = 1632
+	bootHeaderSizeV1 = bootHeaderSize + 16
+	bootHeaderSizeV2 = bootHeaderSizeV1 + 12
+	bootHeaderSizeV3 = 1580
+	bootHeaderSizeV4 = bootHeaderSizeV3 + 4
+	// the page size is fixed starting with version 3
+	pageSizeV3 = 4096
+)
+
+// headerVersionOffset is where header_version sits for every version
+const headerVersionOffset = 40
+
+// Header holds the fields of an android boot image header, the fields
+// that are not part of HeaderVersion are left empty
+type Header struct {
+	KernelSize    uint32
+	KernelAddr    uint32
+	RamdiskSize   uint32
+	RamdiskAddr   uint32
+	SecondSize    uint32
+	SecondAddr    uint32
+	TagsAddr      uint32
+	PageSize      uint32
+	HeaderVersion uint32
+	OSVersion     uint32
+	Name          string
+	Cmdline       string
+	Id            [bootIdSize]byte
+	ExtraCmdline  string
+	// Version 1
+	RecoveryDtboSize   uint32
+	RecoveryDtboOffset uint64
+	HeaderSize         uint32
+	// Version 2
+	DtbSize uint32
+	DtbAddr uint64
+	// Version 4
+	SignatureSize uint32
+}
+
+// bootImgHdr is the on disk representation of a version 0 Header
+type bootImgHdr struct {
+	Magic         [8]byte
+	KernelSize    uint32
+	KernelAddr    uint32
+	RamdiskSize   uint32
+	RamdiskAddr   uint32
+	SecondSize    uint32
+	SecondAddr    uint32
+	TagsAddr      uint32
+	PageSize      uint32
+	HeaderVersion uint32
+	OSVersion     uint32
+	Name          [bootNameSize]byte
+	Cmdline       [bootArgsSize]byte
+	Id            [bootIdSize]byte
+	ExtraCmdline  [bootExtraArgsSize]byte
+}
+
+// bootImgHdrV1 follows bootImgHdr in version 1 and 2 headers
+type bootImgHdrV1 struct {
+	RecoveryDtboSize   uint32
+	RecoveryDtboOffset uint64
+	HeaderSize         uint32
+}
+
+// bootImgHdrV2 follows bootImgHdrV1 in version 2 headers
+type bootImgHdrV2 struct {
+	DtbSize uint32
+	DtbAddr uint64
+}
+
+// bootImgHdrV3 is the on disk representation of a version 3 Header, the
+// load addresses and page size moved to the vendor_boot image
+type bootImgHdrV3 struct {
+	Magic         [8]byte
+	KernelSize    uint32
+	RamdiskSize   uint32
+	OSVersion     uint32
+	HeaderSize    uint32
+	Reserved      [4]uint32
+	HeaderVersion uint32
+	Cmdline       [bootArgsSizeV3]byte
+}
+
+// bootImgHdrV4 follows bootImgHdrV3 in version 4 headers
+type bootImgHdrV4 struct {
+	SignatureSize uint32
+}
+
+// Version returns the header version used to lay out the image, the
+// field used to be unused padding, legacy Qualcomm images keep the size
+// of their QCDT there, so values that are not a known version are
+// treated as version 0
+func (hdr Header) Version() uint32 {
+	if hdr.HeaderVersion > MaxHeaderVersion {
+		return 0
+	}
+	return hdr.HeaderVersion
+}
+
+// size returns the amount of bytes the header takes in the image
+func (hdr Header) size() int {
+	switch hdr.Version() {
+	case 1:
+		return bootHeaderSizeV1
+	case 2:
+		return bootHeaderSizeV2
+	case 3:
+		return bootHeaderSizeV3
+	case 4:
+		return bootHeaderSizeV4
+	}
+	return bootHeaderSize
+}
+
+// FullCmdline returns the kernel cmdline including the extra cmdline
+func (hdr Header) FullCmdline() string {
+	return hdr.Cmdline + hdr.ExtraCmdline
+}
+
+// Release returns the android release encoded in OSVersion, e.g.; 7.1.2
+func (hdr Header) Release() string {
+	if hdr.OSVersion == 0 {
+		return ""
+	}
+	v := hdr.OSVersion >> 11
+	return fmt.Sprintf("%d.%d.%d", v>>14&0x7f, v>>7&0x7f, v&0x7f)
+}
+
+// PatchLevel returns the security patch level encoded in OSVersion, e.g.;
+// 2016-06
+func (hdr Header) PatchLevel() string {
+	if hdr.OSVersion == 0 {
+		return ""
+	}
+	p := hdr.OSVersion & 0x7ff
+	return fmt.Sprintf("%d-%02d", 2000+p>>4, p&0xf)
+}
+
+// parseHeader decodes the header at the start of img according to the
+// version it declares
+func parseHeader(img []byte) (hdr Header, err error) {
+	if len(img) < headerVersionOffset+4 {
+		return hdr, ErrTruncated{"boot image header", headerVersionOffset + 4, int64(len(img))}
+	}
+	hdr.HeaderVersion = binary.LittleEndian.Uint32(img[headerVersionOffset:])
+	if len(img) < hdr.size() {
+		return hdr, ErrTruncated{fmt.Sprintf("version %d header", hdr.Version()), uint64(hdr.size()), int64(len(img))}
+	}
+
+	r := bytes.NewReader(img)
+	if hdr.Version() >= 3 {
+		var raw bootImgHdrV3
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			return hdr, err
+		}
+		hdr = Header{
+			KernelSize:    raw.KernelSize,
+			RamdiskSize:   raw.RamdiskSize,
+			OSVersion:     raw.OSVersion,
+			HeaderSize:    raw.HeaderSize,
+			HeaderVersion: raw.HeaderVersion,
+			PageSize:      pageSizeV3,
+			Cmdline:       cString(raw.Cmdline[:]),
+		}
+		if hdr.Version() >= 4 {
+			var v4 bootImgHdrV4
+			if err := binary.Read(r, binary.LittleEndian, &v4); err != nil {
+				return hdr, err
+			}
+			hdr.SignatureSize = v4.SignatureSize
+		}
+		return hdr, nil
+	}
+
+	var raw bootImgHdr
+	if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+		return hdr, err
+	}
+	hdr = Header{
+		KernelSize:    raw.KernelSize,
+		KernelAddr:    raw.KernelAddr,
+		RamdiskSize:   raw.RamdiskSize,
+		RamdiskAddr:   raw.RamdiskAddr,
+		SecondSize:    raw.SecondSize,
+		SecondAddr:    raw.SecondAddr,
+		TagsAddr:      raw.TagsAddr,
+		PageSize:      raw.PageSize,
+		HeaderVersion: raw.HeaderVersion,
+		OSVersion:     raw.OSVersion,
+		Name:          cString(raw.Name[:]),
+		Cmdline:       cString(raw.Cmdline[:]),
+		Id:            raw.Id,
+		ExtraCmdline:  cString(raw.ExtraCmdline[:]),
+	}
+	if hdr.Version() >= 1 {
+		var v1 bootImgHdrV1
+		if err := binary.Read(r, binary.LittleEndian, &v1); err != nil {
+			return hdr, err
+		}
+		hdr.RecoveryDtboSize = v1.RecoveryDtboSize
+		hdr.RecoveryDtboOffset = v1.RecoveryDtboOffset
+		hdr.HeaderSize = v1.HeaderSize
+	}
+	if hdr.Version() >= 2 {
+		var v2 bootImgHdrV2
+		if err := binary.Read(r, binary.LittleEndian, &v2); err != nil {
+			return hdr, err
+		}
+		hdr.DtbSize = v2.DtbSize
+		hdr.DtbAddr = v2.DtbAddr
+	}
+	return hdr, nil
+}
+
+// bytes encodes hdr the way it is laid out in the image
+func (hdr Header) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	var fields []interface{}
+
+	if hdr.Version() >= 3 {
+		cmdline := hdr.FullCmdline()
+		if len(cmdline) >= bootArgsSizeV3 {
+			return nil, fmt.Errorf("kernel cmdline is longer than %d characters", bootArgsSizeV3-1)
+		}
+		raw := bootImgHdrV3{
+			KernelSize:    hdr.KernelSize,
+			RamdiskSize:   hdr.RamdiskSize,
+			OSVersion:     hdr.OSVersion,
+			HeaderSize:    hdr.HeaderSize,
+			HeaderVersion: hdr.HeaderVersion,
+		}
+		copy(raw.Magic[:], BOOT_MAGIC)
+		copy(raw.Cmdline[:], cmdline)
+		fields = append(fields, raw)
+		if hdr.Version() >= 4 {
+			fields = append(fields, bootImgHdrV4{SignatureSize: hdr.SignatureSize})
+		}
+	} else {
+		if len(hdr.Name) >= bootNameSize {
+			return nil, fmt.Errorf("board name %q is longer than %d characters", hdr.Name, bootNameSize-1)
+		}
+		if len(hdr.Cmdline) >= bootArgsSize || len(hdr.ExtraCmdline) >= bootExtraArgsSize {
+			return nil, fmt.Errorf("kernel cmdline is longer than %d characters", bootArgsSize+bootExtraArgsSize-2)
+		}
+		raw := bootImgHdr{
+			KernelSize:    hdr.KernelSize,
+			KernelAddr:    hdr.KernelAddr,
+			RamdiskSize:   hdr.RamdiskSize,
+			RamdiskAddr:   hdr.RamdiskAddr,
+			SecondSize:    hdr.SecondSize,
+			SecondAddr:    hdr.SecondAddr,
+			TagsAddr:      hdr.TagsAddr,
+			PageSize:      hdr.PageSize,
+			HeaderVersion: hdr.HeaderVersion,
+			OSVersion:     hdr.OSVersion,
+			Id:            hdr.Id,
+		}
+		copy(raw.Magic[:], BOOT_MAGIC)
+		copy(raw.Name[:], hdr.Name)
+		copy(raw.Cmdline[:], hdr.Cmdline)
+		copy(raw.ExtraCmdline[:], hdr.ExtraCmdline)
+		fields = append(fields, raw)
+		if hdr.Version() >= 1 {
+			fields = append(fields, bootImgHdrV1{
+				RecoveryDtboSize:   hdr.RecoveryDtboSize,
+				RecoveryDtboOffset: hdr.RecoveryDtboOffset,
+				HeaderSize:         hdr.HeaderSize,
+			})
+		}
+		if hdr.Version() >= 2 {
+			fields = append(fields, bootImgHdrV2{DtbSize: hdr.DtbSize, DtbAddr: hdr.DtbAddr})
+		}
+	}
+
+	for _, field := range fields {
+		if err := binary.Write(&buf, binary.LittleEndian, field); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// cString returns the contents of a NUL terminated C string
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -0,0 +1,313 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+)
+
+// KernelFormat is the kind of kernel payload found in a boot image
+type KernelFormat int
+
+const (
+	KernelUnknown KernelFormat = iota
+	KernelZImage
+	KernelBzImage
+	KernelImage
+	KernelGzip
+	KernelLz4
+)
+
+var kernelFormatNames = map[KernelFormat]string{
+	KernelUnknown: "unknown",
+	KernelZImage:  "zImage",
+	KernelBzImage: "bzImage",
+	KernelImage:   "Image",
+	KernelGzip:    "gzip",
+	KernelLz4:     "lz4",
+}
+
+func (f KernelFormat) String() string {
+	return kernelFormatNames[f]
+}
+
+var (
+	gzipMagic      = []byte{0x1f, 0x8b, 0x08}
+	lz4LegacyMagic = []byte{0x02, 0x21, 0x4c, 0x18}
+	fdtMagic       = []byte{0xd0, 0x0d, 0xfe, 0xed}
+	bannerPrefix   = []byte("Linux version ")
+)
+
+// Offsets of the fields used to identify each kernel format
+const (
+	zImageMagicOffset  = 0x24
+	zImageMagic        = 0x016f2818
+	zImageStartOffset  = 0x28
+	zImageEndOffset    = 0x2c
+	bzImageMagicOffset = 0x202
+	bzImageMagic       = "HdrS"
+	arm64MagicOffset   = 0x38
+	arm64Magic         = "ARM\x64"
+	fdtHeaderSize      = 40
+	maxBannerSize      = 1024
+	// the legacy lz4 format used by the kernel has blocks of 8MiB
+	lz4LegacyBlockSize = 8 << 20
+)
+
+// KernelInfo describes the kernel payload of a boot image, Size is the
+// size of the kernel itself without the DTBs appended after it
+type KernelInfo struct {
+	Format  KernelFormat
+	Version string
+	Size    int
+	Dtbs    [][]byte
+}
+
+// KernelInfo looks into the kernel contained in AndroidBootImg
+func (boot *AndroidBootImg) KernelInfo() (KernelInfo, error) {
+	kernel, err := ioutil.ReadAll(boot.Kernel())
+	if err != nil {
+		return KernelInfo{}, err
+	}
+	return ParseKernel(kernel), nil
+}
+
+// ParseKernel identifies the format of kernel, extracts its version
+// banner and splits off any DTBs appended to it
+func ParseKernel(kernel []byte) (info KernelInfo) {
+	info.Format = kernelFormat(kernel)
+
+	start := 0
+	if info.Format == KernelZImage {
+		// zImage records its own size as it needs to find appended DTBs too
+		begin := binary.LittleEndian.Uint32(kernel[zImageStartOffset:])
+		end := binary.LittleEndian.Uint32(kernel[zImageEndOffset:])
+		if end > begin && end-begin <= uint32(len(kernel)) {
+			start = int(end - begin)
+		}
+	}
+	info.Size, info.Dtbs = splitDtbs(kernel, start)
+	kernel = kernel[:info.Size]
+
+	switch info.Format {
+	case KernelGzip:
+		info.Version = findBanner(gunzip(kernel))
+	case KernelLz4:
+		info.Version = findBanner(unlz4(kernel))
+	default:
+		if info.Version = findBanner(kernel); info.Version == "" {
+			info.Version = findCompressedBanner(kernel)
+		}
+	}
+	return info
+}
+
+func kernelFormat(kernel []byte) KernelFormat {
+	switch {
+	case len(kernel) >= zImageEndOffset+4 && binary.LittleEndian.Uint32(kernel[zImageMagicOffset:]) == zImageMagic:
+		return KernelZImage
+	case len(kernel) >= bzImageMagicOffset+4 && string(kernel[bzImageMagicOffset:bzImageMagicOffset+4]) == bzImageMagic:
+		return KernelBzImage
+	case len(kernel) >= arm64MagicOffset+4 && string(kernel[arm64MagicOffset:arm64MagicOffset+4]) == arm64Magic:
+		return KernelImage
+	case bytes.HasPrefix(kernel, gzipMagic):
+		return KernelGzip
+	case bytes.HasPrefix(kernel, lz4LegacyMagic):
+		return KernelLz4
+	}
+	return KernelUnknown
+}
+
+// splitDtbs looks for flattened device trees starting at start, the
+// first one found marks the end of the kernel
+func splitDtbs(kernel []byte, start int) (size int, dtbs [][]byte) {
+	size = len(kernel)
+	for i := start; i < len(kernel); {
+		j := bytes.Index(kernel[i:], fdtMagic)
+		if j < 0 {
+			break
+		}
+		i += j
+		n := dtbSize(kernel[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		if dtbs == nil {
+			size = i
+		}
+		dtbs = append(dtbs, kernel[i:i+n])
+		i += n
+	}
+	return size, dtbs
+}
+
+// dtbSize returns the size of the DTB at the start of b or 0 if the
+// header does not look like a valid one
+func dtbSize(b []byte) int {
+	if len(b) < fdtHeaderSize || !bytes.HasPrefix(b, fdtMagic) {
+		return 0
+	}
+	size := binary.BigEndian.Uint32(b[4:])
+	version := binary.BigEndian.Uint32(b[20:])
+	if size < fdtHeaderSize || uint64(size) > uint64(len(b)) || version < 16 || version > 17 {
+		return 0
+	}
+	return int(size)
+}
+
+// findBanner returns the "Linux version" line found in data
+func findBanner(data []byte) string {
+	i := bytes.Index(data, bannerPrefix)
+	if i < 0 {
+		return ""
+	}
+	banner := data[i:]
+	if len(banner) > maxBannerSize {
+		banner = banner[:maxBannerSize]
+	}
+	if end := bytes.IndexAny(banner, "\n\x00"); end >= 0 {
+		banner = banner[:end]
+	}
+	return strings.TrimSpace(string(banner))
+}
+
+// findCompressedBanner looks for the banner in the compressed payloads
+// self decompressing kernels carry
+func findCompressedBanner(kernel []byte) string {
+	for i := 0; i < len(kernel); i++ {
+		var banner string
+		switch {
+		case bytes.HasPrefix(kernel[i:], gzipMagic):
+			banner = findBanner(gunzip(kernel[i:]))
+		case bytes.HasPrefix(kernel[i:], lz4LegacyMagic):
+			banner = findBanner(unlz4(kernel[i:]))
+		default:
+			continue
+		}
+		if banner != "" {
+			return banner
+		}
+	}
+	return ""
+}
+
+// gunzip returns as much as could be decompressed from the gzip stream
+// at the start of data, trailing data is ignored
+func gunzip(data []byte) []byte {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	r.Multistream(false)
+	out, _ := ioutil.ReadAll(r)
+	return out
+}
+
+// unlz4 returns as much as could be decompressed from the legacy lz4
+// stream at the start of data, the stream ends with the data, with a new
+// stream or with anything that does not look like an lz4 block
+func unlz4(data []byte) []byte {
+	if !bytes.HasPrefix(data, lz4LegacyMagic) {
+		return nil
+	}
+	var out []byte
+	for data = data[len(lz4LegacyMagic):]; len(data) >= 4; {
+		size := binary.LittleEndian.Uint32(data)
+		if size == binary.LittleEndian.Uint32(lz4LegacyMagic) {
+			data = data[4:]
+			continue
+		}
+		if size == 0 || uint64(size) > uint64(len(data)-4) {
+			break
+		}
+		block, ok := lz4Block(data[4:4+size], lz4LegacyBlockSize)
+		out = append(out, block...)
+		if !ok {
+			break
+		}
+		data = data[4+size:]
+	}
+	return out
+}
+
+// lz4Block decompresses an lz4 block of up to max bytes, ok is false if the
+// block is corrupt in which case what was decompressed up to that point is
+// returned
+func lz4Block(src []byte, max int) (dst []byte, ok bool) {
+	// length reads the extra bytes of a literal or match length
+	length := func(n int) (int, bool) {
+		for {
+			if len(src) == 0 {
+				return 0, false
+			}
+			b := src[0]
+			src = src[1:]
+			n += int(b)
+			if b != 255 {
+				return n, true
+			}
+		}
+	}
+	for len(src) > 0 {
+		token := src[0]
+		src = src[1:]
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			if literals, ok = length(literals); !ok {
+				return dst, false
+			}
+		}
+		if literals > len(src) || len(dst)+literals > max {
+			return dst, false
+		}
+		dst = append(dst, src[:literals]...)
+		src = src[literals:]
+		// the last sequence only holds literals
+		if len(src) == 0 {
+			return dst, true
+		}
+
+		if len(src) < 2 {
+			return dst, false
+		}
+		offset := int(binary.LittleEndian.Uint16(src))
+		src = src[2:]
+		match := int(token & 0xf)
+		if match == 15 {
+			if match, ok = length(match); !ok {
+				return dst, false
+			}
+		}
+		match += 4
+		if offset == 0 || offset > len(dst) || len(dst)+match > max {
+			return dst, false
+		}
+		// matches may overlap what they are copying
+		for start := len(dst) - offset; match > 0; match-- {
+			dst = append(dst, dst[start])
+			start++
+		}
+	}
+	return dst, true
+}
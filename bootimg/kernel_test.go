@@ -0,0 +1,154 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+
+	. "launchpad.net/gocheck"
+)
+
+type KernelTestSuite struct{}
+
+var _ = Suite(&KernelTestSuite{})
+
+const testBanner = "Linux version 3.4.0-5-goldfish (buildd@lcy01-05) (gcc version 4.8 (Ubuntu 4.8.2-19ubuntu1) ) #1 PREEMPT"
+
+func fakeDtb(size int) []byte {
+	dtb := make([]byte, size)
+	copy(dtb, fdtMagic)
+	binary.BigEndian.PutUint32(dtb[4:], uint32(size))
+	binary.BigEndian.PutUint32(dtb[20:], 17)
+	return dtb
+}
+
+func gzipped(c *C, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	return buf.Bytes()
+}
+
+// lz4Legacy wraps data in a legacy lz4 stream of literal only blocks
+func lz4Legacy(data []byte) []byte {
+	block := []byte{0xf0}
+	n := len(data) - 15
+	for ; n >= 255; n -= 255 {
+		block = append(block, 255)
+	}
+	block = append(block, byte(n))
+	block = append(block, data...)
+
+	stream := append([]byte{}, lz4LegacyMagic...)
+	stream = append(stream, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(stream[len(lz4LegacyMagic):], uint32(len(block)))
+	return append(stream, block...)
+}
+
+func (s *KernelTestSuite) TestImageWithDtbs(c *C) {
+	kernel := make([]byte, 0x40)
+	copy(kernel[arm64MagicOffset:], arm64Magic)
+	kernel = append(kernel, []byte("\x00"+testBanner+"\n\x00")...)
+	size := len(kernel)
+	kernel = append(kernel, fakeDtb(64)...)
+	kernel = append(kernel, fakeDtb(48)...)
+
+	info := ParseKernel(kernel)
+	c.Check(info.Format, Equals, KernelImage)
+	c.Check(info.Version, Equals, testBanner)
+	c.Check(info.Size, Equals, size)
+	c.Assert(info.Dtbs, HasLen, 2)
+	c.Check(info.Dtbs[0], DeepEquals, fakeDtb(64))
+	c.Check(info.Dtbs[1], DeepEquals, fakeDtb(48))
+}
+
+func (s *KernelTestSuite) TestGzip(c *C) {
+	kernel := gzipped(c, []byte("padding "+testBanner+"\n"))
+	info := ParseKernel(kernel)
+	c.Check(info.Format, Equals, KernelGzip)
+	c.Check(info.Version, Equals, testBanner)
+	c.Check(info.Dtbs, HasLen, 0)
+}
+
+func (s *KernelTestSuite) TestLz4(c *C) {
+	padding := bytes.Repeat([]byte{0}, 300)
+	kernel := lz4Legacy(append(padding, []byte(testBanner+"\n")...))
+	// the size of the uncompressed kernel trails the stream
+	kernel = append(kernel, 0xff, 0xff, 0xff, 0x7f)
+	info := ParseKernel(kernel)
+	c.Check(info.Format, Equals, KernelLz4)
+	c.Check(info.Version, Equals, testBanner)
+}
+
+func (s *KernelTestSuite) TestLz4Block(c *C) {
+	// "abc" followed by a 9 byte match 3 bytes back and a last literal
+	block := []byte{0x35, 'a', 'b', 'c', 3, 0, 0x10, '!'}
+	out, ok := lz4Block(block, 100)
+	c.Check(ok, Equals, true)
+	c.Check(string(out), Equals, "abcabcabcabc!")
+
+	// matches cannot go further back than the output or past max
+	out, ok = lz4Block([]byte{0x35, 'a', 'b', 'c', 4, 0, 0x10, '!'}, 100)
+	c.Check(ok, Equals, false)
+	c.Check(string(out), Equals, "abc")
+	_, ok = lz4Block(block, 10)
+	c.Check(ok, Equals, false)
+}
+
+func (s *KernelTestSuite) TestZImage(c *C) {
+	kernel := make([]byte, 0x30)
+	binary.LittleEndian.PutUint32(kernel[zImageMagicOffset:], zImageMagic)
+	// a fake DTB inside the zImage must not be split off
+	kernel = append(kernel, fakeDtb(40)...)
+	kernel = append(kernel, gzipped(c, []byte(testBanner+"\n"))...)
+	binary.LittleEndian.PutUint32(kernel[zImageEndOffset:], uint32(len(kernel)))
+	size := len(kernel)
+	kernel = append(kernel, fakeDtb(64)...)
+
+	info := ParseKernel(kernel)
+	c.Check(info.Format, Equals, KernelZImage)
+	c.Check(info.Version, Equals, testBanner)
+	c.Check(info.Size, Equals, size)
+	c.Check(info.Dtbs, HasLen, 1)
+}
+
+func (s *KernelTestSuite) TestUnknown(c *C) {
+	// a corrupt DTB header is not split off
+	dtb := fakeDtb(64)
+	binary.BigEndian.PutUint32(dtb[4:], 1000)
+	info := ParseKernel(append([]byte("kernel"), dtb...))
+	c.Check(info.Format, Equals, KernelUnknown)
+	c.Check(info.Version, Equals, "")
+	c.Check(info.Size, Equals, len("kernel")+64)
+	c.Check(info.Dtbs, HasLen, 0)
+}
+
+func (s *KernelTestSuite) TestBootImgKernelInfo(c *C) {
+	img, err := NewBuilder(gzipped(c, []byte(testBanner+"\n")), []byte("ramdisk")).Bytes()
+	c.Assert(err, IsNil)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	info, err := boot.KernelInfo()
+	c.Assert(err, IsNil)
+	c.Check(info.Format, Equals, KernelGzip)
+	c.Check(info.Version, Equals, testBanner)
+}
@@ -0,0 +1,111 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const MTK_MAGIC = 0x58881688
+
+const (
+	mtkHeaderSize = 512
+	mtkNameSize   = 32
+)
+
+// MtkHeader is the header MediaTek devices put in front of the kernel
+// and ramdisk, Size is the size of the payload that follows it
+type MtkHeader struct {
+	Size uint32
+	Name string
+	raw  []byte
+}
+
+// NewMtkHeader returns a header labelled name, e.g.; KERNEL, ROOTFS or
+// RECOVERY, filled the way the MediaTek tools do
+func NewMtkHeader(name string) (*MtkHeader, error) {
+	if len(name) >= mtkNameSize {
+		return nil, fmt.Errorf("MediaTek header name %q is longer than %d characters", name, mtkNameSize-1)
+	}
+	raw := bytes.Repeat([]byte{0xff}, mtkHeaderSize)
+	binary.LittleEndian.PutUint32(raw, MTK_MAGIC)
+	copy(raw[8:8+mtkNameSize], make([]byte, mtkNameSize))
+	copy(raw[8:], name)
+	return &MtkHeader{Name: name, raw: raw}, nil
+}
+
+// readMtkHeader returns the MediaTek header at the start of section or
+// nil if there is none
+func readMtkHeader(section *io.SectionReader) *MtkHeader {
+	raw := make([]byte, mtkHeaderSize)
+	if _, err := section.ReadAt(raw, 0); err != nil {
+		return nil
+	}
+	if binary.LittleEndian.Uint32(raw) != MTK_MAGIC {
+		return nil
+	}
+	size := binary.LittleEndian.Uint32(raw[4:])
+	if int64(size) > section.Size()-mtkHeaderSize {
+		return nil
+	}
+	return &MtkHeader{Size: size, Name: cString(raw[8 : 8+mtkNameSize]), raw: raw}
+}
+
+// wrap returns data preceded by the header with its size updated, fields
+// other than the size are kept as they were read
+func (mtk *MtkHeader) wrap(data []byte) []byte {
+	raw := make([]byte, mtkHeaderSize, mtkHeaderSize+len(data))
+	copy(raw, mtk.raw)
+	binary.LittleEndian.PutUint32(raw[4:], uint32(len(data)))
+	return append(raw, data...)
+}
+
+// Bytes returns the header as it is stored in the image
+func (mtk *MtkHeader) Bytes() []byte {
+	raw := make([]byte, mtkHeaderSize)
+	copy(raw, mtk.raw)
+	binary.LittleEndian.PutUint32(raw[4:], mtk.Size)
+	return raw
+}
+
+// ParseMtkHeader decodes a header previously obtained through Bytes
+func ParseMtkHeader(raw []byte) (*MtkHeader, error) {
+	if len(raw) != mtkHeaderSize || binary.LittleEndian.Uint32(raw) != MTK_MAGIC {
+		return nil, fmt.Errorf("not a %d byte MediaTek header", mtkHeaderSize)
+	}
+	return &MtkHeader{
+		Size: binary.LittleEndian.Uint32(raw[4:]),
+		Name: cString(raw[8 : 8+mtkNameSize]),
+		raw:  append([]byte(nil), raw...),
+	}, nil
+}
+
+// KernelMtkHeader returns the MediaTek header of the kernel or nil if the
+// image does not use one
+func (boot *AndroidBootImg) KernelMtkHeader() *MtkHeader {
+	return boot.mtk[kernelSection]
+}
+
+// RamdiskMtkHeader returns the MediaTek header of the ramdisk or nil if
+// the image does not use one
+func (boot *AndroidBootImg) RamdiskMtkHeader() *MtkHeader {
+	return boot.mtk[ramdiskSection]
+}
@@ -0,0 +1,101 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+
+	. "launchpad.net/gocheck"
+)
+
+type MtkTestSuite struct{}
+
+var _ = Suite(&MtkTestSuite{})
+
+func (s *MtkTestSuite) mtkImage(c *C) []byte {
+	kernelHdr, err := NewMtkHeader("KERNEL")
+	c.Assert(err, IsNil)
+	ramdiskHdr, err := NewMtkHeader("ROOTFS")
+	c.Assert(err, IsNil)
+	b := NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.KernelMtkHeader = kernelHdr
+	b.RamdiskMtkHeader = ramdiskHdr
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	return img
+}
+
+func (s *MtkTestSuite) TestStripped(c *C) {
+	boot, err := New(s.mtkImage(c))
+	c.Assert(err, IsNil)
+	c.Check(boot.Info().KernelSize, Equals, uint32(mtkHeaderSize+len("kernel")))
+	c.Check(readAll(c, boot.Kernel()), DeepEquals, []byte("kernel"))
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, []byte("ramdisk"))
+	c.Assert(boot.KernelMtkHeader(), NotNil)
+	c.Check(boot.KernelMtkHeader().Name, Equals, "KERNEL")
+	c.Check(boot.KernelMtkHeader().Size, Equals, uint32(len("kernel")))
+	c.Check(boot.RamdiskMtkHeader().Name, Equals, "ROOTFS")
+	c.Check(boot.VerifyChecksum(), IsNil)
+
+	raw := boot.KernelMtkHeader().Bytes()
+	c.Check(raw[:4], DeepEquals, []byte{0x88, 0x16, 0x88, 0x58})
+	c.Check(raw[mtkHeaderSize-1], Equals, byte(0xff))
+	parsed, err := ParseMtkHeader(raw)
+	c.Assert(err, IsNil)
+	c.Check(parsed.Name, Equals, "KERNEL")
+}
+
+func (s *MtkTestSuite) TestPlainImage(c *C) {
+	img, err := NewBuilder([]byte("kernel"), []byte("ramdisk")).Bytes()
+	c.Assert(err, IsNil)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+	c.Check(boot.KernelMtkHeader(), IsNil)
+	c.Check(boot.RamdiskMtkHeader(), IsNil)
+}
+
+func (s *MtkTestSuite) TestReplaceKeepsHeader(c *C) {
+	boot, err := New(s.mtkImage(c))
+	c.Assert(err, IsNil)
+	c.Assert(boot.ReplaceRamdisk([]byte("new ramdisk")), IsNil)
+	c.Check(readAll(c, boot.Ramdisk()), DeepEquals, []byte("new ramdisk"))
+	c.Assert(boot.RamdiskMtkHeader(), NotNil)
+	c.Check(boot.RamdiskMtkHeader().Size, Equals, uint32(len("new ramdisk")))
+	c.Check(boot.RamdiskMtkHeader().Name, Equals, "ROOTFS")
+	c.Check(boot.VerifyChecksum(), IsNil)
+}
+
+func (s *MtkTestSuite) TestRepackUnchanged(c *C) {
+	img := s.mtkImage(c)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+
+	b := NewBuilder(readAll(c, boot.Kernel()), readAll(c, boot.Ramdisk()))
+	b.KernelMtkHeader = boot.KernelMtkHeader()
+	b.RamdiskMtkHeader = boot.RamdiskMtkHeader()
+	repacked, err := b.Bytes()
+	c.Assert(err, IsNil)
+	c.Check(bytes.Equal(repacked, img), Equals, true)
+}
+
+func (s *MtkTestSuite) TestInvalidHeaders(c *C) {
+	_, err := NewMtkHeader(string(bytes.Repeat([]byte("n"), mtkNameSize)))
+	c.Check(err, NotNil)
+	_, err = ParseMtkHeader([]byte("short"))
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,176 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const QCDT_MAGIC = "QCDT"
+
+// Sizes of the QCDT table header and of the entries of each version
+const (
+	qcdtHeaderSize    = 12
+	qcdtEntrySizeV1   = 20
+	qcdtEntrySizeV2   = 24
+	qcdtEntrySizeV3   = 40
+	qcdtLatestVersion = 3
+)
+
+// QcdtEntry is a DTB in a Qualcomm dt.img along with the ids the
+// bootloader matches against the board it runs on, SubtypeId is only
+// kept by version 2 tables and PmicRev by version 3 ones
+type QcdtEntry struct {
+	PlatformId uint32
+	VariantId  uint32
+	SubtypeId  uint32
+	SocRev     uint32
+	PmicRev    [4]uint32
+	Dtb        []byte
+}
+
+// Qcdt is a Qualcomm device tree table as created by dtbTool, DTBs start
+// on a PageSize boundary
+type Qcdt struct {
+	Version  uint32
+	PageSize uint32
+	Entries  []QcdtEntry
+}
+
+// IsQcdt returns true if img starts with a QCDT table
+func IsQcdt(img []byte) bool {
+	return bytes.HasPrefix(img, []byte(QCDT_MAGIC))
+}
+
+func qcdtEntrySize(version uint32) (int, error) {
+	switch version {
+	case 1:
+		return qcdtEntrySizeV1, nil
+	case 2:
+		return qcdtEntrySizeV2, nil
+	case 3:
+		return qcdtEntrySizeV3, nil
+	}
+	return 0, ErrHeaderVersion{"QCDT", version}
+}
+
+// ParseQcdt reads the entries in the dt.img img, the page size is not
+// recorded in the table so it is left as the DefaultPageSize
+func ParseQcdt(img []byte) (*Qcdt, error) {
+	if !IsQcdt(img) {
+		return nil, ErrBadMagic{"QCDT", QCDT_MAGIC}
+	}
+	if len(img) < qcdtHeaderSize {
+		return nil, ErrTruncated{"QCDT header", qcdtHeaderSize, int64(len(img))}
+	}
+	dt := &Qcdt{Version: binary.LittleEndian.Uint32(img[4:]), PageSize: DefaultPageSize}
+	entrySize, err := qcdtEntrySize(dt.Version)
+	if err != nil {
+		return nil, err
+	}
+	count := uint64(binary.LittleEndian.Uint32(img[8:]))
+	if end := qcdtHeaderSize + count*uint64(entrySize); end > uint64(len(img)) {
+		return nil, ErrTruncated{"QCDT table", end, int64(len(img))}
+	}
+
+	for i := uint64(0); i < count; i++ {
+		fields := make([]uint32, entrySize/4)
+		for j := range fields {
+			fields[j] = binary.LittleEndian.Uint32(img[qcdtHeaderSize+int(i)*entrySize+j*4:])
+		}
+		e := QcdtEntry{PlatformId: fields[0], VariantId: fields[1]}
+		switch dt.Version {
+		case 1:
+			e.SocRev = fields[2]
+		case 2:
+			e.SubtypeId, e.SocRev = fields[2], fields[3]
+		case 3:
+			e.SubtypeId, e.SocRev = fields[2], fields[3]
+			copy(e.PmicRev[:], fields[4:8])
+		}
+		offset, size := uint64(fields[len(fields)-2]), uint64(fields[len(fields)-1])
+		if offset+size > uint64(len(img)) {
+			return nil, ErrTruncated{fmt.Sprintf("QCDT entry %d", i), offset + size, int64(len(img))}
+		}
+		e.Dtb = img[offset : offset+size]
+		dt.Entries = append(dt.Entries, e)
+	}
+	return dt, nil
+}
+
+// Lookup returns the first entry for the platform, variant and soc
+// revision given
+func (dt *Qcdt) Lookup(platformId, variantId, socRev uint32) (*QcdtEntry, bool) {
+	for i, e := range dt.Entries {
+		if e.PlatformId == platformId && e.VariantId == variantId && e.SocRev == socRev {
+			return &dt.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Bytes lays out the table the way dtbTool does, entries sharing the same
+// DTB point to a single copy of it
+func (dt *Qcdt) Bytes() ([]byte, error) {
+	entrySize, err := qcdtEntrySize(dt.Version)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := dt.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+
+	// the table is terminated by a zero word
+	tableSize := uint64(qcdtHeaderSize + len(dt.Entries)*entrySize + 4)
+	offset := alignTo(tableSize, pageSize)
+	offsets := make(map[string]uint64)
+	var dtbs [][]byte
+	var table bytes.Buffer
+	table.WriteString(QCDT_MAGIC)
+	fields := []uint32{dt.Version, uint32(len(dt.Entries))}
+	for _, e := range dt.Entries {
+		dtbOffset, ok := offsets[string(e.Dtb)]
+		if !ok {
+			dtbOffset = offset
+			offsets[string(e.Dtb)] = offset
+			offset += alignTo(uint64(len(e.Dtb)), pageSize)
+			dtbs = append(dtbs, e.Dtb)
+		}
+		if offset > 1<<32-1 {
+			return nil, fmt.Errorf("QCDT table does not fit in %d bytes", uint64(1<<32-1))
+		}
+
+		fields = append(fields, e.PlatformId, e.VariantId)
+		if dt.Version >= 2 {
+			fields = append(fields, e.SubtypeId)
+		}
+		fields = append(fields, e.SocRev)
+		if dt.Version >= 3 {
+			fields = append(fields, e.PmicRev[:]...)
+		}
+		fields = append(fields, uint32(dtbOffset), uint32(len(e.Dtb)))
+	}
+	fields = append(fields, 0)
+	if err := binary.Write(&table, binary.LittleEndian, fields); err != nil {
+		return nil, err
+	}
+	return assemble(table.Bytes(), pageSize, dtbs...), nil
+}
@@ -0,0 +1,423 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const VENDOR_BOOT_MAGIC = "VNDRBOOT"
+
+// Types for the entries in the vendor ramdisk table
+const (
+	VendorRamdiskTypeNone = iota
+	VendorRamdiskTypePlatform
+	VendorRamdiskTypeRecovery
+	VendorRamdiskTypeDlkm
+)
+
+// Sizes of the vendor_boot image header fields
+const (
+	vendorBootArgsSize          = 2048
+	vendorRamdiskNameSize       = 32
+	vendorRamdiskBoardIdSize    = 16
+	vendorBootHeaderSizeV3      = 2112
+	vendorBootHeaderSizeV4      = vendorBootHeaderSizeV3 + 16
+	vendorRamdiskTableEntrySize = 108
+)
+
+// VendorHeader holds the fields of a vendor_boot image header which
+// carries the vendor specific parts split out of boot.img starting with
+// header version 3
+type VendorHeader struct {
+	HeaderVersion     uint32
+	PageSize          uint32
+	KernelAddr        uint32
+	RamdiskAddr       uint32
+	VendorRamdiskSize uint32
+	Cmdline           string
+	TagsAddr          uint32
+	Name              string
+	HeaderSize        uint32
+	DtbSize           uint32
+	DtbAddr           uint64
+	// Version 4
+	VendorRamdiskTableSize      uint32
+	VendorRamdiskTableEntryNum  uint32
+	VendorRamdiskTableEntrySize uint32
+	BootconfigSize              uint32
+	Ramdisks                    []VendorRamdisk
+}
+
+// VendorRamdisk describes an entry in the vendor ramdisk table, Offset
+// is relative to the start of the vendor ramdisk section
+type VendorRamdisk struct {
+	Size, Offset, Type uint32
+	Name               string
+	BoardId            [vendorRamdiskBoardIdSize]uint32
+}
+
+type vendorBootImgHdrV3 struct {
+	Magic             [8]byte
+	HeaderVersion     uint32
+	PageSize          uint32
+	KernelAddr        uint32
+	RamdiskAddr       uint32
+	VendorRamdiskSize uint32
+	Cmdline           [vendorBootArgsSize]byte
+	TagsAddr          uint32
+	Name              [bootNameSize]byte
+	HeaderSize        uint32
+	DtbSize           uint32
+	DtbAddr           uint64
+}
+
+type vendorBootImgHdrV4 struct {
+	VendorRamdiskTableSize      uint32
+	VendorRamdiskTableEntryNum  uint32
+	VendorRamdiskTableEntrySize uint32
+	BootconfigSize              uint32
+}
+
+type vendorRamdiskTableEntry struct {
+	Size    uint32
+	Offset  uint32
+	Type    uint32
+	Name    [vendorRamdiskNameSize]byte
+	BoardId [vendorRamdiskBoardIdSize]uint32
+}
+
+type VendorBootImg struct {
+	ramdiskOffset, dtbOffset, tableOffset, bootconfigOffset uint64
+	hdr                                                     VendorHeader
+	r                                                       io.ReaderAt
+}
+
+// NewVendor reads a sequence of []byte corresponding to an android
+// vendor_boot image returning a VendorBootImg
+func NewVendor(img []byte) (VendorBootImg, error) {
+	return OpenVendor(bytes.NewReader(img), int64(len(img)))
+}
+
+// OpenVendor reads the header of the size bytes long android vendor_boot
+// image in r, every section is checked to lie within size
+func OpenVendor(r io.ReaderAt, size int64) (vboot VendorBootImg, err error) {
+	vboot.r = r
+
+	magic := make([]byte, len(VENDOR_BOOT_MAGIC))
+	if _, err := r.ReadAt(magic, 0); err != nil || VENDOR_BOOT_MAGIC != string(magic) {
+		return vboot, ErrBadMagic{"vendor_boot", VENDOR_BOOT_MAGIC}
+	}
+	if size < vendorBootHeaderSizeV3 {
+		return vboot, ErrTruncated{"vendor_boot header", vendorBootHeaderSizeV3, size}
+	}
+
+	hr := io.NewSectionReader(r, 0, size)
+	var raw vendorBootImgHdrV3
+	if err := binary.Read(hr, binary.LittleEndian, &raw); err != nil {
+		return vboot, err
+	}
+	vboot.hdr = VendorHeader{
+		HeaderVersion:     raw.HeaderVersion,
+		PageSize:          raw.PageSize,
+		KernelAddr:        raw.KernelAddr,
+		RamdiskAddr:       raw.RamdiskAddr,
+		VendorRamdiskSize: raw.VendorRamdiskSize,
+		Cmdline:           cString(raw.Cmdline[:]),
+		TagsAddr:          raw.TagsAddr,
+		Name:              cString(raw.Name[:]),
+		HeaderSize:        raw.HeaderSize,
+		DtbSize:           raw.DtbSize,
+		DtbAddr:           raw.DtbAddr,
+	}
+	if raw.HeaderVersion != 3 && raw.HeaderVersion != 4 {
+		return vboot, ErrHeaderVersion{"vendor_boot", raw.HeaderVersion}
+	}
+	if err := validPageSize(raw.PageSize); err != nil {
+		return vboot, err
+	}
+	if raw.HeaderVersion == 4 {
+		if size < vendorBootHeaderSizeV4 {
+			return vboot, ErrTruncated{"vendor_boot header", vendorBootHeaderSizeV4, size}
+		}
+		var v4 vendorBootImgHdrV4
+		if err := binary.Read(hr, binary.LittleEndian, &v4); err != nil {
+			return vboot, err
+		}
+		vboot.hdr.VendorRamdiskTableSize = v4.VendorRamdiskTableSize
+		vboot.hdr.VendorRamdiskTableEntryNum = v4.VendorRamdiskTableEntryNum
+		vboot.hdr.VendorRamdiskTableEntrySize = v4.VendorRamdiskTableEntrySize
+		vboot.hdr.BootconfigSize = v4.BootconfigSize
+	}
+
+	hdr := vboot.hdr
+	vboot.ramdiskOffset = alignTo(uint64(vboot.headerSize()), hdr.PageSize)
+	vboot.dtbOffset = vboot.ramdiskOffset + alignTo(uint64(hdr.VendorRamdiskSize), hdr.PageSize)
+	vboot.tableOffset = vboot.dtbOffset + alignTo(uint64(hdr.DtbSize), hdr.PageSize)
+	vboot.bootconfigOffset = vboot.tableOffset + alignTo(uint64(hdr.VendorRamdiskTableSize), hdr.PageSize)
+	if end := vboot.bootconfigOffset + uint64(hdr.BootconfigSize); end > uint64(size) {
+		return vboot, ErrTruncated{"vendor_boot image", end, size}
+	}
+
+	if hdr.HeaderVersion == 4 {
+		if vboot.hdr.Ramdisks, err = vboot.readRamdiskTable(); err != nil {
+			return vboot, err
+		}
+	}
+	return vboot, nil
+}
+
+func (vboot *VendorBootImg) headerSize() int {
+	if vboot.hdr.HeaderVersion == 4 {
+		return vendorBootHeaderSizeV4
+	}
+	return vendorBootHeaderSizeV3
+}
+
+func (vboot *VendorBootImg) readRamdiskTable() (ramdisks []VendorRamdisk, err error) {
+	hdr := vboot.hdr
+	if hdr.VendorRamdiskTableEntryNum == 0 {
+		return nil, nil
+	}
+	entrySize := uint64(hdr.VendorRamdiskTableEntrySize)
+	if entrySize < vendorRamdiskTableEntrySize ||
+		uint64(hdr.VendorRamdiskTableEntryNum)*entrySize > uint64(hdr.VendorRamdiskTableSize) {
+		return nil, errors.New("invalid vendor ramdisk table")
+	}
+	for i := uint64(0); i < uint64(hdr.VendorRamdiskTableEntryNum); i++ {
+		start := vboot.tableOffset + i*entrySize
+		var entry vendorRamdiskTableEntry
+		if err := binary.Read(io.NewSectionReader(vboot.r, int64(start), int64(entrySize)), binary.LittleEndian, &entry); err != nil {
+			return nil, err
+		}
+		if uint64(entry.Offset)+uint64(entry.Size) > uint64(hdr.VendorRamdiskSize) {
+			return nil, fmt.Errorf("vendor ramdisk %d lies outside of the vendor ramdisk section", i)
+		}
+		ramdisks = append(ramdisks, VendorRamdisk{
+			Size:    entry.Size,
+			Offset:  entry.Offset,
+			Type:    entry.Type,
+			Name:    cString(entry.Name[:]),
+			BoardId: entry.BoardId,
+		})
+	}
+	return ramdisks, nil
+}
+
+// Info returns the parsed header of the vendor_boot image
+func (vboot *VendorBootImg) Info() VendorHeader {
+	return vboot.hdr
+}
+
+// Ramdisk returns a reader for all the vendor ramdisks as they are
+// concatenated in the image
+func (vboot *VendorBootImg) Ramdisk() *io.SectionReader {
+	return io.NewSectionReader(vboot.r, int64(vboot.ramdiskOffset), int64(vboot.hdr.VendorRamdiskSize))
+}
+
+// VendorRamdisk returns a reader for the vendor ramdisk called name from
+// the vendor ramdisk table
+func (vboot *VendorBootImg) VendorRamdisk(name string) (*io.SectionReader, error) {
+	for _, r := range vboot.hdr.Ramdisks {
+		if r.Name == name {
+			return io.NewSectionReader(vboot.r, int64(vboot.ramdiskOffset)+int64(r.Offset), int64(r.Size)), nil
+		}
+	}
+	return nil, fmt.Errorf("vendor ramdisk %q does not exist in this vendor_boot image", name)
+}
+
+// Dtb returns a reader for the DTB, its Size is 0 if the image does not
+// carry one
+func (vboot *VendorBootImg) Dtb() *io.SectionReader {
+	return io.NewSectionReader(vboot.r, int64(vboot.dtbOffset), int64(vboot.hdr.DtbSize))
+}
+
+// Bootconfig returns a reader for the bootconfig of version 4 images, its
+// Size is 0 if the image does not carry one
+func (vboot *VendorBootImg) Bootconfig() *io.SectionReader {
+	return io.NewSectionReader(vboot.r, int64(vboot.bootconfigOffset), int64(vboot.hdr.BootconfigSize))
+}
+
+// CombinedRamdisk returns a reader for the ramdisk the bootloader hands
+// over to the kernel for header version 3 and later, that is, the vendor
+// ramdisks followed by the generic ramdisk in boot
+func (vboot *VendorBootImg) CombinedRamdisk(boot *AndroidBootImg) io.Reader {
+	return io.MultiReader(vboot.Ramdisk(), boot.Ramdisk())
+}
+
+// WriteRamdisk writes all the vendor ramdisks as they are concatenated in
+// the image to filePath
+func (vboot *VendorBootImg) WriteRamdisk(filePath string) error {
+	return writeFile(filePath, vboot.Ramdisk())
+}
+
+// WriteVendorRamdisk writes the vendor ramdisk called name from the
+// vendor ramdisk table to filePath
+func (vboot *VendorBootImg) WriteVendorRamdisk(name, filePath string) error {
+	r, err := vboot.VendorRamdisk(name)
+	if err != nil {
+		return err
+	}
+	return writeFile(filePath, r)
+}
+
+// WriteDtb writes the DTB contained in the vendor_boot image to filePath
+func (vboot *VendorBootImg) WriteDtb(filePath string) error {
+	if vboot.hdr.DtbSize == 0 {
+		return errors.New("dtb does not exist in this vendor_boot image")
+	}
+	return writeFile(filePath, vboot.Dtb())
+}
+
+// WriteBootconfig writes the bootconfig of a version 4 vendor_boot image
+// to filePath
+func (vboot *VendorBootImg) WriteBootconfig(filePath string) error {
+	if vboot.hdr.BootconfigSize == 0 {
+		return errors.New("bootconfig does not exist in this vendor_boot image")
+	}
+	return writeFile(filePath, vboot.Bootconfig())
+}
+
+// WriteCombinedRamdisk writes the ramdisk returned by CombinedRamdisk to
+// filePath
+func (vboot *VendorBootImg) WriteCombinedRamdisk(boot *AndroidBootImg, filePath string) error {
+	return writeFile(filePath, vboot.CombinedRamdisk(boot))
+}
+
+// VendorBuilder holds the pieces required to assemble a vendor_boot image
+type VendorBuilder struct {
+	Dtb, Bootconfig []byte
+	Cmdline, Name   string
+	Base            uint32
+	KernelOffset    uint32
+	RamdiskOffset   uint32
+	TagsOffset      uint32
+	DtbOffset       uint64
+	PageSize        uint32
+	HeaderVersion   uint32
+	ramdisks        []VendorRamdisk
+	ramdiskData     [][]byte
+}
+
+// NewVendorBuilder returns a VendorBuilder for headerVersion using the
+// same defaults mkbootimg does
+func NewVendorBuilder(headerVersion uint32) *VendorBuilder {
+	return &VendorBuilder{
+		Base:          DefaultBase,
+		KernelOffset:  DefaultKernelOffset,
+		RamdiskOffset: DefaultRamdiskOffset,
+		TagsOffset:    DefaultTagsOffset,
+		DtbOffset:     DefaultDtbOffset,
+		PageSize:      DefaultPageSize,
+		HeaderVersion: headerVersion,
+	}
+}
+
+// AddRamdisk appends a vendor ramdisk, version 3 images only hold one
+func (b *VendorBuilder) AddRamdisk(name string, ramdiskType uint32, data []byte) {
+	b.ramdisks = append(b.ramdisks, VendorRamdisk{Name: name, Type: ramdiskType})
+	b.ramdiskData = append(b.ramdiskData, data)
+}
+
+// Bytes assembles the vendor_boot image described by VendorBuilder
+func (b *VendorBuilder) Bytes() ([]byte, error) {
+	if b.HeaderVersion != 3 && b.HeaderVersion != 4 {
+		return nil, ErrHeaderVersion{"vendor_boot", b.HeaderVersion}
+	}
+	if err := validPageSize(b.PageSize); err != nil {
+		return nil, err
+	}
+	if len(b.Name) >= bootNameSize {
+		return nil, fmt.Errorf("board name %q is longer than %d characters", b.Name, bootNameSize-1)
+	}
+	if len(b.Cmdline) >= vendorBootArgsSize {
+		return nil, fmt.Errorf("vendor cmdline is longer than %d characters", vendorBootArgsSize-1)
+	}
+	if b.HeaderVersion == 3 && (len(b.ramdisks) > 1 || len(b.Bootconfig) != 0) {
+		return nil, errors.New("vendor_boot header version 3 holds a single ramdisk and no bootconfig")
+	}
+
+	var ramdisk, table bytes.Buffer
+	for i, r := range b.ramdisks {
+		entry := vendorRamdiskTableEntry{
+			Size:    uint32(len(b.ramdiskData[i])),
+			Offset:  uint32(ramdisk.Len()),
+			Type:    r.Type,
+			BoardId: r.BoardId,
+		}
+		if len(r.Name) >= vendorRamdiskNameSize {
+			return nil, fmt.Errorf("vendor ramdisk name %q is longer than %d characters", r.Name, vendorRamdiskNameSize-1)
+		}
+		copy(entry.Name[:], r.Name)
+		if err := binary.Write(&table, binary.LittleEndian, entry); err != nil {
+			return nil, err
+		}
+		ramdisk.Write(b.ramdiskData[i])
+	}
+
+	raw := vendorBootImgHdrV3{
+		HeaderVersion:     b.HeaderVersion,
+		PageSize:          b.PageSize,
+		KernelAddr:        b.Base + b.KernelOffset,
+		RamdiskAddr:       b.Base + b.RamdiskOffset,
+		VendorRamdiskSize: uint32(ramdisk.Len()),
+		TagsAddr:          b.Base + b.TagsOffset,
+		HeaderSize:        vendorBootHeaderSizeV3,
+		DtbSize:           uint32(len(b.Dtb)),
+		DtbAddr:           uint64(b.Base) + b.DtbOffset,
+	}
+	copy(raw.Magic[:], VENDOR_BOOT_MAGIC)
+	copy(raw.Cmdline[:], b.Cmdline)
+	copy(raw.Name[:], b.Name)
+
+	var hdr bytes.Buffer
+	sections := [][]byte{ramdisk.Bytes(), b.Dtb}
+	if b.HeaderVersion == 4 {
+		raw.HeaderSize = vendorBootHeaderSizeV4
+		if err := binary.Write(&hdr, binary.LittleEndian, raw); err != nil {
+			return nil, err
+		}
+		v4 := vendorBootImgHdrV4{
+			VendorRamdiskTableSize:      uint32(table.Len()),
+			VendorRamdiskTableEntryNum:  uint32(len(b.ramdisks)),
+			VendorRamdiskTableEntrySize: vendorRamdiskTableEntrySize,
+			BootconfigSize:              uint32(len(b.Bootconfig)),
+		}
+		if err := binary.Write(&hdr, binary.LittleEndian, v4); err != nil {
+			return nil, err
+		}
+		sections = append(sections, table.Bytes(), b.Bootconfig)
+	} else if err := binary.Write(&hdr, binary.LittleEndian, raw); err != nil {
+		return nil, err
+	}
+	return assemble(hdr.Bytes(), b.PageSize, sections...), nil
+}
+
+// WriteImage assembles the vendor_boot image and writes it to filePath
+func (b *VendorBuilder) WriteImage(filePath string) error {
+	img, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, img, 0644)
+}
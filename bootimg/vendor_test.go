@@ -0,0 +1,125 @@
+//
+// bootimg - Tool to assemble/dissassemble Android boot.img s
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package bootimg
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+)
+
+type VendorBootImgTestSuite struct {
+	tmpdir string
+}
+
+var _ = Suite(&VendorBootImgTestSuite{})
+
+func (s *VendorBootImgTestSuite) SetUpTest(c *C) {
+	s.tmpdir = c.MkDir()
+}
+
+func (s *VendorBootImgTestSuite) TestVersion4(c *C) {
+	b := NewVendorBuilder(4)
+	b.Name = "generic"
+	b.Cmdline = "androidboot.hardware=ranchu"
+	b.Dtb = []byte("dtb")
+	b.Bootconfig = []byte("androidboot.slot_suffix=_a\n")
+	b.AddRamdisk("platform", VendorRamdiskTypePlatform, []byte("platform ramdisk"))
+	b.AddRamdisk("dlkm", VendorRamdiskTypeDlkm, []byte("dlkm"))
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+
+	vboot, err := NewVendor(img)
+	c.Assert(err, IsNil)
+	hdr := vboot.Info()
+	c.Check(hdr.HeaderVersion, Equals, uint32(4))
+	c.Check(hdr.Name, Equals, "generic")
+	c.Check(hdr.Cmdline, Equals, "androidboot.hardware=ranchu")
+	c.Check(hdr.VendorRamdiskSize, Equals, uint32(len("platform ramdisk")+len("dlkm")))
+	c.Assert(hdr.Ramdisks, HasLen, 2)
+	c.Check(hdr.Ramdisks[1], DeepEquals, VendorRamdisk{
+		Size: 4, Offset: uint32(len("platform ramdisk")), Type: VendorRamdiskTypeDlkm, Name: "dlkm"})
+
+	dlkm := filepath.Join(s.tmpdir, "dlkm")
+	c.Assert(vboot.WriteVendorRamdisk("dlkm", dlkm), IsNil)
+	s.checkFile(c, dlkm, "dlkm")
+	c.Check(vboot.WriteVendorRamdisk("missing", dlkm), NotNil)
+
+	// drop the page holding the bootconfig
+	_, err = NewVendor(img[:len(img)-DefaultPageSize])
+	c.Check(err, FitsTypeOf, ErrTruncated{})
+
+	dtb := filepath.Join(s.tmpdir, "dtb")
+	c.Assert(vboot.WriteDtb(dtb), IsNil)
+	s.checkFile(c, dtb, "dtb")
+
+	bootconfig := filepath.Join(s.tmpdir, "bootconfig")
+	c.Assert(vboot.WriteBootconfig(bootconfig), IsNil)
+	s.checkFile(c, bootconfig, "androidboot.slot_suffix=_a\n")
+}
+
+func (s *VendorBootImgTestSuite) TestVersion3CombinedRamdisk(c *C) {
+	vb := NewVendorBuilder(3)
+	vb.AddRamdisk("", VendorRamdiskTypeNone, []byte("vendor"))
+	vimg, err := vb.Bytes()
+	c.Assert(err, IsNil)
+	vboot, err := NewVendor(vimg)
+	c.Assert(err, IsNil)
+	c.Check(vboot.Info().Ramdisks, HasLen, 0)
+
+	b := NewBuilder([]byte("kernel"), []byte("generic"))
+	b.HeaderVersion = 3
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	boot, err := New(img)
+	c.Assert(err, IsNil)
+
+	ramdisk := filepath.Join(s.tmpdir, "ramdisk")
+	c.Assert(vboot.WriteCombinedRamdisk(&boot, ramdisk), IsNil)
+	s.checkFile(c, ramdisk, "vendorgeneric")
+}
+
+func (s *VendorBootImgTestSuite) TestVersion3SingleRamdisk(c *C) {
+	vb := NewVendorBuilder(3)
+	vb.AddRamdisk("a", VendorRamdiskTypePlatform, []byte("a"))
+	vb.AddRamdisk("b", VendorRamdiskTypePlatform, []byte("b"))
+	_, err := vb.Bytes()
+	c.Check(err, NotNil)
+}
+
+func (s *VendorBootImgTestSuite) TestInvalid(c *C) {
+	_, err := NewVendor([]byte(VENDOR_BOOT_MAGIC))
+	c.Check(err, NotNil)
+
+	img, err := NewVendorBuilder(4).Bytes()
+	c.Assert(err, IsNil)
+	// header_version follows the magic
+	img[len(VENDOR_BOOT_MAGIC)] = 5
+	_, err = NewVendor(img)
+	c.Check(err, FitsTypeOf, ErrHeaderVersion{})
+
+	_, err = NewVendor(nil)
+	c.Check(err, FitsTypeOf, ErrBadMagic{})
+}
+
+func (s *VendorBootImgTestSuite) checkFile(c *C, path, contents string) {
+	b, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, contents)
+}
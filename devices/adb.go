@@ -5,9 +5,11 @@
 package devices
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"os/exec"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -33,13 +35,13 @@ func init() {
 
 // Returns an AndroidDebugBridge while ensuring the server is started
 func NewAndroidDebugBridge() (adb AndroidDebugBridge, err error) {
-	err = exec.Command(adbCommand, "start-server").Run()
+	err = adbStartServer()
 	return adb, err
 }
 
 // Returns an AndroidDebugBridge while ensuring the server is started
 func NewUbuntuDebugBridge() (adb UbuntuDebugBridge, err error) {
-	err = exec.Command(adbCommand, "start-server").Run()
+	err = adbStartServer()
 	return adb, err
 }
 
@@ -49,21 +51,22 @@ func (adb *AndroidDebugBridge) SetSerial(serial string) {
 	adb.params = append(adb.params, []string{"-s", serial}...)
 }
 
-// Shell runs a specific command on the target device
+// Shell runs a specific command on the target device, commands that exit
+// with a non zero status are returned as an ErrShellExit
 func (adb *AndroidDebugBridge) Shell(command ...string) (string, error) {
-	cmd := append(adb.params, "shell")
-	cmd = append(cmd, command...)
-	out, err := exec.Command(adbCommand, cmd...).Output()
+	result, err := adb.Run(context.Background(), nil, command...)
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(out)), nil
+	if !result.Success() {
+		return "", ErrShellExit{strings.Join(command, " "), result.ExitCode, strings.TrimSpace(string(result.Stderr))}
+	}
+	return strings.TrimSpace(string(result.Stdout)), nil
 }
 
 // GetDevice parses the android property system to determine the device being used
 func (adb *AndroidDebugBridge) GetDevice() (deviceName string, err error) {
-	cmd := append(adb.params, []string{"shell", "getprop", "ro.product.device"}...)
-	out, err := exec.Command(adbCommand, cmd...).Output()
+	out, err := runService(context.Background(), adb.serial, "shell:getprop ro.product.device")
 	if err != nil {
 		return deviceName, err
 	}
@@ -72,82 +75,126 @@ func (adb *AndroidDebugBridge) GetDevice() (deviceName string, err error) {
 	return adb.deviceName, err
 }
 
-// Push copies a file from src to dst over the adb server
+// Push copies a file or directory from src to dst over the adb server,
+// like adb push it goes into dst if dst is a directory on the device
 func (adb AndroidDebugBridge) Push(src, dst string) (err error) {
-	// TODO add file path verification
-	cmd := append(adb.params, []string{"push", src, dst}...)
-	b, err := exec.Command(adbCommand, cmd...).CombinedOutput()
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("error pushing: %s", err)
+	}
+	sc, err := openSync(adb.serial)
+	if err != nil {
+		return fmt.Errorf("error pushing: %s", err)
+	}
+	defer sc.Close()
+
+	f, err := sc.stat(dst)
 	if err != nil {
-		return fmt.Errorf("error pushing: %s\n", b)
+		return fmt.Errorf("error pushing: %s", err)
+	}
+	if f.isDir() {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+	if err := sc.pushTree(src, dst); err != nil {
+		return fmt.Errorf("error pushing: %s", err)
 	}
 	return nil
 }
 
-// Pull copies a file from src to dst over the adb server
+// Pull copies a file or directory from src to dst over the adb server,
+// like adb pull it goes into dst if dst is a local directory
 func (adb AndroidDebugBridge) Pull(src, dst string) (err error) {
-	// TODO add file path verification
-	cmd := append(adb.params, []string{"pull", src, dst}...)
-	return exec.Command(adbCommand, cmd...).Run()
+	sc, err := openSync(adb.serial)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	f, err := sc.stat(src)
+	if err != nil {
+		return err
+	}
+	if !f.exists() {
+		return fmt.Errorf("remote object '%s' does not exist", src)
+	}
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+	return sc.pullTree(src, f, dst)
 }
 
 // RebootBooloader restarts the system into the bootloader
 func (adb AndroidDebugBridge) RebootBootloader() (err error) {
-	return adb.reboot("bootloader")
+	return adb.Reboot(context.Background(), TargetBootloader)
 }
 
 // RebootRecovery restarts the system into recovery
 func (adb AndroidDebugBridge) RebootRecovery() (err error) {
-	return adb.reboot("recovery")
+	return adb.Reboot(context.Background(), TargetRecovery)
 }
 
-// reboot restarts into the desired target
-func (adb AndroidDebugBridge) reboot(mode string) (err error) {
-	cmd := append(adb.params, []string{"reboot", mode}...)
-	return exec.Command(adbCommand, cmd...).Run()
+// Reboot restarts the system into target
+func (adb AndroidDebugBridge) Reboot(ctx context.Context, target string) (err error) {
+	_, err = runService(ctx, adb.serial, "reboot:"+target)
+	return err
 }
 
-// Reboot restarts the system from the shell. This is different than
-// calling adb reboot directly.
-func (adb UbuntuDebugBridge) Reboot() (err error) {
-	cmd := append(adb.params, []string{"shell", "reboot"}...)
-	return exec.Command(adbCommand, cmd...).Run()
+// WaitForState waits until adb reports the device in state, devices on
+// the network are connected to again while they are missing
+func (adb AndroidDebugBridge) WaitForState(ctx context.Context, state State) error {
+	return poll(ctx, adb.serial, state, func() (State, error) {
+		attached, err := adbDevices(ctx)
+		if err != nil {
+			return "", err
+		}
+		var current State
+		for _, dev := range attached {
+			if dev.serial == adb.serial || adb.serial == "" {
+				current = State(dev.state)
+				break
+			}
+		}
+		if current != state {
+			adb.reconnect(ctx, current)
+		}
+		return current, nil
+	})
+}
+
+// Reboot restarts the system into target, restarting into the system
+// is done from the shell. This is different than calling adb reboot
+// directly.
+func (adb UbuntuDebugBridge) Reboot(ctx context.Context, target string) (err error) {
+	if target != TargetSystem {
+		return adb.AndroidDebugBridge.Reboot(ctx, target)
+	}
+	_, err = runService(ctx, adb.serial, "shell:reboot")
+	return err
 }
 
 // WaitForDevice waits for the device to be available
 func (adb UbuntuDebugBridge) WaitForDevice() (err error) {
-	cmd := append(adb.params, []string{"wait-for-device"}...)
-	err = exec.Command(adbCommand, cmd...).Run()
-	return err
+	if IsNetworkSerial(adb.serial) {
+		return adb.WaitForState(context.Background(), StateDevice)
+	}
+	return waitForDevice(context.Background(), adb.serial)
 }
 
+// recoveryTimeout is how long a device gets to boot into recovery
+const recoveryTimeout = 70 * time.Second
+
 // WaitForRecovery idles until the image has booted into recovery
 // for recovery
 func (adb UbuntuDebugBridge) WaitForRecovery() error {
-	// Recovery takes some time to get into, so we wait a bit
-	time.Sleep(10 * time.Second)
-	done := make(chan bool)
-	cmd := append(adb.params, []string{"shell", "ls"}...)
-	go func() {
-		for {
-			err := exec.Command(adbCommand, cmd...).Run()
-			if err == nil {
-				done <- true
-			}
-			time.Sleep(5 * time.Second)
-		}
-	}()
-	for {
-		select {
-		case <-done:
-			return nil
-		case <-time.After(60 * time.Second):
-			return errors.New(fmt.Sprint("Failed to enter Recovery"))
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), recoveryTimeout)
+	defer cancel()
+	if err := adb.WaitForState(ctx, StateRecovery); err != nil {
+		return fmt.Errorf("Failed to enter Recovery: %s", err)
 	}
+	return nil
 }
 
 // Ping pings the device to know it's there
 func (adb UbuntuDebugBridge) Ping() (err error) {
-	cmd := append(adb.params, []string{"shell", "ls"}...)
-	return exec.Command(adbCommand, cmd...).Run()
+	_, err = runService(context.Background(), adb.serial, "shell:ls")
+	return err
 }
@@ -0,0 +1,140 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type AdbTestSuite struct {
+	server  *fakeAdbServer
+	restore func()
+	tmpdir  string
+}
+
+var _ = Suite(&AdbTestSuite{})
+
+func (s *AdbTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeAdbServer()
+	c.Assert(err, IsNil)
+	s.restore = devices.SetAdbServerAddr(s.server.addr())
+	s.tmpdir = c.MkDir()
+}
+
+func (s *AdbTestSuite) TearDownTest(c *C) {
+	s.restore()
+	s.server.close()
+}
+
+func (s *AdbTestSuite) TestShell(c *C) {
+	s.server.shell["getprop ro.product.device"] = "mako\r\n"
+	s.server.shell["echo hello world"] = "hello world\n"
+
+	adb, err := devices.NewAndroidDebugBridge()
+	c.Assert(err, IsNil)
+	adb.SetSerial("0123456789ABCDEF")
+	out, err := adb.Shell("echo", "hello", "world")
+	c.Assert(err, IsNil)
+	c.Check(out, Equals, "hello world")
+	device, err := adb.GetDevice()
+	c.Assert(err, IsNil)
+	c.Check(device, Equals, "mako")
+
+	c.Check(s.server.received(), DeepEquals, []string{
+		"host:version",
+		"host-serial:0123456789ABCDEF:features",
+		"host:transport:0123456789ABCDEF", "shell,v2,raw:echo hello world",
+		"host:transport:0123456789ABCDEF", "shell:getprop ro.product.device",
+	})
+}
+
+func (s *AdbTestSuite) TestUnknownSerial(c *C) {
+	var adb devices.UbuntuDebugBridge
+	adb.SetSerial("missing")
+	err := adb.Ping()
+	c.Assert(err, FitsTypeOf, devices.ErrAdb{})
+	c.Check(err, ErrorMatches, `adb request "host:transport:missing" failed: device not found`)
+}
+
+func (s *AdbTestSuite) TestPushPull(c *C) {
+	var adb devices.AndroidDebugBridge
+	src := filepath.Join(s.tmpdir, "ubuntu.tar.xz")
+	c.Assert(ioutil.WriteFile(src, []byte("image"), 0644), IsNil)
+	// pushing to an existing directory goes into the directory
+	s.server.files["/cache/recovery/log"] = []byte("log")
+	c.Assert(adb.Push(src, "/cache/recovery"), IsNil)
+	c.Check(string(s.server.files["/cache/recovery/ubuntu.tar.xz"]), Equals, "image")
+
+	dst := filepath.Join(s.tmpdir, "pulled")
+	c.Assert(adb.Pull("/cache/recovery/ubuntu.tar.xz", dst), IsNil)
+	data, err := ioutil.ReadFile(dst)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "image")
+
+	c.Check(adb.Pull("/missing", dst), NotNil)
+	c.Check(adb.Push(filepath.Join(s.tmpdir, "missing"), "/cache"), NotNil)
+}
+
+func (s *AdbTestSuite) TestPushPullDirectory(c *C) {
+	var adb devices.AndroidDebugBridge
+	src := filepath.Join(s.tmpdir, "data")
+	c.Assert(os.MkdirAll(filepath.Join(src, "sub"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "a"), []byte("a"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "sub", "b"), []byte("b"), 0644), IsNil)
+	c.Assert(adb.Push(src, "/tmp/data"), IsNil)
+	c.Check(string(s.server.files["/tmp/data/a"]), Equals, "a")
+	c.Check(string(s.server.files["/tmp/data/sub/b"]), Equals, "b")
+
+	dst := filepath.Join(s.tmpdir, "pulled")
+	c.Assert(adb.Pull("/tmp/data", dst), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(dst, "sub", "b"))
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "b")
+}
+
+func (s *AdbTestSuite) TestRebootAndWait(c *C) {
+	var adb devices.UbuntuDebugBridge
+	c.Assert(adb.RebootRecovery(), IsNil)
+	c.Assert(adb.WaitForDevice(), IsNil)
+	c.Check(s.server.received(), DeepEquals, []string{
+		"host:transport-any", "reboot:recovery", "host:wait-for-any-device",
+	})
+}
+
+func (s *AdbTestSuite) TestWaitForDeviceCancel(c *C) {
+	s.server.setState("offline")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := devices.WaitForDevice(ctx, "0123456789ABCDEF")
+	c.Check(err, Equals, context.Canceled)
+}
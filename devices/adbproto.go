@@ -0,0 +1,261 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// adbServerAddr is where the adb server listens, adb itself honours
+// ANDROID_ADB_SERVER_PORT to move it
+var adbServerAddr string
+
+func init() {
+	port := "5037"
+	if p := os.Getenv("ANDROID_ADB_SERVER_PORT"); p != "" {
+		port = p
+	}
+	adbServerAddr = net.JoinHostPort("localhost", port)
+}
+
+// adbConn is a connection to the adb server, requests are sent as a
+// 4 digit hex length followed by the request and answered with OKAY
+// or FAIL followed by a message
+type adbConn struct {
+	net.Conn
+	r         *bufio.Reader
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// dialAdb connects to the adb server, the connection stops working
+// once ctx is done
+func dialAdb(ctx context.Context) (*adbConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", adbServerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := &adbConn{Conn: conn, r: bufio.NewReader(conn), closed: make(chan struct{})}
+	if ctx.Done() != nil {
+		// closing the connection is the only way to unblock reads and
+		// writes in progress
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-c.closed:
+			}
+		}()
+	}
+	return c, nil
+}
+
+// Close closes the connection to the adb server
+func (conn *adbConn) Close() error {
+	conn.closeOnce.Do(func() { close(conn.closed) })
+	return conn.Conn.Close()
+}
+
+// Read reads through the buffer used to read the status replies
+func (conn *adbConn) Read(p []byte) (int, error) {
+	return conn.r.Read(p)
+}
+
+// request sends req and waits for the server to accept it
+func (conn *adbConn) request(req string) error {
+	if _, err := fmt.Fprintf(conn, "%04x%s", len(req), req); err != nil {
+		return err
+	}
+	return conn.status(req)
+}
+
+// status reads an OKAY or FAIL reply to req
+func (conn *adbConn) status(req string) error {
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return fmt.Errorf("cannot read reply to adb request %q: %v", req, err)
+	}
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		msg, err := conn.readString()
+		if err != nil {
+			return err
+		}
+		return ErrAdb{req, msg}
+	}
+	return ErrAdb{req, fmt.Sprintf("unexpected reply %q", status)}
+}
+
+// readString reads a string prefixed by its length as 4 hex digits
+func (conn *adbConn) readString() (string, error) {
+	hexLen := make([]byte, 4)
+	if _, err := io.ReadFull(conn, hexLen); err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseUint(string(hexLen), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid adb reply length %q", hexLen)
+	}
+	s := make([]byte, n)
+	if _, err := io.ReadFull(conn, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// adbQuery sends a host request to the adb server and returns its reply
+func adbQuery(ctx context.Context, req string) (string, error) {
+	conn, err := dialAdb(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.request(req); err != nil {
+		return "", err
+	}
+	return conn.readString()
+}
+
+// adbStartServer makes sure an adb server is listening, starting one
+// with the adb command if there is none
+func adbStartServer() error {
+	if _, err := adbQuery(context.Background(), "host:version"); err == nil {
+		return nil
+	}
+	_, err := runner.Run(context.Background(), adbCommand, "start-server")
+	return err
+}
+
+// adbDevice is an entry of the devices known to the adb server
+type adbDevice struct {
+	serial string
+	state  string
+	// properties such as product, model, device and usb
+	props map[string]string
+}
+
+// adbDevices lists the devices attached to the adb server
+func adbDevices(ctx context.Context) ([]adbDevice, error) {
+	out, err := adbQuery(ctx, "host:devices-l")
+	if err != nil {
+		return nil, err
+	}
+	return parseAdbDevices(out), nil
+}
+
+// parseAdbDevices parses the reply to host:devices-l, one device per
+// line with its serial, state and key:value properties
+func parseAdbDevices(out string) (devices []adbDevice) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		dev := adbDevice{serial: fields[0], state: fields[1], props: make(map[string]string)}
+		for _, field := range fields[2:] {
+			if kv := strings.SplitN(field, ":", 2); len(kv) == 2 {
+				dev.props[kv[0]] = kv[1]
+			}
+		}
+		devices = append(devices, dev)
+	}
+	return devices
+}
+
+// transport returns a connection to the device selected by serial, or
+// to the only device attached if serial is empty
+func transport(ctx context.Context, serial string) (*adbConn, error) {
+	conn, err := dialAdb(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := "host:transport-any"
+	if serial != "" {
+		req = "host:transport:" + serial
+	}
+	if err := conn.request(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// service opens service on the device selected by serial, the returned
+// connection carries the output of the service
+func service(ctx context.Context, serial, svc string) (*adbConn, error) {
+	conn, err := transport(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.request(svc); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// runService runs svc on the device selected by serial and returns all
+// of its output
+func runService(ctx context.Context, serial, svc string) ([]byte, error) {
+	conn, err := service(ctx, serial, svc)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return ioutil.ReadAll(conn)
+}
+
+// waitForDevice blocks until the device selected by serial, or any
+// device if serial is empty, is online
+func waitForDevice(ctx context.Context, serial string) error {
+	conn, err := dialAdb(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	req := "host:wait-for-any-device"
+	if serial != "" {
+		req = fmt.Sprintf("host-serial:%s:wait-for-any-device", serial)
+	}
+	if err := conn.request(req); err != nil {
+		return err
+	}
+	// a second OKAY comes once the device is there
+	if err := conn.status(req); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"time"
+)
+
+// Device is a device reached over adb or fastboot
+type Device interface {
+	// Serial returns the serial the device was selected with, if any
+	Serial() string
+	// WaitForState blocks until the device is in state or ctx is done
+	WaitForState(ctx context.Context, state State) error
+	// Reboot restarts the device into target
+	Reboot(ctx context.Context, target string) error
+}
+
+// Targets a device can be rebooted into
+const (
+	TargetSystem     = ""
+	TargetBootloader = "bootloader"
+	TargetRecovery   = "recovery"
+)
+
+var (
+	_ Device = AndroidDebugBridge{}
+	_ Device = UbuntuDebugBridge{}
+	_ Device = Fastboot{}
+)
+
+// pollInterval is how often the state of a device is checked
+var pollInterval = 500 * time.Millisecond
+
+// poll calls state until it returns want or ctx is done, an empty state
+// means the device is not attached
+func poll(ctx context.Context, serial string, want State, state func() (State, error)) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var last State
+	for {
+		current, err := state()
+		if err == nil && current == want {
+			return nil
+		}
+		if err == nil {
+			last = current
+		}
+		select {
+		case <-ctx.Done():
+			return ErrWait{serial, want, last, ctx.Err()}
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,105 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type DeviceTestSuite struct {
+	adb      *fakeAdbServer
+	fastboot *fakeFastboot
+	restore  []func()
+}
+
+var _ = Suite(&DeviceTestSuite{})
+
+func (s *DeviceTestSuite) SetUpTest(c *C) {
+	var err error
+	s.adb, err = newFakeAdbServer()
+	c.Assert(err, IsNil)
+	s.fastboot, err = newFakeFastboot()
+	c.Assert(err, IsNil)
+	s.restore = []func(){
+		devices.SetAdbServerAddr(s.adb.addr()),
+		devices.SetPollInterval(10 * time.Millisecond),
+	}
+}
+
+func (s *DeviceTestSuite) TearDownTest(c *C) {
+	for _, restore := range s.restore {
+		restore()
+	}
+	s.adb.close()
+	s.fastboot.close()
+}
+
+func (s *DeviceTestSuite) TestWaitForState(c *C) {
+	var adb devices.UbuntuDebugBridge
+	adb.SetSerial("0123456789ABCDEF")
+	c.Assert(adb.WaitForState(context.Background(), devices.StateDevice), IsNil)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.adb.setState("recovery")
+	}()
+	c.Assert(adb.WaitForRecovery(), IsNil)
+}
+
+func (s *DeviceTestSuite) TestWaitForStateTimeout(c *C) {
+	var adb devices.AndroidDebugBridge
+	adb.SetSerial("0123456789ABCDEF")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := adb.WaitForState(ctx, devices.StateSideload)
+	c.Assert(err, FitsTypeOf, devices.ErrWait{})
+	c.Check(err, ErrorMatches, "device 0123456789ABCDEF did not reach sideload state \\(in device state\\): context deadline exceeded")
+
+	adb.SetSerial("missing")
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = adb.WaitForState(ctx, devices.StateDevice)
+	c.Check(err, ErrorMatches, "device missing did not reach device state \\(not attached\\): context canceled")
+}
+
+func (s *DeviceTestSuite) TestReboot(c *C) {
+	var device devices.Device
+	var adb devices.UbuntuDebugBridge
+	device = adb
+	c.Assert(device.Reboot(context.Background(), devices.TargetSystem), IsNil)
+	c.Assert(device.Reboot(context.Background(), devices.TargetBootloader), IsNil)
+	c.Check(s.adb.received(), DeepEquals, []string{
+		"host:transport-any", "shell:reboot",
+		"host:transport-any", "reboot:bootloader",
+	})
+}
+
+func (s *DeviceTestSuite) TestFastbootDevice(c *C) {
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("tcp:" + s.fastboot.addr())
+	var device devices.Device = fastboot
+	c.Check(device.Serial(), Equals, "tcp:"+s.fastboot.addr())
+	c.Assert(device.WaitForState(context.Background(), devices.StateBootloader), IsNil)
+	c.Check(device.WaitForState(context.Background(), devices.StateRecovery), NotNil)
+	c.Assert(device.Reboot(context.Background(), devices.TargetSystem), IsNil)
+	c.Check(s.fastboot.received(), DeepEquals, []string{"reboot"})
+}
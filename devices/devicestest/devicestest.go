@@ -0,0 +1,609 @@
+//
+// Fake devices to test code using the devices package
+//
+// The devices answer to the adb server protocol and to the fastboot
+// command, every command they receive is recorded as the adb or fastboot
+// command line that would have been used, e.g.; "adb -s 0123 reboot
+// recovery", and they move between states as real devices do when
+// rebooted.
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devicestest
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+// Device describes a fake device, it is owned by the Fake it is given to
+// and should only be inspected through it afterwards
+type Device struct {
+	Serial  string
+	Product string
+	Model   string
+	State   devices.State
+	// Vars holds the bootloader variables besides product
+	Vars map[string]string
+	// Shell holds the output of shell commands, other commands succeed
+	// without output
+	Shell map[string]string
+	// Exit holds the exit status of shell commands
+	Exit map[string]int
+	// Files holds the contents of the files on the device
+	Files map[string][]byte
+	// Dirs are directories that exist on the device even if empty
+	Dirs []string
+	// Fail makes fastboot commands starting with a key fail with the
+	// message it maps to, e.g.; "flash recovery": "Partition is locked"
+	Fail map[string]string
+
+	flashed map[string][]byte
+}
+
+// NewDevice returns a Device in state with /cache/recovery in place
+func NewDevice(serial, product string, state devices.State) *Device {
+	return &Device{
+		Serial:  serial,
+		Product: product,
+		Model:   product,
+		State:   state,
+		Vars:    make(map[string]string),
+		Shell:   make(map[string]string),
+		Exit:    make(map[string]int),
+		Files:   make(map[string][]byte),
+		Dirs:    []string{"/cache/recovery"},
+		Fail:    make(map[string]string),
+	}
+}
+
+// onAdb returns true if the device is seen by the adb server
+func (d *Device) onAdb() bool {
+	return d.State != devices.StateBootloader && d.State != ""
+}
+
+// Fake is an adb server and a devices.Runner for a set of fake devices
+type Fake struct {
+	l net.Listener
+
+	mu      sync.Mutex
+	devices []*Device
+	calls   []string
+}
+
+// New starts an adb server for devs, Install makes the devices package use
+// it
+func New(devs ...*Device) (*Fake, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	f := &Fake{l: l, devices: devs}
+	for _, d := range devs {
+		d.flashed = make(map[string][]byte)
+	}
+	go f.serve()
+	return f, nil
+}
+
+// Install points the devices package to f, the returned function undoes it
+func (f *Fake) Install() (restore func()) {
+	restoreAddr := devices.SetAdbServerAddr(f.l.Addr().String())
+	restoreRunner := devices.SetRunner(f)
+	return func() {
+		restoreRunner()
+		restoreAddr()
+	}
+}
+
+// Close stops the adb server
+func (f *Fake) Close() error {
+	return f.l.Close()
+}
+
+// Calls returns the commands received so far
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// State returns the state of the device with serial
+func (f *Fake) State(serial string) devices.State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d := f.lookup(serial); d != nil {
+		return d.State
+	}
+	return ""
+}
+
+// SetState moves the device with serial to state, e.g.; to authorize an
+// unauthorized device
+func (f *Fake) SetState(serial string, state devices.State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d := f.lookup(serial); d != nil {
+		d.State = state
+	}
+}
+
+// File returns the contents of p on the device with serial, nil if missing
+func (f *Fake) File(serial, p string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d := f.lookup(serial); d != nil {
+		return d.Files[p]
+	}
+	return nil
+}
+
+// Flashed returns what was last flashed to partition on the device with
+// serial, nil if nothing was
+func (f *Fake) Flashed(serial, partition string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d := f.lookup(serial); d != nil {
+		return d.flashed[partition]
+	}
+	return nil
+}
+
+func (f *Fake) lookup(serial string) *Device {
+	for _, d := range f.devices {
+		if d.Serial == serial {
+			return d
+		}
+	}
+	return nil
+}
+
+func (f *Fake) record(tool, serial string, args ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("%s -s %s %s", tool, serial, strings.Join(args, " ")))
+}
+
+// pick returns the device with serial, or the only one if serial is empty,
+// among the devices for which visible is true
+func (f *Fake) pick(serial string, visible func(*Device) bool) (*Device, error) {
+	var found []*Device
+	for _, d := range f.devices {
+		if visible(d) && (serial == "" || d.Serial == serial) {
+			found = append(found, d)
+		}
+	}
+	switch {
+	case len(found) == 1:
+		return found[0], nil
+	case len(found) > 1:
+		return nil, errors.New("more than one device/emulator")
+	case serial != "":
+		return nil, fmt.Errorf("device '%s' not found", serial)
+	}
+	return nil, errors.New("no devices/emulators found")
+}
+
+// LookPath finds the adb and fastboot commands
+func (f *Fake) LookPath(name string) (string, error) {
+	switch filepath.Base(name) {
+	case "adb", "fastboot":
+		return "/usr/bin/" + filepath.Base(name), nil
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+// Run runs the adb and fastboot commands against the fake devices
+func (f *Fake) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	switch filepath.Base(name) {
+	case "adb":
+		// the server is already running
+		return nil, nil
+	case "fastboot":
+		return f.fastboot(args)
+	}
+	return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+var errExit = errors.New("exit status 1")
+
+func (f *Fake) fastboot(args []string) ([]byte, error) {
+	var serial string
+	if len(args) > 1 && args[0] == "-s" {
+		serial, args = args[1], args[2:]
+	}
+	if len(args) == 0 {
+		return []byte("usage: fastboot [ <option> ] <command>\n"), errExit
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inBootloader := func(d *Device) bool { return d.State == devices.StateBootloader }
+	if args[0] == "devices" {
+		var out string
+		for _, d := range f.devices {
+			if inBootloader(d) {
+				out += d.Serial + "\tfastboot\n"
+			}
+		}
+		return []byte(out), nil
+	}
+
+	d, err := f.pick(serial, inBootloader)
+	if err != nil {
+		// fastboot waits for devices that are not in the bootloader
+		return []byte("< waiting for device >\n"), err
+	}
+	f.calls = append(f.calls, "fastboot -s "+d.Serial+" "+strings.Join(args, " "))
+	cmd := strings.Join(args, " ")
+	for prefix, msg := range d.Fail {
+		if strings.HasPrefix(cmd, prefix) {
+			return []byte(fmt.Sprintf("FAILED (remote: %s)\nfinished. total time: 0.001s\n", msg)), errExit
+		}
+	}
+
+	const finished = "finished. total time: 0.001s\n"
+	switch {
+	case cmd == "getvar all":
+		vars := map[string]string{"product": d.Product}
+		for k, v := range d.Vars {
+			vars[k] = v
+		}
+		var lines []string
+		for k, v := range vars {
+			lines = append(lines, fmt.Sprintf("(bootloader) %s:%s\n", k, v))
+		}
+		sort.Strings(lines)
+		return []byte(strings.Join(lines, "") + "all: \n" + finished), nil
+	case args[0] == "getvar" && len(args) == 2:
+		v, ok := d.Vars[args[1]]
+		if args[1] == "product" {
+			v, ok = d.Product, true
+		}
+		if !ok {
+			return []byte(args[1] + ": \n" + finished), nil
+		}
+		return []byte(fmt.Sprintf("%s: %s\n%s", args[1], v, finished)), nil
+	case args[0] == "flash" && len(args) == 3:
+		data, err := ioutil.ReadFile(args[2])
+		if err != nil {
+			return []byte(fmt.Sprintf("error: cannot load '%s'\n", args[2])), errExit
+		}
+		d.flashed[args[1]] = data
+		return []byte(fmt.Sprintf("sending '%s'...\nOKAY\nwriting '%[1]s'...\nOKAY\n%s", args[1], finished)), nil
+	case (args[0] == "format" || args[0] == "erase") && len(args) == 2:
+		delete(d.flashed, args[1])
+	case args[0] == "boot" && len(args) == 2:
+		// booting an image from the bootloader is used to get to recovery
+		d.State = devices.StateRecovery
+	case cmd == "reboot", cmd == "continue":
+		d.State = devices.StateDevice
+	case cmd == "reboot-bootloader":
+	case args[0] == "reboot" && len(args) == 2:
+		d.State = devices.State(args[1])
+	case args[0] == "set_active" && len(args) == 2:
+		d.Vars["current-slot"] = args[1]
+	case cmd == "flashing unlock", cmd == "oem unlock":
+		d.Vars["unlocked"] = "yes"
+	case args[0] == "oem", args[0] == "flashing":
+	default:
+		return []byte(fmt.Sprintf("fastboot: unknown command %s\n", args[0])), errExit
+	}
+	return []byte(finished), nil
+}
+
+func (f *Fake) serve() {
+	for {
+		conn, err := f.l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			f.handle(bufio.NewReader(conn), conn)
+		}()
+	}
+}
+
+func okay(w io.Writer, reply ...string) {
+	io.WriteString(w, "OKAY")
+	for _, r := range reply {
+		fmt.Fprintf(w, "%04x%s", len(r), r)
+	}
+}
+
+func fail(w io.Writer, msg string) {
+	fmt.Fprintf(w, "FAIL%04x%s", len(msg), msg)
+}
+
+// online returns true for devices adbd accepts services on
+func online(d *Device) bool {
+	return d.onAdb() && d.State != devices.StateUnauthorized && d.State != devices.StateOffline
+}
+
+// handle answers the requests of a client of the adb server, once a
+// transport is selected the requests go to the device
+func (f *Fake) handle(r *bufio.Reader, w io.Writer) {
+	var d *Device
+	for {
+		hexLen := make([]byte, 4)
+		if _, err := io.ReadFull(r, hexLen); err != nil {
+			return
+		}
+		n, _ := strconv.ParseUint(string(hexLen), 16, 16)
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+		req := string(buf)
+
+		if d != nil {
+			f.service(d, r, w, req)
+			return
+		}
+
+		switch {
+		case req == "host:version":
+			okay(w, "0029")
+			return
+		case req == "host:devices-l":
+			var out string
+			f.mu.Lock()
+			for _, d := range f.devices {
+				if d.onAdb() {
+					out += fmt.Sprintf("%s\t%s usb:1-1 product:%s model:%s device:%[3]s\n", d.Serial, d.State, d.Product, d.Model)
+				}
+			}
+			f.mu.Unlock()
+			okay(w, out)
+			return
+		case strings.HasSuffix(req, ":features"):
+			okay(w, "cmd,shell_v2")
+			return
+		case req == "host:transport-any", strings.HasPrefix(req, "host:transport:"):
+			f.mu.Lock()
+			dev, err := f.pick(strings.TrimPrefix(strings.TrimPrefix(req, "host:transport-any"), "host:transport:"), (*Device).onAdb)
+			if err == nil && dev.State == devices.StateUnauthorized {
+				err = errors.New("device unauthorized.\nPlease check the confirmation dialog on your device.")
+			} else if err == nil && dev.State == devices.StateOffline {
+				err = errors.New("device offline")
+			}
+			f.mu.Unlock()
+			if err != nil {
+				fail(w, err.Error())
+				return
+			}
+			d = dev
+			okay(w)
+		case strings.HasSuffix(req, "wait-for-any-device"):
+			serial := strings.TrimSuffix(strings.TrimPrefix(req, "host-serial:"), ":wait-for-any-device")
+			if req == "host:wait-for-any-device" {
+				serial = ""
+			}
+			okay(w)
+			for {
+				f.mu.Lock()
+				_, err := f.pick(serial, online)
+				f.mu.Unlock()
+				if err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			okay(w)
+			return
+		default:
+			fail(w, "unknown host service")
+			return
+		}
+	}
+}
+
+// UnwrapShell returns the command run by the legacy shell: service, the
+// devices package runs it through sh -c and echoes its exit status after
+// it, wrapped is false for commands sent as is
+func UnwrapShell(service string) (cmd string, wrapped bool) {
+	cmd = strings.TrimPrefix(service, "shell:")
+	i := strings.LastIndex(cmd, "; echo \"")
+	if i < 0 {
+		return cmd, false
+	}
+	cmd = strings.TrimPrefix(cmd[:i], "sh -c '")
+	return strings.Replace(strings.TrimSuffix(cmd, "'"), `'\''`, "'", -1), true
+}
+
+// WriteShellExit writes the exit status trailer of a command UnwrapShell
+// reported as wrapped
+func WriteShellExit(w io.Writer, exit int) {
+	fmt.Fprintf(w, "\x1fexit:%d\r\n", exit)
+}
+
+// service runs req on the device d
+func (f *Fake) service(d *Device, r io.Reader, w io.Writer, req string) {
+	switch {
+	case strings.HasPrefix(req, "shell,v2,raw:"):
+		cmd := strings.TrimPrefix(req, "shell,v2,raw:")
+		f.record("adb", d.Serial, "shell", cmd)
+		out, exit := f.shell(d, cmd)
+		okay(w)
+		if out != "" {
+			shellPacket(w, 1, out)
+		}
+		shellPacket(w, 3, string([]byte{byte(exit)}))
+		// wait for the client to hang up
+		io.Copy(ioutil.Discard, r)
+	case strings.HasPrefix(req, "shell:"):
+		cmd, wrapped := UnwrapShell(req)
+		f.record("adb", d.Serial, "shell", cmd)
+		out, exit := f.shell(d, cmd)
+		okay(w)
+		io.WriteString(w, out)
+		if wrapped {
+			WriteShellExit(w, exit)
+		}
+	case strings.HasPrefix(req, "reboot:"):
+		target := strings.TrimPrefix(req, "reboot:")
+		f.record("adb", d.Serial, strings.TrimSpace("reboot "+target))
+		f.reboot(d, target)
+		okay(w)
+	case req == "sync:":
+		okay(w)
+		f.sync(d, r, w)
+	case strings.HasPrefix(req, "tcpip:"):
+		f.record("adb", d.Serial, "tcpip", strings.TrimPrefix(req, "tcpip:"))
+		okay(w)
+		fmt.Fprintf(w, "restarting in TCP mode port: %s\n", strings.TrimPrefix(req, "tcpip:"))
+	case req == "usb:":
+		f.record("adb", d.Serial, "usb")
+		okay(w)
+		io.WriteString(w, "restarting in USB mode\n")
+	default:
+		fail(w, "unknown service "+req)
+	}
+}
+
+// shell returns the output and exit status of cmd, reboot behaves as on
+// Ubuntu devices
+func (f *Fake) shell(d *Device, cmd string) (string, int) {
+	if cmd == "reboot" {
+		f.reboot(d, devices.TargetSystem)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return d.Shell[cmd], d.Exit[cmd]
+}
+
+func (f *Fake) reboot(d *Device, target string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch target {
+	case devices.TargetSystem:
+		d.State = devices.StateDevice
+	case devices.TargetBootloader:
+		d.State = devices.StateBootloader
+	default:
+		d.State = devices.State(target)
+	}
+}
+
+func shellPacket(w io.Writer, id byte, data string) {
+	w.Write([]byte{id})
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	io.WriteString(w, data)
+}
+
+func syncPacket(w io.Writer, id string, values ...uint32) {
+	io.WriteString(w, id)
+	binary.Write(w, binary.LittleEndian, values)
+}
+
+// stat returns the mode and size of p on d
+func (f *Fake) stat(d *Device, p string) (mode, size uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p = path.Clean(p)
+	if data, ok := d.Files[p]; ok {
+		return 0100644, uint32(len(data))
+	}
+	for _, dir := range d.Dirs {
+		if path.Clean(dir) == p || strings.HasPrefix(dir, p+"/") {
+			return 040755, 4096
+		}
+	}
+	for name := range d.Files {
+		if strings.HasPrefix(name, p+"/") || p == "/" {
+			return 040755, 4096
+		}
+	}
+	return 0, 0
+}
+
+// sync answers the requests of the sync service, directories are not
+// listed as none of the flows need it
+func (f *Fake) sync(d *Device, r io.Reader, w io.Writer) {
+	const mtime = 1400000000
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		arg := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return
+		}
+		p := string(arg)
+
+		switch string(hdr[:4]) {
+		case "STAT":
+			mode, size := f.stat(d, p)
+			syncPacket(w, "STAT", mode, size, mtime)
+		case "LIST":
+			syncPacket(w, "DONE", 0, 0, 0, 0)
+		case "SEND":
+			dst := p[:strings.LastIndex(p, ",")]
+			f.record("adb", d.Serial, "push", dst)
+			var data []byte
+			for {
+				if _, err := io.ReadFull(r, hdr); err != nil {
+					return
+				}
+				if string(hdr[:4]) == "DONE" {
+					break
+				}
+				chunk := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+				if _, err := io.ReadFull(r, chunk); err != nil {
+					return
+				}
+				data = append(data, chunk...)
+			}
+			f.mu.Lock()
+			d.Files[dst] = data
+			f.mu.Unlock()
+			syncPacket(w, "OKAY", 0)
+		case "RECV":
+			f.record("adb", d.Serial, "pull", p)
+			f.mu.Lock()
+			data, ok := d.Files[p]
+			f.mu.Unlock()
+			if !ok {
+				msg := "No such file or directory"
+				syncPacket(w, "FAIL", uint32(len(msg)))
+				io.WriteString(w, msg)
+				continue
+			}
+			syncPacket(w, "DATA", uint32(len(data)))
+			w.Write(data)
+			syncPacket(w, "DONE", 0)
+		case "QUIT":
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+//
+// Fake devices to test code using the devices package
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devicestest_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+	"launchpad.net/goget-ubuntu-touch/devices/devicestest"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type FakeTestSuite struct {
+	fake    *devicestest.Fake
+	restore func()
+}
+
+var _ = Suite(&FakeTestSuite{})
+
+func (s *FakeTestSuite) SetUpTest(c *C) {
+	mako := devicestest.NewDevice("0123456789ABCDEF", "mako", devices.StateBootloader)
+	mako.Vars["unlocked"] = "yes"
+	flo := devicestest.NewDevice("FLO0001", "flo", devices.StateUnauthorized)
+	var err error
+	s.fake, err = devicestest.New(mako, flo)
+	c.Assert(err, IsNil)
+	s.restore = s.fake.Install()
+}
+
+func (s *FakeTestSuite) TearDownTest(c *C) {
+	s.restore()
+	s.fake.Close()
+}
+
+func (s *FakeTestSuite) TestList(c *C) {
+	attached, err := devices.List()
+	c.Assert(err, IsNil)
+	c.Assert(attached, HasLen, 2)
+	c.Check(attached[0].Serial, Equals, "FLO0001")
+	c.Check(attached[0].State, Equals, devices.StateUnauthorized)
+	c.Check(attached[1].Serial, Equals, "0123456789ABCDEF")
+	c.Check(attached[1].State, Equals, devices.StateBootloader)
+	c.Check(attached[1].Product, Equals, "mako")
+}
+
+func (s *FakeTestSuite) TestSelectOnlyQueriesSelected(c *C) {
+	dev, err := devices.Select("FLO0001")
+	c.Assert(err, IsNil)
+	c.Check(dev.State, Equals, devices.StateUnauthorized)
+	c.Check(s.fake.Calls(), HasLen, 0)
+
+	dev, err = devices.Select("0123456789ABCDEF")
+	c.Assert(err, IsNil)
+	c.Check(dev.Product, Equals, "mako")
+	c.Check(s.fake.Calls(), DeepEquals, []string{"fastboot -s 0123456789ABCDEF getvar product"})
+
+	_, err = devices.Select("")
+	c.Check(err, FitsTypeOf, devices.ErrMultipleDevices{})
+	c.Check(s.fake.Calls(), HasLen, 1)
+}
+
+func (s *FakeTestSuite) TestBootstrapFlow(c *C) {
+	recovery := filepath.Join(c.MkDir(), "recovery.img")
+	c.Assert(ioutil.WriteFile(recovery, []byte("ANDROID!"), 0644), IsNil)
+
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("0123456789ABCDEF")
+	c.Assert(fastboot.CheckUnlocked(), IsNil)
+	c.Assert(fastboot.Flash("recovery", recovery), IsNil)
+	c.Assert(fastboot.Format("cache"), IsNil)
+	c.Assert(fastboot.BootImage(recovery), IsNil)
+	c.Check(s.fake.Flashed("0123456789ABCDEF", "recovery"), DeepEquals, []byte("ANDROID!"))
+
+	var adb devices.UbuntuDebugBridge
+	adb.SetSerial("0123456789ABCDEF")
+	c.Assert(adb.WaitForRecovery(), IsNil)
+	c.Assert(adb.Push(recovery, "/cache/recovery/"), IsNil)
+	c.Check(s.fake.File("0123456789ABCDEF", "/cache/recovery/recovery.img"), DeepEquals, []byte("ANDROID!"))
+	c.Assert(adb.RebootBootloader(), IsNil)
+	c.Check(s.fake.State("0123456789ABCDEF"), Equals, devices.StateBootloader)
+
+	c.Check(s.fake.Calls(), DeepEquals, []string{
+		"fastboot -s 0123456789ABCDEF getvar all",
+		"fastboot -s 0123456789ABCDEF flash recovery " + recovery,
+		"fastboot -s 0123456789ABCDEF format cache",
+		"fastboot -s 0123456789ABCDEF boot " + recovery,
+		"adb -s 0123456789ABCDEF push /cache/recovery/recovery.img",
+		"adb -s 0123456789ABCDEF reboot bootloader",
+	})
+}
+
+func (s *FakeTestSuite) TestFastbootFailures(c *C) {
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("FLO0001")
+	err := fastboot.Format("cache")
+	c.Assert(err, FitsTypeOf, devices.ErrFastboot{})
+
+	s.fake.SetState("FLO0001", devices.StateBootloader)
+	c.Assert(fastboot.Format("cache"), IsNil)
+	c.Check(fastboot.CheckUnlocked(), FitsTypeOf, devices.ErrLockUnknown{})
+}
+
+func (s *FakeTestSuite) TestUnauthorized(c *C) {
+	var adb devices.UbuntuDebugBridge
+	adb.SetSerial("FLO0001")
+	c.Check(adb.Ping(), ErrorMatches, `(?s).*device unauthorized.*`)
+
+	s.fake.SetState("FLO0001", devices.StateDevice)
+	c.Assert(adb.Ping(), IsNil)
+	c.Assert(adb.Reboot(context.Background(), devices.TargetSystem), IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Assert(adb.WaitForState(ctx, devices.StateDevice), IsNil)
+	c.Check(s.fake.Calls(), DeepEquals, []string{
+		"adb -s FLO0001 shell ls",
+		"adb -s FLO0001 shell reboot",
+	})
+}
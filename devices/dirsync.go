@@ -0,0 +1,358 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SyncOptions tune how SyncPush and SyncPull compare and copy files
+type SyncOptions struct {
+	// Exclude holds path.Match patterns checked against the path of each
+	// file relative to the synced directory and against its name
+	Exclude []string
+	// Checksum compares files by their sha256 instead of by their size
+	// and modification time
+	Checksum bool
+	// Verify checks the sha256 of each copied file on both ends
+	Verify bool
+	// Progress is called once each file has been handled
+	Progress func(SyncProgress)
+}
+
+// SyncProgress reports on a file handled while syncing
+type SyncProgress struct {
+	Path        string
+	Transferred bool
+	Files       int
+	TotalFiles  int
+}
+
+// SyncResult lists the relative paths of the files that were copied
+// and of those that were already up to date
+type SyncResult struct {
+	Transferred []string
+	Skipped     []string
+	Bytes       int64
+}
+
+// excluded returns true if rel, a slash separated relative path, matches
+// any of the patterns
+func excluded(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if m, _ := path.Match(pattern, rel); m {
+			return true
+		}
+		if m, _ := path.Match(pattern, path.Base(rel)); m {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes s for the shell on the device
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// fileSha256 returns the hex encoded sha256 of the local file p
+func fileSha256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteSha256 returns the sha256 of the files found under root on the
+// device keyed by their path relative to root
+func (adb AndroidDebugBridge) remoteSha256(root string, files ...string) (map[string]string, error) {
+	cmd := []string{"find", shellQuote(root), "-type", "f", "-exec", "sha256sum", "{}", "+"}
+	if len(files) != 0 {
+		cmd = []string{"sha256sum"}
+		for _, f := range files {
+			cmd = append(cmd, shellQuote(path.Join(root, f)))
+		}
+	}
+	out, err := adb.Shell(cmd...)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), "  ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		if rel := strings.TrimPrefix(fields[1], root+"/"); rel != fields[1] {
+			sums[rel] = fields[0]
+		}
+	}
+	return sums, scanner.Err()
+}
+
+// verify compares the sha256 of rel on the device with the local file p
+func (adb AndroidDebugBridge) verify(root, rel, p string) error {
+	sums, err := adb.remoteSha256(root, rel)
+	if err != nil {
+		return err
+	}
+	sum, err := fileSha256(p)
+	if err != nil {
+		return err
+	}
+	if sums[rel] != sum {
+		return ErrChecksum{path.Join(root, rel), sum, sums[rel]}
+	}
+	return nil
+}
+
+// listTree returns the regular files under root on the device keyed by
+// their path relative to root
+func (sc *syncConn) listTree(root string) (map[string]remoteFile, error) {
+	files := make(map[string]remoteFile)
+	f, err := sc.stat(root)
+	if err != nil || !f.exists() {
+		return files, err
+	}
+	if !f.isDir() {
+		return nil, fmt.Errorf("%s is not a directory on the device", root)
+	}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := sc.list(path.Join(root, dir))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.name == "." || e.name == ".." || strings.Contains(e.name, "/") {
+				continue
+			}
+			rel := path.Join(dir, e.name)
+			switch {
+			case e.isDir():
+				if err := walk(rel); err != nil {
+					return err
+				}
+			case e.mode&modeTypeMask == modeRegular:
+				files[rel] = e
+			}
+		}
+		return nil
+	}
+	return files, walk("")
+}
+
+// localTree returns the regular files under root keyed by their slash
+// separated path relative to root, excluded directories are not entered
+func localTree(root string, exclude []string) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if excluded(rel, exclude) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			files[rel] = fi
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	return files, err
+}
+
+// unchanged returns true if the remote file matches the local one by
+// size and modification time, adbd keeps mtimes to the second
+func unchanged(fi os.FileInfo, f remoteFile) bool {
+	return int64(f.size) == fi.Size() && f.mtime.Unix() == fi.ModTime().Unix()
+}
+
+// sortedKeys returns the relative paths in files in order
+func sortedKeys(files map[string]os.FileInfo) []string {
+	var keys []string
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SyncPush copies the local directory src to dst on the device, files
+// that are already up to date on the device are not transferred
+func (adb AndroidDebugBridge) SyncPush(src, dst string, opts SyncOptions) (result SyncResult, err error) {
+	if fi, err := os.Stat(src); err != nil {
+		return result, err
+	} else if !fi.IsDir() {
+		return result, fmt.Errorf("%s is not a directory", src)
+	}
+	local, err := localTree(src, opts.Exclude)
+	if err != nil {
+		return result, err
+	}
+	sc, err := openSync(adb.serial)
+	if err != nil {
+		return result, err
+	}
+	defer sc.Close()
+	remote, err := sc.listTree(dst)
+	if err != nil {
+		return result, err
+	}
+	var sums map[string]string
+	if opts.Checksum && len(remote) != 0 {
+		if sums, err = adb.remoteSha256(dst); err != nil {
+			return result, err
+		}
+	}
+
+	paths := sortedKeys(local)
+	for i, rel := range paths {
+		p := filepath.Join(src, filepath.FromSlash(rel))
+		fi := local[rel]
+		f, exists := remote[rel]
+		skip := exists && unchanged(fi, f)
+		if opts.Checksum {
+			sum, err := fileSha256(p)
+			if err != nil {
+				return result, err
+			}
+			skip = exists && sums[rel] == sum
+		}
+
+		if skip {
+			result.Skipped = append(result.Skipped, rel)
+		} else {
+			if err := sc.pushFile(p, path.Join(dst, rel)); err != nil {
+				return result, err
+			}
+			if opts.Verify {
+				if err := adb.verify(dst, rel, p); err != nil {
+					return result, err
+				}
+			}
+			result.Transferred = append(result.Transferred, rel)
+			result.Bytes += fi.Size()
+		}
+		if opts.Progress != nil {
+			opts.Progress(SyncProgress{rel, !skip, i + 1, len(paths)})
+		}
+	}
+	return result, nil
+}
+
+// SyncPull copies the directory src on the device to the local directory
+// dst, files that are already up to date locally are not transferred
+func (adb AndroidDebugBridge) SyncPull(src, dst string, opts SyncOptions) (result SyncResult, err error) {
+	sc, err := openSync(adb.serial)
+	if err != nil {
+		return result, err
+	}
+	defer sc.Close()
+	f, err := sc.stat(src)
+	if err != nil {
+		return result, err
+	}
+	if !f.exists() {
+		return result, fmt.Errorf("remote object '%s' does not exist", src)
+	}
+	remote, err := sc.listTree(src)
+	if err != nil {
+		return result, err
+	}
+	var paths []string
+	for rel := range remote {
+		if !excluded(rel, opts.Exclude) {
+			paths = append(paths, rel)
+		}
+	}
+	sort.Strings(paths)
+	var sums map[string]string
+	if opts.Checksum && len(paths) != 0 {
+		if sums, err = adb.remoteSha256(src); err != nil {
+			return result, err
+		}
+	}
+
+	for i, rel := range paths {
+		p := filepath.Join(dst, filepath.FromSlash(rel))
+		rf := remote[rel]
+		skip := false
+		if fi, err := os.Stat(p); err == nil {
+			skip = unchanged(fi, rf)
+			if opts.Checksum {
+				sum, err := fileSha256(p)
+				if err != nil {
+					return result, err
+				}
+				skip = sums[rel] == sum
+			}
+		}
+
+		if skip {
+			result.Skipped = append(result.Skipped, rel)
+		} else {
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return result, err
+			}
+			if err := sc.pullFile(path.Join(src, rel), p, rf.mode); err != nil {
+				return result, err
+			}
+			if err := os.Chtimes(p, rf.mtime, rf.mtime); err != nil {
+				return result, err
+			}
+			if opts.Verify {
+				if err := adb.verify(src, rel, p); err != nil {
+					return result, err
+				}
+			}
+			result.Transferred = append(result.Transferred, rel)
+			result.Bytes += int64(rf.size)
+		}
+		if opts.Progress != nil {
+			opts.Progress(SyncProgress{rel, !skip, i + 1, len(paths)})
+		}
+	}
+	return result, nil
+}
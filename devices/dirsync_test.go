@@ -0,0 +1,130 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type DirSyncTestSuite struct {
+	server  *fakeAdbServer
+	restore func()
+	adb     devices.AndroidDebugBridge
+	src     string
+}
+
+var _ = Suite(&DirSyncTestSuite{})
+
+func (s *DirSyncTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeAdbServer()
+	c.Assert(err, IsNil)
+	s.restore = devices.SetAdbServerAddr(s.server.addr())
+
+	s.src = c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(s.src, "data", "cache"), 0755), IsNil)
+	for name, contents := range map[string]string{
+		"app.click":          "app",
+		"data/test.json":     "{}",
+		"data/cache/tmp.bin": "cache",
+		"data/notes.swp":     "swap",
+	} {
+		c.Assert(ioutil.WriteFile(filepath.Join(s.src, filepath.FromSlash(name)), []byte(contents), 0644), IsNil)
+	}
+}
+
+func (s *DirSyncTestSuite) TearDownTest(c *C) {
+	s.restore()
+	s.server.close()
+}
+
+func (s *DirSyncTestSuite) TestSyncPushIncremental(c *C) {
+	var progress []devices.SyncProgress
+	opts := devices.SyncOptions{
+		Exclude:  []string{"*.swp", "data/cache"},
+		Progress: func(p devices.SyncProgress) { progress = append(progress, p) },
+	}
+	result, err := s.adb.SyncPush(s.src, "/home/phablet/app", opts)
+	c.Assert(err, IsNil)
+	c.Check(result.Transferred, DeepEquals, []string{"app.click", "data/test.json"})
+	c.Check(result.Bytes, Equals, int64(5))
+	c.Check(string(s.server.files["/home/phablet/app/data/test.json"]), Equals, "{}")
+	c.Check(s.server.files["/home/phablet/app/data/notes.swp"], IsNil)
+	c.Check(progress, DeepEquals, []devices.SyncProgress{
+		{Path: "app.click", Transferred: true, Files: 1, TotalFiles: 2},
+		{Path: "data/test.json", Transferred: true, Files: 2, TotalFiles: 2},
+	})
+
+	// only what changed goes over the second time
+	later := time.Now().Add(time.Hour)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.src, "app.click"), []byte("app2"), 0644), IsNil)
+	c.Assert(os.Chtimes(filepath.Join(s.src, "app.click"), later, later), IsNil)
+	opts.Progress = nil
+	result, err = s.adb.SyncPush(s.src, "/home/phablet/app", opts)
+	c.Assert(err, IsNil)
+	c.Check(result.Transferred, DeepEquals, []string{"app.click"})
+	c.Check(result.Skipped, DeepEquals, []string{"data/test.json"})
+	c.Check(string(s.server.files["/home/phablet/app/app.click"]), Equals, "app2")
+}
+
+func (s *DirSyncTestSuite) TestSyncPushChecksum(c *C) {
+	s.server.files["/home/phablet/app/app.click"] = []byte("app")
+	s.server.files["/home/phablet/app/data/test.json"] = []byte("[]")
+	opts := devices.SyncOptions{Exclude: []string{"*.swp", "cache"}, Checksum: true, Verify: true}
+	result, err := s.adb.SyncPush(s.src, "/home/phablet/app", opts)
+	c.Assert(err, IsNil)
+	c.Check(result.Transferred, DeepEquals, []string{"data/test.json"})
+	c.Check(result.Skipped, DeepEquals, []string{"app.click"})
+}
+
+func (s *DirSyncTestSuite) TestSyncPushVerifyFails(c *C) {
+	s.server.shell["sha256sum '/tmp/app/app.click'"] = "0000  /tmp/app/app.click\n"
+	opts := devices.SyncOptions{Exclude: []string{"data"}, Verify: true}
+	_, err := s.adb.SyncPush(s.src, "/tmp/app", opts)
+	c.Assert(err, FitsTypeOf, devices.ErrChecksum{})
+	c.Check(err, ErrorMatches, "checksum mismatch for /tmp/app/app.click on the device: expected sha256 [0-9a-f]{64}, got 0000")
+}
+
+func (s *DirSyncTestSuite) TestSyncPull(c *C) {
+	s.server.files["/home/phablet/logs/a.log"] = []byte("a")
+	s.server.files["/home/phablet/logs/old/b.log"] = []byte("bb")
+	s.server.files["/home/phablet/logs/core"] = []byte("core")
+	dst := filepath.Join(c.MkDir(), "logs")
+
+	opts := devices.SyncOptions{Exclude: []string{"core"}, Verify: true}
+	result, err := s.adb.SyncPull("/home/phablet/logs", dst, opts)
+	c.Assert(err, IsNil)
+	c.Check(result.Transferred, DeepEquals, []string{"a.log", "old/b.log"})
+	data, err := ioutil.ReadFile(filepath.Join(dst, "old", "b.log"))
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "bb")
+
+	result, err = s.adb.SyncPull("/home/phablet/logs", dst, opts)
+	c.Assert(err, IsNil)
+	c.Check(result.Transferred, IsNil)
+	c.Check(result.Skipped, DeepEquals, []string{"a.log", "old/b.log"})
+
+	_, err = s.adb.SyncPull("/missing", dst, opts)
+	c.Check(err, ErrorMatches, "remote object '/missing' does not exist")
+}
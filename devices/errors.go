@@ -0,0 +1,145 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrAdb represents a request the adb server or the device refused
+type ErrAdb struct {
+	request string
+	message string
+}
+
+func (e ErrAdb) Error() string {
+	return fmt.Sprintf("adb request %q failed: %s", e.request, e.message)
+}
+
+// ErrFastboot represents a command the bootloader answered with FAIL
+type ErrFastboot struct {
+	command string
+	message string
+}
+
+func (e ErrFastboot) Error() string {
+	return fmt.Sprintf("fastboot command %q failed: %s", e.command, e.message)
+}
+
+// ErrNoDevice represents a device that is not attached, serial is empty
+// when no device at all is attached
+type ErrNoDevice struct {
+	serial string
+}
+
+func (e ErrNoDevice) Error() string {
+	if e.serial == "" {
+		return "no devices attached"
+	}
+	return fmt.Sprintf("device %s is not attached", e.serial)
+}
+
+// ErrMultipleDevices represents more than one device being attached when
+// no serial was given to select one
+type ErrMultipleDevices struct {
+	serials []string
+}
+
+func (e ErrMultipleDevices) Error() string {
+	return fmt.Sprintf("more than one device attached (%s), select one by serial", strings.Join(e.serials, ", "))
+}
+
+// ErrWait represents a device that did not reach the state it was waited
+// for, last is the state it was last seen in
+type ErrWait struct {
+	serial string
+	state  State
+	last   State
+	err    error
+}
+
+func (e ErrWait) Error() string {
+	device := "device"
+	if e.serial != "" {
+		device += " " + e.serial
+	}
+	last := "not attached"
+	if e.last != "" {
+		last = "in " + string(e.last) + " state"
+	}
+	return fmt.Sprintf("%s did not reach %s state (%s): %s", device, e.state, last, e.err)
+}
+
+// ErrLocked represents a device with a bootloader that refuses flashing
+type ErrLocked struct {
+	product string
+}
+
+func (e ErrLocked) Error() string {
+	device := "the device"
+	if e.product != "" {
+		device = e.product
+	}
+	return fmt.Sprintf("the bootloader of %s is locked, unlock it with 'fastboot flashing unlock' or 'fastboot oem unlock' first", device)
+}
+
+// ErrLockUnknown represents a device with a bootloader that does not
+// report if it accepts flashing
+type ErrLockUnknown struct {
+	product string
+}
+
+func (e ErrLockUnknown) Error() string {
+	device := "the device"
+	if e.product != "" {
+		device = e.product
+	}
+	return fmt.Sprintf("the bootloader of %s does not report if it is unlocked", device)
+}
+
+// ErrShellExit represents a command that failed on the device
+type ErrShellExit struct {
+	command  string
+	exitCode int
+	stderr   string
+}
+
+func (e ErrShellExit) Error() string {
+	msg := fmt.Sprintf("%q exited with status %d on the device", e.command, e.exitCode)
+	if e.stderr != "" {
+		msg += ": " + e.stderr
+	}
+	return msg
+}
+
+// ErrChecksum represents a file that differs between the host and the
+// device after being copied
+type ErrChecksum struct {
+	path     string
+	expected string
+	actual   string
+}
+
+func (e ErrChecksum) Error() string {
+	actual := e.actual
+	if actual == "" {
+		actual = "none"
+	}
+	return fmt.Sprintf("checksum mismatch for %s on the device: expected sha256 %s, got %s", e.path, e.expected, actual)
+}
@@ -0,0 +1,46 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"time"
+)
+
+// SetFastbootCommand replaces the fastboot command that is run
+func SetFastbootCommand(cmd string) (restore func()) {
+	old := fastbootCommand
+	fastbootCommand = cmd
+	return func() { fastbootCommand = old }
+}
+
+// SetPollInterval changes how often the state of devices is checked
+func SetPollInterval(interval time.Duration) (restore func()) {
+	old := pollInterval
+	pollInterval = interval
+	return func() { pollInterval = old }
+}
+
+const FastbootChunkSize = fastbootChunkSize
+
+var (
+	ParseFastbootDevices = parseFastbootDevices
+	SelectDevice         = selectDevice
+	ParseGetvarAll       = parseGetvarAll
+	NewDeviceInfo        = newDeviceInfo
+	WaitForDevice        = waitForDevice
+)
@@ -0,0 +1,425 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"launchpad.net/goget-ubuntu-touch/devices/devicestest"
+)
+
+// fakeAdbServer is a stand in for the adb server and the adbd of the
+// devices attached to it, files holds the contents of the devices
+type fakeAdbServer struct {
+	l       net.Listener
+	serials []string
+	// shell v2 support, and the output and exit status of commands
+	v2     bool
+	shell  map[string]string
+	stderr map[string]string
+	exit   map[string]int
+	files  map[string][]byte
+	mtimes map[string]uint32
+
+	mu       sync.Mutex
+	state    string
+	requests []string
+}
+
+func newFakeAdbServer() (*fakeAdbServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &fakeAdbServer{
+		l:       l,
+		serials: []string{"0123456789ABCDEF"},
+		state:   "device",
+		v2:      true,
+		shell:   make(map[string]string),
+		stderr:  make(map[string]string),
+		exit:    make(map[string]int),
+		files:   make(map[string][]byte),
+		mtimes:  make(map[string]uint32),
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *fakeAdbServer) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *fakeAdbServer) close() {
+	s.l.Close()
+}
+
+// setState changes the state the devices are reported in
+func (s *fakeAdbServer) setState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+}
+
+// received returns the requests made to the server
+func (s *fakeAdbServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *fakeAdbServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			s.handle(bufio.NewReader(conn), conn)
+		}()
+	}
+}
+
+func okay(w io.Writer, reply ...string) {
+	io.WriteString(w, "OKAY")
+	for _, r := range reply {
+		fmt.Fprintf(w, "%04x%s", len(r), r)
+	}
+}
+
+func fail(w io.Writer, msg string) {
+	fmt.Fprintf(w, "FAIL%04x%s", len(msg), msg)
+}
+
+func (s *fakeAdbServer) handle(r *bufio.Reader, w io.Writer) {
+	for {
+		hexLen := make([]byte, 4)
+		if _, err := io.ReadFull(r, hexLen); err != nil {
+			return
+		}
+		n, _ := strconv.ParseUint(string(hexLen), 16, 16)
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+		req := string(buf)
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+
+		switch {
+		case req == "host:version":
+			okay(w, "0029")
+			return
+		case req == "host:devices-l":
+			var out string
+			s.mu.Lock()
+			for _, serial := range s.serials {
+				out += serial + "          " + s.state + " usb:1-1 product:bacon model:A0001 device:A0001\n"
+			}
+			s.mu.Unlock()
+			okay(w, out)
+			return
+		case req == "host:transport-any":
+			okay(w)
+		case strings.HasPrefix(req, "host:transport:"):
+			if !s.known(strings.TrimPrefix(req, "host:transport:")) {
+				fail(w, "device not found")
+				return
+			}
+			okay(w)
+		case strings.HasSuffix(req, ":features"):
+			if s.v2 {
+				okay(w, "cmd,shell_v2,stat_v2")
+			} else {
+				okay(w, "cmd")
+			}
+			return
+		case strings.HasPrefix(req, "shell,v2,raw:"):
+			okay(w)
+			s.shellV2(r, w, strings.TrimPrefix(req, "shell,v2,raw:"))
+			return
+		case strings.HasPrefix(req, "host:connect:"):
+			addr := strings.TrimPrefix(req, "host:connect:")
+			switch {
+			case strings.HasPrefix(addr, "unreachable"):
+				okay(w, "failed to connect to "+addr)
+			case s.known(addr):
+				okay(w, "already connected to "+addr)
+			default:
+				s.mu.Lock()
+				s.serials = append(s.serials, addr)
+				s.mu.Unlock()
+				okay(w, "connected to "+addr)
+			}
+			return
+		case strings.HasPrefix(req, "host:disconnect:"):
+			addr := strings.TrimPrefix(req, "host:disconnect:")
+			if !s.known(addr) {
+				okay(w, fmt.Sprintf("error: no such device '%s'", addr))
+				return
+			}
+			s.mu.Lock()
+			var serials []string
+			for _, serial := range s.serials {
+				if serial != addr {
+					serials = append(serials, serial)
+				}
+			}
+			s.serials = serials
+			s.mu.Unlock()
+			okay(w, "disconnected "+addr)
+			return
+		case strings.HasPrefix(req, "tcpip:"):
+			okay(w)
+			fmt.Fprintf(w, "restarting in TCP mode port: %s\n", strings.TrimPrefix(req, "tcpip:"))
+			return
+		case req == "usb:":
+			okay(w)
+			io.WriteString(w, "restarting in USB mode\n")
+			return
+		case strings.Contains(req, "forward:"):
+			// host and reverse forwards are acknowledged twice
+			okay(w)
+			okay(w)
+			return
+		case strings.HasSuffix(req, "wait-for-any-device"):
+			okay(w)
+			s.mu.Lock()
+			offline := s.state == "offline"
+			s.mu.Unlock()
+			if offline {
+				// the device never shows up, wait for the client to go
+				io.Copy(ioutil.Discard, r)
+				return
+			}
+			okay(w)
+			return
+		case strings.HasPrefix(req, "shell:"):
+			okay(w)
+			cmd, wrapped := devicestest.UnwrapShell(req)
+			io.WriteString(w, s.shell[cmd]+s.stderr[cmd])
+			if wrapped {
+				devicestest.WriteShellExit(w, s.exit[cmd])
+			}
+			return
+		case strings.HasPrefix(req, "reboot:"):
+			okay(w)
+			return
+		case req == "sync:":
+			okay(w)
+			s.sync(r, w)
+			return
+		default:
+			fail(w, "unknown request")
+			return
+		}
+	}
+}
+
+func shellPacket(w io.Writer, id byte, data string) {
+	w.Write([]byte{id})
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	io.WriteString(w, data)
+}
+
+// sha256sum answers the find and sha256sum commands used to compare
+// files with the contents of files
+func (s *fakeAdbServer) sha256sum(cmd string) string {
+	args := strings.Fields(cmd)
+	var names []string
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(args) > 1 && args[0] == "find":
+		root := strings.Trim(args[1], "'")
+		for name := range s.files {
+			if strings.HasPrefix(name, root+"/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	case len(args) > 1 && args[0] == "sha256sum":
+		for _, arg := range args[1:] {
+			names = append(names, strings.Trim(arg, "'"))
+		}
+	}
+	var out string
+	for _, name := range names {
+		if data, ok := s.files[name]; ok {
+			out += fmt.Sprintf("%x  %s\n", sha256.Sum256(data), name)
+		}
+	}
+	return out
+}
+
+// shellV2 runs cmd with the shell v2 protocol, cat echoes its stdin
+func (s *fakeAdbServer) shellV2(r io.Reader, w io.Writer, cmd string) {
+	stdout, ok := s.shell[cmd]
+	if !ok {
+		stdout = s.sha256sum(cmd)
+	}
+	if cmd == "cat" {
+		stdout = ""
+		for {
+			hdr := make([]byte, 5)
+			if _, err := io.ReadFull(r, hdr); err != nil {
+				return
+			}
+			data := make([]byte, binary.LittleEndian.Uint32(hdr[1:]))
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			if hdr[0] == 4 {
+				break
+			}
+			stdout += string(data)
+		}
+	}
+	if stdout != "" {
+		shellPacket(w, 1, stdout)
+	}
+	if stderr := s.stderr[cmd]; stderr != "" {
+		shellPacket(w, 2, stderr)
+	}
+	shellPacket(w, 3, string([]byte{byte(s.exit[cmd])}))
+}
+
+func (s *fakeAdbServer) known(serial string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, known := range s.serials {
+		if serial == known {
+			return true
+		}
+	}
+	return false
+}
+
+func syncPacket(w io.Writer, id string, values ...uint32) {
+	io.WriteString(w, id)
+	binary.Write(w, binary.LittleEndian, values)
+}
+
+// lookup returns the mode, size and mtime of p in files
+func (s *fakeAdbServer) lookup(p string) (mode, size, mtime uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data, ok := s.files[p]; ok {
+		mtime, ok := s.mtimes[p]
+		if !ok {
+			mtime = 1400000000
+		}
+		return 0100644, uint32(len(data)), mtime
+	}
+	for name := range s.files {
+		if strings.HasPrefix(name, p+"/") || p == "/" {
+			return 040755, 4096, 1400000000
+		}
+	}
+	return 0, 0, 0
+}
+
+func (s *fakeAdbServer) sync(r io.Reader, w io.Writer) {
+	for {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		id, n := string(hdr[:4]), binary.LittleEndian.Uint32(hdr[4:])
+		arg := make([]byte, n)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return
+		}
+		p := string(arg)
+
+		switch id {
+		case "STAT":
+			mode, size, mtime := s.lookup(p)
+			syncPacket(w, "STAT", mode, size, mtime)
+		case "LIST":
+			s.mu.Lock()
+			children := make(map[string]bool)
+			for name := range s.files {
+				if rel := strings.TrimPrefix(name, p+"/"); rel != name {
+					children[strings.SplitN(rel, "/", 2)[0]] = true
+				}
+			}
+			s.mu.Unlock()
+			var names []string
+			for name := range children {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range append([]string{".", ".."}, names...) {
+				mode, size, mtime := s.lookup(path.Join(p, name))
+				syncPacket(w, "DENT", mode, size, mtime, uint32(len(name)))
+				io.WriteString(w, name)
+			}
+			syncPacket(w, "DONE", 0, 0, 0, 0)
+		case "SEND":
+			dst := p[:strings.LastIndex(p, ",")]
+			var data []byte
+			for {
+				if _, err := io.ReadFull(r, hdr); err != nil {
+					return
+				}
+				if string(hdr[:4]) == "DONE" {
+					break
+				}
+				chunk := make([]byte, binary.LittleEndian.Uint32(hdr[4:]))
+				if _, err := io.ReadFull(r, chunk); err != nil {
+					return
+				}
+				data = append(data, chunk...)
+			}
+			s.mu.Lock()
+			s.files[dst] = data
+			s.mtimes[dst] = binary.LittleEndian.Uint32(hdr[4:])
+			s.mu.Unlock()
+			syncPacket(w, "OKAY", 0)
+		case "RECV":
+			s.mu.Lock()
+			data, ok := s.files[p]
+			s.mu.Unlock()
+			if !ok {
+				msg := "No such file or directory"
+				syncPacket(w, "FAIL", uint32(len(msg)))
+				io.WriteString(w, msg)
+				continue
+			}
+			syncPacket(w, "DATA", uint32(len(data)))
+			w.Write(data)
+			syncPacket(w, "DONE", 0)
+		case "QUIT":
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeFastboot is a stand in for a bootloader speaking fastboot over TCP,
+// vars holds the replies to getvar and flashed what each flash wrote
+type fakeFastboot struct {
+	l    net.Listener
+	vars map[string]string
+
+	mu       sync.Mutex
+	commands []string
+	flashed  map[string][][]byte
+}
+
+func newFakeFastboot() (*fakeFastboot, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	fb := &fakeFastboot{
+		l:       l,
+		vars:    map[string]string{"product": "mako", "max-download-size": "0x10000000"},
+		flashed: make(map[string][][]byte),
+	}
+	go fb.serve()
+	return fb, nil
+}
+
+func (fb *fakeFastboot) addr() string {
+	return fb.l.Addr().String()
+}
+
+func (fb *fakeFastboot) close() {
+	fb.l.Close()
+}
+
+func (fb *fakeFastboot) received() []string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return append([]string(nil), fb.commands...)
+}
+
+func (fb *fakeFastboot) serve() {
+	for {
+		conn, err := fb.l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			fb.handle(conn)
+		}()
+	}
+}
+
+func readPacket(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	p := make([]byte, binary.BigEndian.Uint64(hdr))
+	_, err := io.ReadFull(r, p)
+	return p, err
+}
+
+func writePacket(w io.Writer, format string, args ...interface{}) {
+	p := fmt.Sprintf(format, args...)
+	binary.Write(w, binary.BigEndian, uint64(len(p)))
+	io.WriteString(w, p)
+}
+
+func (fb *fakeFastboot) handle(conn net.Conn) {
+	handshake := make([]byte, 4)
+	if _, err := io.ReadFull(conn, handshake); err != nil || string(handshake) != "FB01" {
+		return
+	}
+	io.WriteString(conn, "FB01")
+
+	var download []byte
+	for {
+		p, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		cmd := string(p)
+		fb.mu.Lock()
+		fb.commands = append(fb.commands, cmd)
+		fb.mu.Unlock()
+
+		switch {
+		case cmd == "getvar:all":
+			fb.mu.Lock()
+			var names []string
+			for name := range fb.vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				writePacket(conn, "INFO%s:%s", name, fb.vars[name])
+			}
+			fb.mu.Unlock()
+			writePacket(conn, "OKAY")
+		case strings.HasPrefix(cmd, "set_active:"):
+			fb.mu.Lock()
+			fb.vars["current-slot"] = strings.TrimPrefix(cmd, "set_active:")
+			fb.mu.Unlock()
+			writePacket(conn, "OKAY")
+		case cmd == "getvar:slow":
+			// the reply arrives in two segments
+			binary.Write(conn, binary.BigEndian, uint64(len("OKAYslow")))
+			io.WriteString(conn, "OK")
+			time.Sleep(10 * time.Millisecond)
+			io.WriteString(conn, "AYslow")
+		case strings.HasPrefix(cmd, "getvar:"):
+			v, ok := fb.vars[strings.TrimPrefix(cmd, "getvar:")]
+			if !ok {
+				writePacket(conn, "FAILGetVar Variable Not found")
+				continue
+			}
+			writePacket(conn, "OKAY%s", v)
+		case strings.HasPrefix(cmd, "download:"):
+			size, _ := strconv.ParseUint(strings.TrimPrefix(cmd, "download:"), 16, 32)
+			writePacket(conn, "DATA%08x", size)
+			download = nil
+			for uint64(len(download)) < size {
+				data, err := readPacket(conn)
+				if err != nil {
+					return
+				}
+				download = append(download, data...)
+			}
+			writePacket(conn, "OKAY")
+		case strings.HasPrefix(cmd, "flash:"):
+			if download == nil {
+				writePacket(conn, "FAILno image downloaded")
+				continue
+			}
+			partition := strings.TrimPrefix(cmd, "flash:")
+			fb.mu.Lock()
+			fb.flashed[partition] = append(fb.flashed[partition], download)
+			fb.mu.Unlock()
+			writePacket(conn, "INFOwriting '%s'...", partition)
+			writePacket(conn, "OKAY")
+		case strings.HasPrefix(cmd, "oem "):
+			writePacket(conn, "INFOunlocked: no")
+			writePacket(conn, "INFOtampered: no")
+			writePacket(conn, "OKAY")
+		case cmd == "reboot", cmd == "continue", cmd == "boot", strings.HasPrefix(cmd, "erase:"):
+			writePacket(conn, "OKAY")
+		default:
+			writePacket(conn, "FAILunknown command")
+		}
+	}
+}
@@ -20,7 +20,9 @@ package devices
 // with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 import (
-	"os/exec"
+	"context"
+	"fmt"
+	"os"
 	"strings"
 )
 
@@ -36,45 +38,155 @@ func (fastboot *Fastboot) SetSerial(serial string) {
 	fastboot.params = append(fastboot.params, []string{"-s", serial}...)
 }
 
-// Reboot restarts the system from the Android bootloader
-func (fastboot Fastboot) Reboot() (err error) {
-	cmd := append(fastboot.params, []string{"reboot"}...)
-	err = exec.Command(fastbootCommand, cmd...).Run()
-	return err
+// overTcp returns true for devices reached over TCP, selected with a
+// tcp:host[:port] serial as fastboot does
+func (fastboot Fastboot) overTcp() bool {
+	return strings.HasPrefix(fastboot.serial, "tcp:")
+}
+
+// withClient runs f with a native client connected to the device
+func (fastboot Fastboot) withClient(ctx context.Context, f func(fb *FastbootClient) error) error {
+	t, err := DialFastbootTcp(ctx, strings.TrimPrefix(fastboot.serial, "tcp:"))
+	if err != nil {
+		return err
+	}
+	fb := NewFastbootClient(t)
+	defer fb.Close()
+	return f(fb)
+}
+
+// openImage opens the image at path and returns its size
+func openImage(path string) (*os.File, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// run runs the fastboot command with args, the output of fastboot is
+// kept in the error as it holds the reason for the failure
+func (fastboot Fastboot) run(args ...string) error {
+	return fastboot.runContext(context.Background(), args...)
+}
+
+// runContext is run with the fastboot command killed once ctx is done
+func (fastboot Fastboot) runContext(ctx context.Context, args ...string) error {
+	cmd := append(fastboot.params, args...)
+	out, err := runner.Run(ctx, fastbootCommand, cmd...)
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return ErrFastboot{strings.Join(args, " "), msg}
+	}
+	return nil
+}
+
+// Reboot restarts the system from the Android bootloader into target
+func (fastboot Fastboot) Reboot(ctx context.Context, target string) (err error) {
+	if fastboot.overTcp() {
+		return fastboot.withClient(ctx, func(fb *FastbootClient) error { return fb.Reboot(target) })
+	}
+	switch target {
+	case TargetSystem:
+		return fastboot.runContext(ctx, "reboot")
+	case TargetBootloader:
+		return fastboot.runContext(ctx, "reboot-bootloader")
+	}
+	return fastboot.runContext(ctx, "reboot", target)
+}
+
+// WaitForState waits until the device shows up in the bootloader, the
+// only state fastboot can see
+func (fastboot Fastboot) WaitForState(ctx context.Context, state State) error {
+	if state != StateBootloader {
+		return fmt.Errorf("fastboot cannot wait for the %s state", state)
+	}
+	return poll(ctx, fastboot.serial, state, func() (State, error) {
+		if fastboot.overTcp() {
+			if err := fastboot.withClient(ctx, func(*FastbootClient) error { return nil }); err != nil {
+				return "", nil
+			}
+			return StateBootloader, nil
+		}
+		out, err := runner.Run(ctx, fastbootCommand, "devices")
+		if err != nil {
+			return "", err
+		}
+		for _, dev := range parseFastbootDevices(string(out)) {
+			if dev.Serial == fastboot.serial || fastboot.serial == "" {
+				return dev.State, nil
+			}
+		}
+		return "", nil
+	})
 }
 
 // Boot boots the system from the Android bootloader from the boot partition
 func (fastboot Fastboot) Boot() (err error) {
-	cmd := append(fastboot.params, []string{"boot"}...)
-	err = exec.Command(fastbootCommand, cmd...).Run()
-	return err
+	if fastboot.overTcp() {
+		return fastboot.withClient(context.Background(), func(fb *FastbootClient) error { return fb.Continue() })
+	}
+	return fastboot.run("boot")
 }
 
 // BootImage boots the system from the Android specified boot image
 func (fastboot Fastboot) BootImage(image string) (err error) {
-	cmd := append(fastboot.params, []string{"boot", image}...)
-	err = exec.Command(fastbootCommand, cmd...).Run()
-	return err
+	if fastboot.overTcp() {
+		return fastboot.withClient(context.Background(), func(fb *FastbootClient) error {
+			f, size, err := openImage(image)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := fb.Download(f, size); err != nil {
+				return err
+			}
+			return fb.Boot()
+		})
+	}
+	return fastboot.run("boot", image)
 }
 
 // Flash flashes the specified image to partition on the device
 func (fastboot Fastboot) Flash(partition, image string) (err error) {
-	cmd := append(fastboot.params, []string{"flash", partition, image}...)
-	err = exec.Command(fastbootCommand, cmd...).Run()
-	return err
+	if fastboot.overTcp() {
+		return fastboot.withClient(context.Background(), func(fb *FastbootClient) error {
+			f, size, err := openImage(image)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return fb.FlashImage(partition, f, size)
+		})
+	}
+	return fastboot.run("flash", partition, image)
 }
 
-// Format formats the specified partition on the device
+// Format formats the specified partition on the device, the filesystem
+// is created by the fastboot command even for devices reached over TCP
 func (fastboot Fastboot) Format(partition string) (err error) {
-	cmd := append(fastboot.params, []string{"format", partition}...)
-	err = exec.Command(fastbootCommand, cmd...).Run()
-	return err
+	return fastboot.run("format", partition)
 }
 
 // GetDevice obtains the device name from fastboot
 func (fastboot Fastboot) GetDevice() (device string, err error) {
+	if fastboot.overTcp() {
+		err = fastboot.withClient(context.Background(), func(fb *FastbootClient) error {
+			device, err = fb.GetVar("product")
+			return err
+		})
+		return device, err
+	}
 	cmd := append(fastboot.params, []string{"getvar", "product"}...)
-	deviceOutput, err := exec.Command(fastbootCommand, cmd...).CombinedOutput()
+	deviceOutput, err := runner.Run(context.Background(), fastbootCommand, cmd...)
 	lines := strings.Split(string(deviceOutput), "\n")
 	for _, line := range lines {
 		fields := strings.Split(line, ":")
@@ -85,3 +197,72 @@ func (fastboot Fastboot) GetDevice() (device string, err error) {
 	}
 	return device, err
 }
+
+// Info returns the bootloader variables of the device
+func (fastboot Fastboot) Info() (info DeviceInfo, err error) {
+	if fastboot.overTcp() {
+		err = fastboot.withClient(context.Background(), func(fb *FastbootClient) error {
+			vars, err := fb.GetVarAll()
+			info = newDeviceInfo(vars)
+			return err
+		})
+		return info, err
+	}
+	// fastboot prints the variables on stderr
+	cmd := append(fastboot.params, "getvar", "all")
+	out, err := runner.Run(context.Background(), fastbootCommand, cmd...)
+	if err != nil {
+		return info, ErrFastboot{"getvar all", strings.TrimSpace(string(out))}
+	}
+	return newDeviceInfo(parseGetvarAll(string(out))), nil
+}
+
+// SetActive marks slot, a or b, as the one the device boots from
+func (fastboot Fastboot) SetActive(slot string) error {
+	slot = strings.TrimPrefix(slot, "_")
+	if fastboot.overTcp() {
+		return fastboot.withClient(context.Background(), func(fb *FastbootClient) error { return fb.SetActive(slot) })
+	}
+	return fastboot.run("set_active", slot)
+}
+
+// FlashSlot flashes image to partition in slot, an empty slot flashes
+// partition as is
+func (fastboot Fastboot) FlashSlot(partition, slot, image string) error {
+	return fastboot.Flash(slotPartition(partition, slot), image)
+}
+
+// Unlock unlocks the bootloader with flashing unlock, falling back to oem
+// unlock for bootloaders that predate it; the device usually requires a
+// confirmation on its screen and wipes the userdata partition
+func (fastboot Fastboot) Unlock() error {
+	if fastboot.overTcp() {
+		return fastboot.withClient(context.Background(), func(fb *FastbootClient) error {
+			if _, err := fb.Command("flashing unlock"); err == nil {
+				return nil
+			}
+			_, err := fb.Oem("unlock")
+			return err
+		})
+	}
+	if err := fastboot.run("flashing", "unlock"); err == nil {
+		return nil
+	}
+	return fastboot.run("oem", "unlock")
+}
+
+// CheckUnlocked returns an ErrLocked if the bootloader of the device
+// refuses flashing, or an ErrLockUnknown if it does not tell
+func (fastboot Fastboot) CheckUnlocked() error {
+	info, err := fastboot.Info()
+	if err != nil {
+		return err
+	}
+	switch info.Lock {
+	case Locked:
+		return ErrLocked{info.Product}
+	case LockUnknown:
+		return ErrLockUnknown{info.Product}
+	}
+	return nil
+}
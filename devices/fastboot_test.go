@@ -0,0 +1,137 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+	"launchpad.net/goget-ubuntu-touch/sparse"
+)
+
+type FastbootTestSuite struct {
+	server *fakeFastboot
+	fb     *devices.FastbootClient
+	tmpdir string
+}
+
+var _ = Suite(&FastbootTestSuite{})
+
+func (s *FastbootTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeFastboot()
+	c.Assert(err, IsNil)
+	t, err := devices.DialFastbootTcp(context.Background(), s.server.addr())
+	c.Assert(err, IsNil)
+	s.fb = devices.NewFastbootClient(t)
+	s.tmpdir = c.MkDir()
+}
+
+func (s *FastbootTestSuite) TearDownTest(c *C) {
+	s.fb.Close()
+	s.server.close()
+}
+
+func (s *FastbootTestSuite) TestGetVar(c *C) {
+	product, err := s.fb.GetVar("product")
+	c.Assert(err, IsNil)
+	c.Check(product, Equals, "mako")
+
+	_, err = s.fb.GetVar("missing")
+	c.Assert(err, FitsTypeOf, devices.ErrFastboot{})
+	c.Check(err, ErrorMatches, `fastboot command "getvar:missing" failed: GetVar Variable Not found`)
+}
+
+func (s *FastbootTestSuite) TestGetVarSplitReply(c *C) {
+	slow, err := s.fb.GetVar("slow")
+	c.Assert(err, IsNil)
+	c.Check(slow, Equals, "slow")
+}
+
+func (s *FastbootTestSuite) TestDownloadFlash(c *C) {
+	var info []string
+	var progress []int64
+	s.fb.Info = func(msg string) { info = append(info, msg) }
+	s.fb.Progress = func(sent, total int64) { progress = append(progress, sent) }
+
+	data := bytes.Repeat([]byte("r"), devices.FastbootChunkSize+10)
+	c.Assert(s.fb.Download(bytes.NewReader(data), int64(len(data))), IsNil)
+	c.Assert(s.fb.Flash("recovery"), IsNil)
+	c.Check(s.server.flashed["recovery"], DeepEquals, [][]byte{data})
+	c.Check(info, DeepEquals, []string{"writing 'recovery'..."})
+	c.Check(progress, DeepEquals, []int64{devices.FastbootChunkSize, int64(len(data))})
+
+	c.Check(s.fb.Download(bytes.NewReader(data), int64(len(data))+1), NotNil)
+}
+
+func (s *FastbootTestSuite) TestOemAndReboot(c *C) {
+	messages, err := s.fb.Oem("device-info")
+	c.Assert(err, IsNil)
+	c.Check(messages, DeepEquals, []string{"unlocked: no", "tampered: no"})
+	c.Assert(s.fb.Erase("cache"), IsNil)
+	c.Assert(s.fb.Reboot(""), IsNil)
+	c.Check(s.fb.Reboot("bootloader"), ErrorMatches, ".*unknown command")
+	c.Check(s.server.received(), DeepEquals, []string{
+		"oem device-info", "erase:cache", "reboot", "reboot-bootloader",
+	})
+}
+
+func (s *FastbootTestSuite) TestFlashImageSplits(c *C) {
+	s.server.vars["max-download-size"] = "0x3000"
+	raw := bytes.Repeat([]byte("0123456789abcdef"), 5*sparse.DefaultBlockSize/16)
+	c.Assert(s.fb.FlashImage("system", bytes.NewReader(raw), int64(len(raw))), IsNil)
+
+	parts := s.server.flashed["system"]
+	c.Assert(len(parts) > 1, Equals, true)
+	flashed := make([]byte, len(raw))
+	for _, part := range parts {
+		c.Assert(len(part) <= 0x3000, Equals, true)
+		img, err := sparse.Parse(bytes.NewReader(part), int64(len(part)))
+		c.Assert(err, IsNil)
+		r, err := sparse.NewReader(bytes.NewReader(part))
+		c.Assert(err, IsNil)
+		expanded, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		for _, chunk := range img.Chunks {
+			if chunk.Type == sparse.ChunkRaw {
+				start := chunk.Block * sparse.DefaultBlockSize
+				end := start + chunk.Blocks*sparse.DefaultBlockSize
+				copy(flashed[start:end], expanded[start:end])
+			}
+		}
+	}
+	c.Check(flashed, DeepEquals, raw)
+}
+
+func (s *FastbootTestSuite) TestFastbootOverTcp(c *C) {
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("tcp:" + s.server.addr())
+	device, err := fastboot.GetDevice()
+	c.Assert(err, IsNil)
+	c.Check(device, Equals, "mako")
+
+	recovery := filepath.Join(s.tmpdir, "recovery.img")
+	c.Assert(ioutil.WriteFile(recovery, []byte("ANDROID!"), 0644), IsNil)
+	c.Assert(fastboot.Flash("recovery", recovery), IsNil)
+	c.Assert(fastboot.BootImage(recovery), IsNil)
+	c.Check(s.server.flashed["recovery"], DeepEquals, [][]byte{[]byte("ANDROID!")})
+}
@@ -0,0 +1,383 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"launchpad.net/goget-ubuntu-touch/sparse"
+)
+
+// FastbootTransport carries fastboot packets to and from a device, each
+// Write is sent as a single packet and each Read returns as much of a
+// single packet as fits in the buffer
+type FastbootTransport interface {
+	io.ReadWriter
+	io.Closer
+}
+
+// DefaultFastbootTcpPort is the port fastbootd and bootloaders listen on
+// for fastboot over TCP
+const DefaultFastbootTcpPort = "5554"
+
+// fastbootTcpVersion is the TCP protocol version spoken in the handshake
+const fastbootTcpVersion = 1
+
+// tcpTransport frames packets with a big endian 64 bit length as done by
+// fastboot over TCP
+type tcpTransport struct {
+	conn net.Conn
+	// bytes left in the packet being read
+	remaining uint64
+}
+
+// DialFastbootTcp connects to the fastboot over TCP server at addr, the
+// port defaults to DefaultFastbootTcpPort, the connection stops working
+// once the deadline of ctx is reached
+func DialFastbootTcp(ctx context.Context, addr string) (FastbootTransport, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultFastbootTcpPort)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	handshake := fmt.Sprintf("FB%02d", fastbootTcpVersion)
+	if _, err := io.WriteString(conn, handshake); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("fastboot handshake with %s failed: %v", addr, err)
+	}
+	if version, err := strconv.Atoi(string(reply[2:])); string(reply[:2]) != "FB" || err != nil || version < 1 {
+		conn.Close()
+		return nil, fmt.Errorf("fastboot handshake with %s failed: unexpected reply %q", addr, reply)
+	}
+	return &tcpTransport{conn: conn}, nil
+}
+
+func (t *tcpTransport) Write(p []byte) (int, error) {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint64(hdr, uint64(len(p)))
+	if _, err := t.conn.Write(hdr); err != nil {
+		return 0, err
+	}
+	return t.conn.Write(p)
+}
+
+func (t *tcpTransport) Read(p []byte) (int, error) {
+	if t.remaining == 0 {
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(t.conn, hdr); err != nil {
+			return 0, err
+		}
+		t.remaining = binary.BigEndian.Uint64(hdr)
+	}
+	if uint64(len(p)) > t.remaining {
+		p = p[:t.remaining]
+	}
+	// the packet may arrive in several segments
+	n, err := io.ReadFull(t.conn, p)
+	t.remaining -= uint64(n)
+	return n, err
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// fastbootMaxResponse is the size of the biggest reply from a device
+const fastbootMaxResponse = 256
+
+// fastbootChunkSize is how much is sent to the device per packet while
+// downloading
+const fastbootChunkSize = 1024 * 1024
+
+// FastbootClient speaks the fastboot protocol over a FastbootTransport
+type FastbootClient struct {
+	t FastbootTransport
+	// Info receives the INFO and TEXT messages sent while a command runs
+	Info func(msg string)
+	// Progress is called as data is downloaded to the device
+	Progress func(sent, total int64)
+}
+
+// NewFastbootClient returns a FastbootClient talking over t
+func NewFastbootClient(t FastbootTransport) *FastbootClient {
+	return &FastbootClient{t: t}
+}
+
+// Close closes the transport
+func (fb *FastbootClient) Close() error {
+	return fb.t.Close()
+}
+
+// Command sends cmd and waits for the device to complete it, the
+// payload of the OKAY reply is returned
+func (fb *FastbootClient) Command(cmd string) (string, error) {
+	if _, err := fb.t.Write([]byte(cmd)); err != nil {
+		return "", err
+	}
+	status, payload, err := fb.response(cmd)
+	if err != nil {
+		return "", err
+	}
+	if status != "OKAY" {
+		return "", ErrFastboot{cmd, fmt.Sprintf("unexpected %s reply", status)}
+	}
+	return payload, nil
+}
+
+// response reads replies to cmd until one that is not informational
+func (fb *FastbootClient) response(cmd string) (status, payload string, err error) {
+	buf := make([]byte, fastbootMaxResponse)
+	for {
+		n, err := fb.t.Read(buf)
+		if err != nil {
+			return "", "", err
+		}
+		if n < 4 {
+			return "", "", ErrFastboot{cmd, fmt.Sprintf("short reply %q", buf[:n])}
+		}
+		status, payload := string(buf[:4]), string(buf[4:n])
+		switch status {
+		case "INFO", "TEXT":
+			if fb.Info != nil {
+				fb.Info(payload)
+			}
+		case "FAIL":
+			return "", "", ErrFastboot{cmd, payload}
+		case "OKAY", "DATA":
+			return status, payload, nil
+		default:
+			return "", "", ErrFastboot{cmd, fmt.Sprintf("unknown reply %q", buf[:n])}
+		}
+	}
+}
+
+// GetVar returns the value of the bootloader variable name
+func (fb *FastbootClient) GetVar(name string) (string, error) {
+	return fb.Command("getvar:" + name)
+}
+
+// Download sends size bytes from r to the device to be used by a
+// following flash or boot command
+func (fb *FastbootClient) Download(r io.Reader, size int64) error {
+	if size < 0 || size > 0xffffffff {
+		return fmt.Errorf("cannot download %d bytes to a device", size)
+	}
+	cmd := fmt.Sprintf("download:%08x", size)
+	if _, err := fb.t.Write([]byte(cmd)); err != nil {
+		return err
+	}
+	status, payload, err := fb.response(cmd)
+	if err != nil {
+		return err
+	}
+	if status != "DATA" {
+		return ErrFastboot{cmd, "device did not ask for data"}
+	}
+	if accepted, err := strconv.ParseInt(payload, 16, 64); err != nil || accepted != size {
+		return ErrFastboot{cmd, fmt.Sprintf("device asked for %q bytes instead of %d", payload, size)}
+	}
+
+	buf := make([]byte, fastbootChunkSize)
+	for sent := int64(0); sent < size; {
+		chunk := buf
+		if size-sent < int64(len(chunk)) {
+			chunk = chunk[:size-sent]
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+		if _, err := fb.t.Write(chunk); err != nil {
+			return err
+		}
+		sent += int64(len(chunk))
+		if fb.Progress != nil {
+			fb.Progress(sent, size)
+		}
+	}
+
+	status, _, err = fb.response(cmd)
+	if err != nil {
+		return err
+	}
+	if status != "OKAY" {
+		return ErrFastboot{cmd, fmt.Sprintf("unexpected %s reply", status)}
+	}
+	return nil
+}
+
+// Flash writes the last download to partition
+func (fb *FastbootClient) Flash(partition string) error {
+	_, err := fb.Command("flash:" + partition)
+	return err
+}
+
+// Erase erases partition
+func (fb *FastbootClient) Erase(partition string) error {
+	_, err := fb.Command("erase:" + partition)
+	return err
+}
+
+// Boot boots the last download as a boot image
+func (fb *FastbootClient) Boot() error {
+	_, err := fb.Command("boot")
+	return err
+}
+
+// Continue resumes the normal boot of the device
+func (fb *FastbootClient) Continue() error {
+	_, err := fb.Command("continue")
+	return err
+}
+
+// Reboot restarts the device, into target if it is not empty, e.g.;
+// bootloader, recovery or fastboot
+func (fb *FastbootClient) Reboot(target string) error {
+	cmd := "reboot"
+	if target != "" {
+		cmd += "-" + target
+	}
+	_, err := fb.Command(cmd)
+	return err
+}
+
+// Oem runs a vendor specific command and returns the messages the
+// device sent while running it
+func (fb *FastbootClient) Oem(args ...string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, errors.New("an oem command is required")
+	}
+	return fb.collect("oem " + strings.Join(args, " "))
+}
+
+// GetVarAll returns every variable the bootloader reports
+func (fb *FastbootClient) GetVarAll() (map[string]string, error) {
+	messages, err := fb.collect("getvar:all")
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for _, msg := range messages {
+		if name, value, ok := parseVar(msg); ok {
+			vars[name] = value
+		}
+	}
+	return vars, nil
+}
+
+// SetActive marks slot as the one to boot from
+func (fb *FastbootClient) SetActive(slot string) error {
+	_, err := fb.Command("set_active:" + strings.TrimPrefix(slot, "_"))
+	return err
+}
+
+// collect runs cmd and returns the messages the device sent while
+// running it
+func (fb *FastbootClient) collect(cmd string) ([]string, error) {
+	var messages []string
+	info := fb.Info
+	fb.Info = func(msg string) {
+		messages = append(messages, msg)
+		if info != nil {
+			info(msg)
+		}
+	}
+	defer func() { fb.Info = info }()
+	_, err := fb.Command(cmd)
+	return messages, err
+}
+
+// MaxDownloadSize returns how much the device accepts in a download
+func (fb *FastbootClient) MaxDownloadSize() (int64, error) {
+	v, err := fb.GetVar("max-download-size")
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(v), 0, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid max-download-size %q", v)
+	}
+	return size, nil
+}
+
+// FlashImage downloads the image in r and flashes it to partition, images
+// bigger than the max-download-size of the device are sent as a series
+// of sparse images
+func (fb *FastbootClient) FlashImage(partition string, r io.ReaderAt, size int64) error {
+	maxSize, err := fb.MaxDownloadSize()
+	if err != nil {
+		return err
+	}
+	if size <= maxSize {
+		if err := fb.Download(io.NewSectionReader(r, 0, size), size); err != nil {
+			return err
+		}
+		return fb.Flash(partition)
+	}
+
+	header := make([]byte, 4)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return err
+	}
+	var img *sparse.Image
+	if sparse.IsSparse(header) {
+		img, err = sparse.Parse(r, size)
+	} else {
+		img, err = sparse.Scan(r, size, sparse.DefaultBlockSize, false)
+	}
+	if err != nil {
+		return err
+	}
+	parts, err := img.Split(maxSize)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		pr, pw := io.Pipe()
+		go func(part *sparse.Image) {
+			_, err := part.WriteTo(pw)
+			pw.CloseWithError(err)
+		}(part)
+		err := fb.Download(pr, part.Size())
+		pr.CloseWithError(err)
+		if err != nil {
+			return err
+		}
+		if err := fb.Flash(partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
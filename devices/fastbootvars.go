@@ -0,0 +1,171 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Partition describes a partition as reported by the bootloader
+type Partition struct {
+	Name string
+	Type string
+	Size int64
+	// HasSlot is set for partitions that exist once per A/B slot
+	HasSlot bool
+	Logical bool
+}
+
+// LockState tells if a bootloader accepts flashing
+type LockState int
+
+const (
+	// LockUnknown is used for bootloaders that do not report it, secure
+	// says nothing about it as production bootloaders report secure
+	// whether they are locked or not
+	LockUnknown LockState = iota
+	Locked
+	Unlocked
+)
+
+var lockStateNames = map[LockState]string{
+	LockUnknown: "unknown",
+	Locked:      "locked",
+	Unlocked:    "unlocked",
+}
+
+func (s LockState) String() string {
+	return lockStateNames[s]
+}
+
+// DeviceInfo holds the bootloader variables reported by getvar all, every
+// variable is kept as is in Vars
+type DeviceInfo struct {
+	Product         string
+	MaxDownloadSize int64
+	// Lock is taken from the unlocked variable
+	Lock        LockState
+	Secure      bool
+	CurrentSlot string
+	SlotCount   int
+	Partitions  map[string]Partition
+	Vars        map[string]string
+}
+
+// HasSlots returns true for devices with A/B slots
+func (info DeviceInfo) HasSlots() bool {
+	return info.SlotCount > 1
+}
+
+// partitionVars are the variables that take the partition name as an
+// argument, e.g.; partition-size:system
+var partitionVars = map[string]bool{
+	"partition-size": true,
+	"partition-type": true,
+	"has-slot":       true,
+	"is-logical":     true,
+}
+
+// parseVar splits a name:value line sent by the bootloader
+func parseVar(line string) (name, value string, ok bool) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	name, value = line[:i], line[i+1:]
+	if partitionVars[name] {
+		if j := strings.Index(value, ":"); j >= 0 {
+			name, value = name+":"+value[:j], value[j+1:]
+		}
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(value), true
+}
+
+// parseGetvarAll returns the variables in the output of fastboot getvar all
+func parseGetvarAll(output string) map[string]string {
+	vars := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "(bootloader)") {
+			continue
+		}
+		if name, value, ok := parseVar(strings.TrimSpace(strings.TrimPrefix(line, "(bootloader)"))); ok {
+			vars[name] = value
+		}
+	}
+	return vars
+}
+
+// isYes returns true for the ways bootloaders answer a yes or no variable
+func isYes(v string) bool {
+	switch strings.ToLower(v) {
+	case "yes", "true", "1":
+		return true
+	}
+	return false
+}
+
+// newDeviceInfo interprets the bootloader variables in vars
+func newDeviceInfo(vars map[string]string) DeviceInfo {
+	info := DeviceInfo{
+		Product:     vars["product"],
+		CurrentSlot: strings.TrimPrefix(vars["current-slot"], "_"),
+		Partitions:  make(map[string]Partition),
+		Vars:        vars,
+	}
+	info.MaxDownloadSize, _ = strconv.ParseInt(vars["max-download-size"], 0, 64)
+	info.SlotCount, _ = strconv.Atoi(vars["slot-count"])
+	info.Secure = isYes(vars["secure"])
+	if v, ok := vars["unlocked"]; ok {
+		info.Lock = Locked
+		if isYes(v) {
+			info.Lock = Unlocked
+		}
+	}
+
+	for name, value := range vars {
+		i := strings.Index(name, ":")
+		if i < 0 || !partitionVars[name[:i]] {
+			continue
+		}
+		p := info.Partitions[name[i+1:]]
+		p.Name = name[i+1:]
+		switch name[:i] {
+		case "partition-size":
+			p.Size, _ = strconv.ParseInt(value, 0, 64)
+		case "partition-type":
+			p.Type = value
+		case "has-slot":
+			p.HasSlot = isYes(value)
+		case "is-logical":
+			p.Logical = isYes(value)
+		}
+		info.Partitions[p.Name] = p
+	}
+	return info
+}
+
+// slotPartition returns the name of partition in slot, slots can be given
+// as a or _a
+func slotPartition(partition, slot string) string {
+	if slot == "" {
+		return partition
+	}
+	return partition + "_" + strings.TrimPrefix(slot, "_")
+}
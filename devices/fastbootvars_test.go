@@ -0,0 +1,155 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type FastbootVarsTestSuite struct {
+	server *fakeFastboot
+}
+
+var _ = Suite(&FastbootVarsTestSuite{})
+
+func (s *FastbootVarsTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeFastboot()
+	c.Assert(err, IsNil)
+	s.server.vars["slot-count"] = "2"
+	s.server.vars["current-slot"] = "a"
+	s.server.vars["unlocked"] = "no"
+	s.server.vars["partition-size:boot_a"] = "0x4000000"
+	s.server.vars["partition-type:boot_a"] = "raw"
+	s.server.vars["has-slot:boot"] = "yes"
+}
+
+func (s *FastbootVarsTestSuite) TearDownTest(c *C) {
+	s.server.close()
+}
+
+const getvarAll = `(bootloader) version-bootloader:MMB29K
+(bootloader) product:bullhead
+(bootloader) secure:yes
+(bootloader) unlocked:yes
+(bootloader) max-download-size: 0x20000000
+(bootloader) partition-size:system: 0x00000000a0000000
+(bootloader) partition-type:system:ext4
+(bootloader) partition-size:userdata: 0x0000000680000000
+(bootloader) partition-type:userdata:f2fs
+all: 
+finished. total time: 0.122s
+`
+
+func (s *FastbootVarsTestSuite) TestParseGetvarAll(c *C) {
+	vars := devices.ParseGetvarAll(getvarAll)
+	c.Check(vars["version-bootloader"], Equals, "MMB29K")
+	c.Check(vars["partition-type:system"], Equals, "ext4")
+	c.Check(vars, HasLen, 9)
+
+	info := devices.NewDeviceInfo(vars)
+	c.Check(info.Product, Equals, "bullhead")
+	c.Check(info.MaxDownloadSize, Equals, int64(0x20000000))
+	c.Check(info.Lock, Equals, devices.Unlocked)
+	c.Check(info.Secure, Equals, true)
+	c.Check(info.HasSlots(), Equals, false)
+	c.Check(info.Partitions, DeepEquals, map[string]devices.Partition{
+		"system":   {Name: "system", Type: "ext4", Size: 0xa0000000},
+		"userdata": {Name: "userdata", Type: "f2fs", Size: 0x680000000},
+	})
+}
+
+func (s *FastbootVarsTestSuite) TestLockState(c *C) {
+	c.Check(devices.NewDeviceInfo(map[string]string{"unlocked": "no"}).Lock, Equals, devices.Locked)
+	c.Check(devices.NewDeviceInfo(map[string]string{"unlocked": "yes", "secure": "yes"}).Lock, Equals, devices.Unlocked)
+	// secure says nothing about the lock state
+	c.Check(devices.NewDeviceInfo(map[string]string{"secure": "yes"}).Lock, Equals, devices.LockUnknown)
+	c.Check(devices.NewDeviceInfo(map[string]string{"secure": "no"}).Lock, Equals, devices.LockUnknown)
+	c.Check(devices.NewDeviceInfo(map[string]string{}).Lock, Equals, devices.LockUnknown)
+}
+
+func (s *FastbootVarsTestSuite) TestCheckUnlockedUnknown(c *C) {
+	delete(s.server.vars, "unlocked")
+	s.server.vars["secure"] = "yes"
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("tcp:" + s.server.addr())
+	err := fastboot.CheckUnlocked()
+	c.Assert(err, FitsTypeOf, devices.ErrLockUnknown{})
+	c.Check(err, ErrorMatches, "the bootloader of mako does not report if it is unlocked")
+}
+
+func (s *FastbootVarsTestSuite) TestInfoFromCommand(c *C) {
+	script := filepath.Join(c.MkDir(), "fastboot")
+	c.Assert(ioutil.WriteFile(script, []byte("#!/bin/sh\ncat >&2 <<EOF\n"+getvarAll+"EOF\n"), 0755), IsNil)
+	defer devices.SetFastbootCommand(script)()
+
+	var fastboot devices.Fastboot
+	info, err := fastboot.Info()
+	c.Assert(err, IsNil)
+	c.Check(info.Product, Equals, "bullhead")
+	c.Check(info.Partitions["system"].Size, Equals, int64(0xa0000000))
+	c.Check(fastboot.CheckUnlocked(), IsNil)
+}
+
+func (s *FastbootVarsTestSuite) TestInfoOverTcp(c *C) {
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("tcp:" + s.server.addr())
+	info, err := fastboot.Info()
+	c.Assert(err, IsNil)
+	c.Check(info.Product, Equals, "mako")
+	c.Check(info.CurrentSlot, Equals, "a")
+	c.Check(info.HasSlots(), Equals, true)
+	c.Check(info.Partitions["boot_a"], DeepEquals, devices.Partition{Name: "boot_a", Type: "raw", Size: 0x4000000})
+	c.Check(info.Partitions["boot"].HasSlot, Equals, true)
+
+	err = fastboot.CheckUnlocked()
+	c.Assert(err, FitsTypeOf, devices.ErrLocked{})
+	c.Check(err, ErrorMatches, "the bootloader of mako is locked.*")
+}
+
+func (s *FastbootVarsTestSuite) TestSlots(c *C) {
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("tcp:" + s.server.addr())
+	c.Assert(fastboot.SetActive("_b"), IsNil)
+	c.Check(s.server.vars["current-slot"], Equals, "b")
+
+	boot := filepath.Join(c.MkDir(), "boot.img")
+	c.Assert(ioutil.WriteFile(boot, []byte("ANDROID!"), 0644), IsNil)
+	c.Assert(fastboot.FlashSlot("boot", "b", boot), IsNil)
+	c.Check(s.server.flashed["boot_b"], DeepEquals, [][]byte{[]byte("ANDROID!")})
+}
+
+func (s *FastbootVarsTestSuite) TestUnlockFallsBackToOem(c *C) {
+	var fastboot devices.Fastboot
+	fastboot.SetSerial("tcp:" + s.server.addr())
+	c.Assert(fastboot.Unlock(), IsNil)
+	c.Check(s.server.received(), DeepEquals, []string{"flashing unlock", "oem unlock"})
+
+	t, err := devices.DialFastbootTcp(context.Background(), s.server.addr())
+	c.Assert(err, IsNil)
+	fb := devices.NewFastbootClient(t)
+	defer fb.Close()
+	vars, err := fb.GetVarAll()
+	c.Assert(err, IsNil)
+	c.Check(vars["partition-size:boot_a"], Equals, "0x4000000")
+}
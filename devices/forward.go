@@ -0,0 +1,80 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"fmt"
+)
+
+// hostPrefix returns the prefix of host requests aimed at the device
+// selected by serial
+func hostPrefix(serial string) string {
+	if serial == "" {
+		return "host:"
+	}
+	return "host-serial:" + serial + ":"
+}
+
+// forwardRequest sends a forwarding request, these are acknowledged once by
+// the adb server and once more when the forward has been set up
+func forwardRequest(conn *adbConn, req string) error {
+	defer conn.Close()
+	if err := conn.request(req); err != nil {
+		return err
+	}
+	return conn.status(req)
+}
+
+// Forward forwards connections to local on the host to remote on the
+// device, both given as adb sockets, e.g.; tcp:8080 or localabstract:name
+func (adb AndroidDebugBridge) Forward(local, remote string) error {
+	conn, err := dialAdb(context.Background())
+	if err != nil {
+		return err
+	}
+	return forwardRequest(conn, fmt.Sprintf("%sforward:%s;%s", hostPrefix(adb.serial), local, remote))
+}
+
+// RemoveForward removes the forward set up for local
+func (adb AndroidDebugBridge) RemoveForward(local string) error {
+	conn, err := dialAdb(context.Background())
+	if err != nil {
+		return err
+	}
+	return forwardRequest(conn, hostPrefix(adb.serial)+"killforward:"+local)
+}
+
+// Reverse forwards connections to remote on the device to local on the
+// host, the reverse of Forward
+func (adb AndroidDebugBridge) Reverse(remote, local string) error {
+	conn, err := transport(context.Background(), adb.serial)
+	if err != nil {
+		return err
+	}
+	return forwardRequest(conn, fmt.Sprintf("reverse:forward:%s;%s", remote, local))
+}
+
+// RemoveReverse removes the reverse forward set up for remote
+func (adb AndroidDebugBridge) RemoveReverse(remote string) error {
+	conn, err := transport(context.Background(), adb.serial)
+	if err != nil {
+		return err
+	}
+	return forwardRequest(conn, "reverse:killforward:"+remote)
+}
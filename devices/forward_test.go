@@ -0,0 +1,87 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"io/ioutil"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type ForwardTestSuite struct {
+	server  *fakeAdbServer
+	restore func()
+	adb     devices.UbuntuDebugBridge
+}
+
+var _ = Suite(&ForwardTestSuite{})
+
+func (s *ForwardTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeAdbServer()
+	c.Assert(err, IsNil)
+	s.restore = devices.SetAdbServerAddr(s.server.addr())
+	s.adb.SetSerial("0123456789ABCDEF")
+}
+
+func (s *ForwardTestSuite) TearDownTest(c *C) {
+	s.restore()
+	s.server.close()
+}
+
+func (s *ForwardTestSuite) TestForward(c *C) {
+	c.Assert(s.adb.Forward("tcp:8080", "tcp:80"), IsNil)
+	c.Assert(s.adb.RemoveForward("tcp:8080"), IsNil)
+	c.Assert(s.adb.Reverse("tcp:5000", "tcp:5001"), IsNil)
+	c.Assert(s.adb.RemoveReverse("tcp:5000"), IsNil)
+	c.Check(s.server.received(), DeepEquals, []string{
+		"host-serial:0123456789ABCDEF:forward:tcp:8080;tcp:80",
+		"host-serial:0123456789ABCDEF:killforward:tcp:8080",
+		"host:transport:0123456789ABCDEF", "reverse:forward:tcp:5000;tcp:5001",
+		"host:transport:0123456789ABCDEF", "reverse:killforward:tcp:5000",
+	})
+
+	var adb devices.AndroidDebugBridge
+	c.Assert(adb.Forward("tcp:8080", "localabstract:ubuntu"), IsNil)
+	c.Check(s.server.received()[6], Equals, "host:forward:tcp:8080;localabstract:ubuntu")
+}
+
+func (s *ForwardTestSuite) TestLogs(c *C) {
+	s.server.shell["tail -F /var/log/syslog"] = "Jan  1 00:00:00 ubuntu-phablet kernel: hello\n"
+	r, err := s.adb.Logs(context.Background(), devices.LogSyslog)
+	c.Assert(err, IsNil)
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "Jan  1 00:00:00 ubuntu-phablet kernel: hello\n")
+	c.Assert(r.Close(), IsNil)
+
+	_, err = s.adb.Logs(context.Background(), "journal")
+	c.Check(err, ErrorMatches, `unknown log source "journal"`)
+}
+
+func (s *ForwardTestSuite) TestLogsCloseBeforeEnd(c *C) {
+	s.server.shell["dmesg -w"] = "[    0.000000] Booting Linux\n"
+	r, err := s.adb.Logs(context.Background(), devices.LogKernel)
+	c.Assert(err, IsNil)
+	buf := make([]byte, 4)
+	_, err = r.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+}
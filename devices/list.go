@@ -0,0 +1,172 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"strings"
+)
+
+// Transports a device can be attached over
+const (
+	TransportAdb      = "adb"
+	TransportFastboot = "fastboot"
+)
+
+// State is the state of an attached device
+type State string
+
+// States reported for attached devices, adb may report others such as
+// "no permissions" or "authorizing"
+const (
+	StateDevice       State = "device"
+	StateRecovery     State = "recovery"
+	StateSideload     State = "sideload"
+	StateUnauthorized State = "unauthorized"
+	StateOffline      State = "offline"
+	StateBootloader   State = "bootloader"
+)
+
+// AttachedDevice is a device found over adb or fastboot
+type AttachedDevice struct {
+	Serial    string
+	Transport string
+	State     State
+	Product   string
+	Model     string
+	UsbPath   string
+}
+
+// List returns the devices attached over adb and fastboot, devices in
+// the bootloader are only listed if the fastboot command is installed
+func List() ([]AttachedDevice, error) {
+	attached, err := list()
+	if err != nil {
+		return nil, err
+	}
+	for i := range attached {
+		attached[i].lookupProduct()
+	}
+	return attached, nil
+}
+
+// list returns the attached devices without asking the ones in the
+// bootloader for their product
+func list() ([]AttachedDevice, error) {
+	if err := adbStartServer(); err != nil {
+		return nil, err
+	}
+	adbAttached, err := adbDevices(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	var attached []AttachedDevice
+	for _, dev := range adbAttached {
+		attached = append(attached, AttachedDevice{
+			Serial:    dev.serial,
+			Transport: TransportAdb,
+			State:     State(dev.state),
+			Product:   dev.props["product"],
+			Model:     dev.props["model"],
+			UsbPath:   dev.props["usb"],
+		})
+	}
+
+	if _, err := runner.LookPath(fastbootCommand); err != nil {
+		return attached, nil
+	}
+	out, err := runner.Run(context.Background(), fastbootCommand, "devices", "-l")
+	if err != nil {
+		return nil, err
+	}
+	return append(attached, parseFastbootDevices(string(out))...), nil
+}
+
+// lookupProduct asks the bootloader for the product of a device attached
+// over fastboot, it is not known otherwise
+func (dev *AttachedDevice) lookupProduct() {
+	if dev.Transport != TransportFastboot || dev.Product != "" {
+		return
+	}
+	var fastboot Fastboot
+	fastboot.SetSerial(dev.Serial)
+	dev.Product, _ = fastboot.GetDevice()
+}
+
+// parseFastbootDevices parses the output of fastboot devices -l, one
+// device per line with its serial, "fastboot" and its usb path
+func parseFastbootDevices(out string) (attached []AttachedDevice) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		dev := AttachedDevice{Serial: fields[0], Transport: TransportFastboot, State: StateBootloader}
+		for _, field := range fields[2:] {
+			if strings.HasPrefix(field, "usb:") {
+				dev.UsbPath = strings.TrimPrefix(field, "usb:")
+			}
+		}
+		attached = append(attached, dev)
+	}
+	return attached
+}
+
+// Select returns the attached device with serial, with an empty serial
+// it returns the only device attached and refuses to pick one if there
+// are several. Only the selected device is talked to
+func Select(serial string) (AttachedDevice, error) {
+	// devices on the network are only listed once connected to
+	if IsNetworkSerial(serial) {
+		if _, err := Connect(context.Background(), serial); err != nil {
+			return AttachedDevice{}, err
+		}
+	}
+	attached, err := list()
+	if err != nil {
+		return AttachedDevice{}, err
+	}
+	dev, err := selectDevice(attached, serial)
+	if err != nil {
+		return AttachedDevice{}, err
+	}
+	dev.lookupProduct()
+	return dev, nil
+}
+
+func selectDevice(attached []AttachedDevice, serial string) (AttachedDevice, error) {
+	if serial != "" {
+		for _, dev := range attached {
+			if dev.Serial == serial {
+				return dev, nil
+			}
+		}
+		return AttachedDevice{}, ErrNoDevice{serial}
+	}
+	switch len(attached) {
+	case 0:
+		return AttachedDevice{}, ErrNoDevice{}
+	case 1:
+		return attached[0], nil
+	}
+	var serials []string
+	for _, dev := range attached {
+		serials = append(serials, dev.Serial)
+	}
+	return AttachedDevice{}, ErrMultipleDevices{serials}
+}
@@ -0,0 +1,85 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type ListTestSuite struct {
+	server  *fakeAdbServer
+	restore []func()
+}
+
+var _ = Suite(&ListTestSuite{})
+
+func (s *ListTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeAdbServer()
+	c.Assert(err, IsNil)
+	s.restore = []func(){
+		devices.SetAdbServerAddr(s.server.addr()),
+		devices.SetFastbootCommand("/nonexistent/fastboot"),
+	}
+}
+
+func (s *ListTestSuite) TearDownTest(c *C) {
+	for _, restore := range s.restore {
+		restore()
+	}
+	s.server.close()
+}
+
+func (s *ListTestSuite) TestList(c *C) {
+	attached, err := devices.List()
+	c.Assert(err, IsNil)
+	c.Check(attached, DeepEquals, []devices.AttachedDevice{{
+		Serial:    "0123456789ABCDEF",
+		Transport: devices.TransportAdb,
+		State:     devices.StateDevice,
+		Product:   "bacon",
+		Model:     "A0001",
+		UsbPath:   "1-1",
+	}})
+
+	dev, err := devices.Select("")
+	c.Assert(err, IsNil)
+	c.Check(dev.Serial, Equals, "0123456789ABCDEF")
+	_, err = devices.Select("missing")
+	c.Check(err, FitsTypeOf, devices.ErrNoDevice{})
+}
+
+func (s *ListTestSuite) TestParseFastbootDevices(c *C) {
+	out := "0123456789ABCDEF       fastboot usb:3-2\nemulator-5554\tfastboot\n\n"
+	c.Check(devices.ParseFastbootDevices(out), DeepEquals, []devices.AttachedDevice{
+		{Serial: "0123456789ABCDEF", Transport: devices.TransportFastboot, State: devices.StateBootloader, UsbPath: "3-2"},
+		{Serial: "emulator-5554", Transport: devices.TransportFastboot, State: devices.StateBootloader},
+	})
+}
+
+func (s *ListTestSuite) TestSelectRefusesToGuess(c *C) {
+	attached := []devices.AttachedDevice{{Serial: "a"}, {Serial: "b"}}
+	_, err := devices.SelectDevice(attached, "")
+	c.Check(err, ErrorMatches, `more than one device attached \(a, b\), select one by serial`)
+	dev, err := devices.SelectDevice(attached, "b")
+	c.Assert(err, IsNil)
+	c.Check(dev.Serial, Equals, "b")
+	_, err = devices.SelectDevice(nil, "")
+	c.Check(err, ErrorMatches, "no devices attached")
+}
@@ -0,0 +1,75 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// LogSource selects the log streamed by Logs
+type LogSource string
+
+const (
+	// LogLogcat is the android log
+	LogLogcat LogSource = "logcat"
+	// LogKernel is the kernel ring buffer
+	LogKernel LogSource = "kernel"
+	// LogSyslog is /var/log/syslog on Ubuntu devices
+	LogSyslog LogSource = "syslog"
+)
+
+// logCommands are the commands that follow each LogSource
+var logCommands = map[LogSource][]string{
+	LogLogcat: {"logcat"},
+	LogKernel: {"dmesg", "-w"},
+	LogSyslog: {"tail", "-F", "/var/log/syslog"},
+}
+
+// logStream is a log being followed on the device
+type logStream struct {
+	io.Reader
+	session *ShellSession
+	cancel  context.CancelFunc
+}
+
+// Close stops following the log
+func (l logStream) Close() error {
+	l.cancel()
+	return l.session.Close()
+}
+
+// Logs follows source on the device until ctx is done or the returned
+// reader is closed
+func (adb AndroidDebugBridge) Logs(ctx context.Context, source LogSource) (io.ReadCloser, error) {
+	command, ok := logCommands[source]
+	if !ok {
+		return nil, fmt.Errorf("unknown log source %q", source)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	session, err := adb.StartShell(ctx, command...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	session.Stdin.Close()
+	go io.Copy(ioutil.Discard, session.Stderr)
+	return logStream{session.Stdout, session, cancel}, nil
+}
@@ -0,0 +1,115 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultAdbPort is where adbd listens once restarted in TCP/IP mode
+const defaultAdbPort = 5555
+
+// IsNetworkSerial returns true for the host:port serials of devices the
+// adb server reaches over the network
+func IsNetworkSerial(serial string) bool {
+	host, port, err := net.SplitHostPort(serial)
+	if err != nil || host == "" {
+		return false
+	}
+	_, err = strconv.Atoi(port)
+	return err == nil
+}
+
+// Connect connects the adb server to the device listening at addr, the
+// port defaults to 5555; the device is known by the returned serial
+func Connect(ctx context.Context, addr string) (serial string, err error) {
+	serial = addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		serial = net.JoinHostPort(addr, strconv.Itoa(defaultAdbPort))
+	}
+	req := "host:connect:" + serial
+	if err := adbStartServer(); err != nil {
+		return "", err
+	}
+	reply, err := adbQuery(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	// failures are reported in an OKAY reply
+	if !strings.HasPrefix(reply, "connected to") && !strings.HasPrefix(reply, "already connected to") {
+		return "", ErrAdb{req, reply}
+	}
+	return serial, nil
+}
+
+// Disconnect drops the connection to the device at addr, or to every
+// device reached over the network if addr is empty
+func Disconnect(ctx context.Context, addr string) error {
+	req := "host:disconnect:" + addr
+	reply, err := adbQuery(ctx, req)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(reply, "error:") || strings.HasPrefix(reply, "no such device") {
+		return ErrAdb{req, reply}
+	}
+	return nil
+}
+
+// restartAdbd runs svc, which restarts adbd in another mode, and checks
+// the device acknowledged it
+func (adb AndroidDebugBridge) restartAdbd(svc string) error {
+	out, err := runService(context.Background(), adb.serial, svc)
+	if err != nil {
+		return err
+	}
+	if reply := strings.TrimSpace(string(out)); !strings.HasPrefix(reply, "restarting") {
+		return ErrAdb{svc, reply}
+	}
+	return nil
+}
+
+// TcpIp restarts adbd on the device listening on port, the device can then
+// be reached with Connect once it is on the same network
+func (adb AndroidDebugBridge) TcpIp(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	return adb.restartAdbd(fmt.Sprintf("tcpip:%d", port))
+}
+
+// Usb restarts adbd on the device listening on USB
+func (adb AndroidDebugBridge) Usb() error {
+	return adb.restartAdbd("usb:")
+}
+
+// reconnect connects again to a device on the network, the adb server
+// drops or keeps offline such devices when they reboot
+func (adb AndroidDebugBridge) reconnect(ctx context.Context, state State) {
+	if !IsNetworkSerial(adb.serial) || (state != "" && state != StateOffline) {
+		return
+	}
+	if state == StateOffline {
+		Disconnect(ctx, adb.serial)
+	}
+	Connect(ctx, adb.serial)
+}
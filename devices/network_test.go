@@ -0,0 +1,106 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"time"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type NetworkTestSuite struct {
+	server  *fakeAdbServer
+	restore []func()
+}
+
+var _ = Suite(&NetworkTestSuite{})
+
+func (s *NetworkTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeAdbServer()
+	c.Assert(err, IsNil)
+	s.restore = []func(){
+		devices.SetAdbServerAddr(s.server.addr()),
+		devices.SetFastbootCommand("/nonexistent/fastboot"),
+		devices.SetPollInterval(10 * time.Millisecond),
+	}
+}
+
+func (s *NetworkTestSuite) TearDownTest(c *C) {
+	for _, restore := range s.restore {
+		restore()
+	}
+	s.server.close()
+}
+
+func (s *NetworkTestSuite) TestIsNetworkSerial(c *C) {
+	c.Check(devices.IsNetworkSerial("192.168.1.20:5555"), Equals, true)
+	c.Check(devices.IsNetworkSerial("phone.lab:5555"), Equals, true)
+	c.Check(devices.IsNetworkSerial("[fe80::1]:5555"), Equals, true)
+	c.Check(devices.IsNetworkSerial("0123456789ABCDEF"), Equals, false)
+	c.Check(devices.IsNetworkSerial("emulator-5554"), Equals, false)
+	c.Check(devices.IsNetworkSerial("tcp:192.168.1.20"), Equals, false)
+	c.Check(devices.IsNetworkSerial("tcp:192.168.1.20:5554"), Equals, false)
+}
+
+func (s *NetworkTestSuite) TestConnectDisconnect(c *C) {
+	serial, err := devices.Connect(context.Background(), "192.168.1.20")
+	c.Assert(err, IsNil)
+	c.Check(serial, Equals, "192.168.1.20:5555")
+	serial, err = devices.Connect(context.Background(), "192.168.1.20:5555")
+	c.Assert(err, IsNil)
+	c.Check(serial, Equals, "192.168.1.20:5555")
+
+	_, err = devices.Connect(context.Background(), "unreachable:5555")
+	c.Assert(err, FitsTypeOf, devices.ErrAdb{})
+	c.Check(err, ErrorMatches, `.*failed to connect to unreachable:5555`)
+
+	c.Assert(devices.Disconnect(context.Background(), "192.168.1.20:5555"), IsNil)
+	c.Check(devices.Disconnect(context.Background(), "192.168.1.20:5555"), ErrorMatches, `.*no such device.*`)
+}
+
+func (s *NetworkTestSuite) TestSelectConnects(c *C) {
+	dev, err := devices.Select("192.168.1.20:5555")
+	c.Assert(err, IsNil)
+	c.Check(dev.Serial, Equals, "192.168.1.20:5555")
+}
+
+func (s *NetworkTestSuite) TestTcpIpAndUsb(c *C) {
+	var adb devices.AndroidDebugBridge
+	adb.SetSerial("0123456789ABCDEF")
+	c.Assert(adb.TcpIp(5555), IsNil)
+	c.Assert(adb.Usb(), IsNil)
+	c.Check(adb.TcpIp(0), ErrorMatches, "invalid port 0")
+	c.Check(s.server.received(), DeepEquals, []string{
+		"host:transport:0123456789ABCDEF", "tcpip:5555",
+		"host:transport:0123456789ABCDEF", "usb:",
+	})
+}
+
+func (s *NetworkTestSuite) TestWaitReconnects(c *C) {
+	var adb devices.UbuntuDebugBridge
+	adb.SetSerial("192.168.1.20:5555")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Assert(adb.WaitForState(ctx, devices.StateDevice), IsNil)
+	c.Check(s.server.received(), DeepEquals, []string{
+		"host:devices-l", "host:version", "host:connect:192.168.1.20:5555", "host:devices-l",
+	})
+}
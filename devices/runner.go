@@ -0,0 +1,61 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Runner runs the adb and fastboot commands the bridges rely on, it can
+// be replaced to exercise flashing flows without a device attached
+type Runner interface {
+	// LookPath returns the path to the command name
+	LookPath(name string) (string, error)
+	// Run runs name with args and returns its combined output
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// execRunner runs commands on the host
+type execRunner struct{}
+
+func (execRunner) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+var runner Runner = execRunner{}
+
+// SetRunner replaces the Runner used for the adb and fastboot commands,
+// the returned function restores the previous one
+func SetRunner(r Runner) (restore func()) {
+	old := runner
+	runner = r
+	return func() { runner = old }
+}
+
+// SetAdbServerAddr points the bridges to the adb server listening at
+// addr, the returned function restores the previous address
+func SetAdbServerAddr(addr string) (restore func()) {
+	old := adbServerAddr
+	adbServerAddr = addr
+	return func() { adbServerAddr = old }
+}
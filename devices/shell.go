@@ -0,0 +1,301 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Packet ids of the shell v2 protocol, each packet is the id followed by
+// the little endian length of its data
+const (
+	shellStdin = iota
+	shellStdout
+	shellStderr
+	shellExit
+	shellCloseStdin
+	shellWindowSize
+)
+
+// shellExitMarker precedes the exit status appended to the output of
+// commands run on devices without the shell v2 protocol
+const shellExitMarker = "\x1fexit:"
+
+// ShellResult is the outcome of a command run on the device, Stderr is
+// empty on devices without the shell v2 protocol as the streams are
+// merged into Stdout
+type ShellResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Success returns true if the command exited with status 0
+func (r ShellResult) Success() bool {
+	return r.ExitCode == 0
+}
+
+// ShellSession is a command running on the device, both Stdout and Stderr
+// need to be read for the command to make progress
+type ShellSession struct {
+	Stdout io.Reader
+	Stderr io.Reader
+	// Stdin feeds the standard input of the command, closing it sends EOF
+	Stdin io.WriteCloser
+
+	conn     *adbConn
+	v2       bool
+	readers  []*io.PipeReader
+	stdout   *io.PipeWriter
+	stderr   *io.PipeWriter
+	mu       sync.Mutex
+	done     chan struct{}
+	exitCode int
+	err      error
+}
+
+// shellV2 returns true if the device selected by serial speaks the shell
+// v2 protocol
+func shellV2(ctx context.Context, serial string) (bool, error) {
+	req := "host:features"
+	if serial != "" {
+		req = fmt.Sprintf("host-serial:%s:features", serial)
+	}
+	features, err := adbQuery(ctx, req)
+	if err != nil {
+		return false, err
+	}
+	for _, feature := range strings.Split(features, ",") {
+		if feature == "shell_v2" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// StartShell runs command on the device without waiting for it to finish,
+// the command is stopped once ctx is done
+func (adb AndroidDebugBridge) StartShell(ctx context.Context, command ...string) (*ShellSession, error) {
+	cmd := strings.Join(command, " ")
+	v2, err := shellV2(ctx, adb.serial)
+	if err != nil {
+		return nil, err
+	}
+	svc := "shell,v2,raw:" + cmd
+	if !v2 {
+		// the command runs in its own shell so the trailer is echoed
+		// whatever the command looks like, e.g.; ending in & or with
+		// an unbalanced quote
+		svc = fmt.Sprintf("shell:sh -c %s; echo \"%s$?\"", shellQuote(cmd), shellExitMarker)
+	}
+	conn, err := service(ctx, adb.serial, svc)
+	if err != nil {
+		return nil, err
+	}
+
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	s := &ShellSession{
+		Stdout:   stdoutR,
+		Stderr:   stderrR,
+		conn:     conn,
+		v2:       v2,
+		readers:  []*io.PipeReader{stdoutR, stderrR},
+		stdout:   stdoutW,
+		stderr:   stderrW,
+		done:     make(chan struct{}),
+		exitCode: -1,
+	}
+	s.Stdin = shellStdinWriter{s}
+	if v2 {
+		go s.demux()
+	} else {
+		go s.scanExitCode()
+	}
+	return s, nil
+}
+
+// finish records the exit status and error of the command
+func (s *ShellSession) finish(exitCode int, err error) {
+	s.exitCode, s.err = exitCode, err
+	s.stdout.CloseWithError(err)
+	s.stderr.CloseWithError(err)
+	s.conn.Close()
+	close(s.done)
+}
+
+// demux splits the shell v2 packets into the output streams
+func (s *ShellSession) demux() {
+	hdr := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(s.conn, hdr); err != nil {
+			if err == io.EOF {
+				err = errors.New("shell closed without an exit status")
+			}
+			s.finish(-1, err)
+			return
+		}
+		data := io.LimitReader(s.conn, int64(binary.LittleEndian.Uint32(hdr[1:])))
+		var err error
+		switch hdr[0] {
+		case shellStdout:
+			_, err = io.Copy(s.stdout, data)
+		case shellStderr:
+			_, err = io.Copy(s.stderr, data)
+		case shellExit:
+			status := make([]byte, 1)
+			if _, err := io.ReadFull(data, status); err != nil {
+				s.finish(-1, err)
+				return
+			}
+			s.finish(int(status[0]), nil)
+			return
+		default:
+			_, err = io.Copy(ioutil.Discard, data)
+		}
+		if err != nil {
+			s.finish(-1, err)
+			return
+		}
+	}
+}
+
+// scanExitCode passes the output through holding back what could be the
+// exit status trailer
+func (s *ShellSession) scanExitCode() {
+	var pending []byte
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.conn.Read(buf)
+		pending = append(pending, buf[:n]...)
+		// keep anything from a possible marker onwards
+		keep := bytes.LastIndexByte(pending, shellExitMarker[0])
+		if keep < 0 {
+			keep = len(pending)
+		}
+		if _, werr := s.stdout.Write(pending[:keep]); werr != nil {
+			s.finish(-1, werr)
+			return
+		}
+		pending = pending[keep:]
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			s.finish(-1, err)
+			return
+		}
+	}
+	trailer := strings.TrimSpace(strings.TrimPrefix(string(pending), shellExitMarker))
+	exitCode, err := strconv.Atoi(trailer)
+	if !strings.HasPrefix(string(pending), shellExitMarker) || err != nil {
+		s.stdout.Write(pending)
+		s.finish(-1, errors.New("shell closed without an exit status"))
+		return
+	}
+	s.finish(exitCode, nil)
+}
+
+type shellStdinWriter struct {
+	s *ShellSession
+}
+
+func (w shellStdinWriter) Write(p []byte) (int, error) {
+	if !w.s.v2 {
+		return 0, errors.New("the device does not support the shell v2 protocol needed for stdin")
+	}
+	if err := w.s.send(shellStdin, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w shellStdinWriter) Close() error {
+	if !w.s.v2 {
+		return nil
+	}
+	return w.s.send(shellCloseStdin, nil)
+}
+
+func (s *ShellSession) send(id byte, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pkt := make([]byte, 5, 5+len(data))
+	pkt[0] = id
+	binary.LittleEndian.PutUint32(pkt[1:], uint32(len(data)))
+	_, err := s.conn.Write(append(pkt, data...))
+	return err
+}
+
+// Wait waits for the command to exit and returns its exit status
+func (s *ShellSession) Wait() (int, error) {
+	<-s.done
+	return s.exitCode, s.err
+}
+
+// Close stops the command discarding any output that was not read
+func (s *ShellSession) Close() error {
+	s.conn.Close()
+	for _, r := range s.readers {
+		r.Close()
+	}
+	<-s.done
+	return nil
+}
+
+// Run runs command on the device feeding it stdin, if not nil, and
+// collects its output and exit status
+func (adb AndroidDebugBridge) Run(ctx context.Context, stdin io.Reader, command ...string) (result ShellResult, err error) {
+	s, err := adb.StartShell(ctx, command...)
+	if err != nil {
+		return result, err
+	}
+	var stdout, stderr bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		io.Copy(&stdout, s.Stdout)
+		wg.Done()
+	}()
+	go func() {
+		io.Copy(&stderr, s.Stderr)
+		wg.Done()
+	}()
+	if stdin != nil {
+		if _, err := io.Copy(s.Stdin, stdin); err != nil {
+			s.conn.Close()
+			s.Wait()
+			wg.Wait()
+			return result, err
+		}
+	}
+	s.Stdin.Close()
+
+	result.ExitCode, err = s.Wait()
+	wg.Wait()
+	result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
+	return result, err
+}
@@ -0,0 +1,111 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices_test
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type ShellTestSuite struct {
+	server  *fakeAdbServer
+	restore func()
+	adb     devices.AndroidDebugBridge
+}
+
+var _ = Suite(&ShellTestSuite{})
+
+func (s *ShellTestSuite) SetUpTest(c *C) {
+	var err error
+	s.server, err = newFakeAdbServer()
+	c.Assert(err, IsNil)
+	s.restore = devices.SetAdbServerAddr(s.server.addr())
+	s.server.shell["ls /missing"] = "partial\n"
+	s.server.stderr["ls /missing"] = "ls: /missing: No such file or directory\n"
+	s.server.exit["ls /missing"] = 1
+	s.adb.SetSerial("0123456789ABCDEF")
+}
+
+func (s *ShellTestSuite) TearDownTest(c *C) {
+	s.restore()
+	s.server.close()
+}
+
+func (s *ShellTestSuite) TestRunSeparatesStreams(c *C) {
+	result, err := s.adb.Run(context.Background(), nil, "ls", "/missing")
+	c.Assert(err, IsNil)
+	c.Check(string(result.Stdout), Equals, "partial\n")
+	c.Check(string(result.Stderr), Equals, "ls: /missing: No such file or directory\n")
+	c.Check(result.ExitCode, Equals, 1)
+	c.Check(result.Success(), Equals, false)
+}
+
+func (s *ShellTestSuite) TestRunWithoutShellV2(c *C) {
+	s.server.v2 = false
+	result, err := s.adb.Run(context.Background(), nil, "ls", "/missing")
+	c.Assert(err, IsNil)
+	// the legacy shell mixes both streams
+	c.Check(string(result.Stdout), Equals, "partial\nls: /missing: No such file or directory\n")
+	c.Check(result.ExitCode, Equals, 1)
+
+	_, err = s.adb.Run(context.Background(), strings.NewReader("input"), "cat")
+	c.Check(err, NotNil)
+}
+
+func (s *ShellTestSuite) TestRunWithoutShellV2Quoting(c *C) {
+	s.server.v2 = false
+	for _, cmd := range []string{"sleep 10 &", "echo hi # comment", `echo "unbalanced`, "echo 'quoted'"} {
+		s.server.exit[cmd] = 2
+		result, err := s.adb.Run(context.Background(), nil, cmd)
+		c.Assert(err, IsNil, Commentf(cmd))
+		c.Check(result.ExitCode, Equals, 2)
+	}
+	received := s.server.received()
+	c.Check(received[len(received)-1], Equals, `shell:sh -c 'echo '\''quoted'\'''; echo "`+"\x1fexit:$?\"")
+}
+
+func (s *ShellTestSuite) TestRunStdin(c *C) {
+	result, err := s.adb.Run(context.Background(), strings.NewReader("some input"), "cat")
+	c.Assert(err, IsNil)
+	c.Check(string(result.Stdout), Equals, "some input")
+	c.Check(result.Success(), Equals, true)
+}
+
+func (s *ShellTestSuite) TestStartShellStreams(c *C) {
+	s.server.shell["dmesg"] = "line 1\nline 2\n"
+	session, err := s.adb.StartShell(context.Background(), "dmesg")
+	c.Assert(err, IsNil)
+	session.Stdin.Close()
+	go ioutil.ReadAll(session.Stderr)
+	out, err := ioutil.ReadAll(session.Stdout)
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, "line 1\nline 2\n")
+	code, err := session.Wait()
+	c.Assert(err, IsNil)
+	c.Check(code, Equals, 0)
+}
+
+func (s *ShellTestSuite) TestShellExitStatus(c *C) {
+	_, err := s.adb.Shell("ls", "/missing")
+	c.Assert(err, FitsTypeOf, devices.ErrShellExit{})
+	c.Check(err, ErrorMatches, `"ls /missing" exited with status 1 on the device: ls: /missing: No such file or directory`)
+}
@@ -0,0 +1,285 @@
+//
+// Helpers to talk to devices that support ADB or Fastboot
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package devices
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// syncMaxData is the biggest DATA packet adbd accepts
+const syncMaxData = 64 * 1024
+
+// Unix file type bits as reported in sync STAT and DENT replies
+const (
+	modeTypeMask = 0170000
+	modeDir      = 0040000
+	modeRegular  = 0100000
+)
+
+// remoteFile is a file on the device as described by the sync service
+type remoteFile struct {
+	name  string
+	mode  uint32
+	size  uint32
+	mtime time.Time
+}
+
+func (f remoteFile) exists() bool {
+	return f.mode != 0
+}
+
+func (f remoteFile) isDir() bool {
+	return f.mode&modeTypeMask == modeDir
+}
+
+// syncConn speaks the sync protocol of adbd, where requests and replies
+// are a 4 character id followed by a little endian length or value
+type syncConn struct {
+	*adbConn
+}
+
+func openSync(serial string) (*syncConn, error) {
+	conn, err := service(context.Background(), serial, "sync:")
+	if err != nil {
+		return nil, err
+	}
+	return &syncConn{conn}, nil
+}
+
+// Close ends the sync session
+func (sc *syncConn) Close() error {
+	sc.send("QUIT", nil)
+	return sc.adbConn.Close()
+}
+
+func (sc *syncConn) send(id string, data []byte) error {
+	pkt := make([]byte, 8, 8+len(data))
+	copy(pkt, id)
+	binary.LittleEndian.PutUint32(pkt[4:], uint32(len(data)))
+	_, err := sc.Write(append(pkt, data...))
+	return err
+}
+
+// readHeader reads the id and the length or value that follows it
+func (sc *syncConn) readHeader() (string, uint32, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(sc, hdr); err != nil {
+		return "", 0, err
+	}
+	return string(hdr[:4]), binary.LittleEndian.Uint32(hdr[4:]), nil
+}
+
+// readFail returns the error carried by a FAIL reply of length n
+func (sc *syncConn) readFail(req string, n uint32) error {
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(sc, msg); err != nil {
+		return err
+	}
+	return ErrAdb{req, string(msg)}
+}
+
+func (sc *syncConn) stat(p string) (f remoteFile, err error) {
+	if err := sc.send("STAT", []byte(p)); err != nil {
+		return f, err
+	}
+	reply := make([]byte, 16)
+	if _, err := io.ReadFull(sc, reply); err != nil {
+		return f, err
+	}
+	if string(reply[:4]) != "STAT" {
+		return f, ErrAdb{"stat " + p, fmt.Sprintf("unexpected reply %q", reply[:4])}
+	}
+	return remoteFile{
+		name:  path.Base(p),
+		mode:  binary.LittleEndian.Uint32(reply[4:]),
+		size:  binary.LittleEndian.Uint32(reply[8:]),
+		mtime: time.Unix(int64(binary.LittleEndian.Uint32(reply[12:])), 0),
+	}, nil
+}
+
+// list returns the entries in the directory p, including . and ..
+func (sc *syncConn) list(p string) (files []remoteFile, err error) {
+	if err := sc.send("LIST", []byte(p)); err != nil {
+		return nil, err
+	}
+	for {
+		reply := make([]byte, 20)
+		if _, err := io.ReadFull(sc, reply); err != nil {
+			return nil, err
+		}
+		switch string(reply[:4]) {
+		case "DONE":
+			return files, nil
+		case "DENT":
+		default:
+			return nil, ErrAdb{"list " + p, fmt.Sprintf("unexpected reply %q", reply[:4])}
+		}
+		name := make([]byte, binary.LittleEndian.Uint32(reply[16:]))
+		if _, err := io.ReadFull(sc, name); err != nil {
+			return nil, err
+		}
+		files = append(files, remoteFile{
+			name:  string(name),
+			mode:  binary.LittleEndian.Uint32(reply[4:]),
+			size:  binary.LittleEndian.Uint32(reply[8:]),
+			mtime: time.Unix(int64(binary.LittleEndian.Uint32(reply[12:])), 0),
+		})
+	}
+}
+
+// push writes the contents of r to dst on the device, adbd creates the
+// directories leading to dst
+func (sc *syncConn) push(r io.Reader, dst string, mode os.FileMode, mtime time.Time) error {
+	req := fmt.Sprintf("%s,%d", dst, modeRegular|uint32(mode.Perm()))
+	if err := sc.send("SEND", []byte(req)); err != nil {
+		return err
+	}
+	buf := make([]byte, syncMaxData)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := sc.send("DATA", buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	done := make([]byte, 8)
+	copy(done, "DONE")
+	binary.LittleEndian.PutUint32(done[4:], uint32(mtime.Unix()))
+	if _, err := sc.Write(done); err != nil {
+		return err
+	}
+
+	id, n, err := sc.readHeader()
+	if err != nil {
+		return err
+	}
+	switch id {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		return sc.readFail("push "+dst, n)
+	}
+	return ErrAdb{"push " + dst, fmt.Sprintf("unexpected reply %q", id)}
+}
+
+// pull writes the contents of src on the device to w
+func (sc *syncConn) pull(src string, w io.Writer) error {
+	if err := sc.send("RECV", []byte(src)); err != nil {
+		return err
+	}
+	for {
+		id, n, err := sc.readHeader()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "DATA":
+			if _, err := io.CopyN(w, sc, int64(n)); err != nil {
+				return err
+			}
+		case "DONE":
+			return nil
+		case "FAIL":
+			return sc.readFail("pull "+src, n)
+		default:
+			return ErrAdb{"pull " + src, fmt.Sprintf("unexpected reply %q", id)}
+		}
+	}
+}
+
+// pushFile copies the local file src to dst
+func (sc *syncConn) pushFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return sc.push(f, dst, fi.Mode(), fi.ModTime())
+}
+
+// pullFile copies the file src on the device to the local file dst
+func (sc *syncConn) pullFile(src, dst string, mode uint32) error {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(mode&0777))
+	if err != nil {
+		return err
+	}
+	if err := sc.pull(src, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pushTree copies src, which may be a directory, to dst
+func (sc *syncConn) pushTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		return sc.pushFile(p, path.Join(dst, filepath.ToSlash(rel)))
+	})
+}
+
+// pullTree copies f, found at src on the device, to dst
+func (sc *syncConn) pullTree(src string, f remoteFile, dst string) error {
+	if !f.isDir() {
+		return sc.pullFile(src, dst, f.mode)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	entries, err := sc.list(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.name == "." || e.name == ".." || strings.Contains(e.name, "/") {
+			continue
+		}
+		// only directories and regular files can be copied over
+		if !e.isDir() && e.mode&modeTypeMask != modeRegular {
+			continue
+		}
+		if err := sc.pullTree(path.Join(src, e.name), e, filepath.Join(dst, e.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
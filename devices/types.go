@@ -19,17 +19,17 @@ package devices
 // You should have received a copy of the GNU General Public License along
 // with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-type deviceCapabilities interface {
-	Reboot()
-}
-
 type device struct {
 	serial, deviceName string
 	params             []string
 }
 
+// Serial returns the serial the device was selected with
+func (d device) Serial() string {
+	return d.serial
+}
+
 type AndroidDebugBridge struct {
-	deviceCapabilities
 	device
 }
 
@@ -38,6 +38,5 @@ type UbuntuDebugBridge struct {
 }
 
 type Fastboot struct {
-	deviceCapabilities
 	device
 }
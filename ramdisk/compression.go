@@ -0,0 +1,154 @@
+//
+// ramdisk - Tool to inspect and modify Android ramdisk cpio archives
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ramdisk
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+)
+
+// Compression is the algorithm a ramdisk archive is compressed with
+type Compression int
+
+const (
+	None Compression = iota
+	Gzip
+	Lz4
+	Xz
+)
+
+var compressionNames = map[Compression]string{
+	None: "none",
+	Gzip: "gzip",
+	Lz4:  "lz4",
+	Xz:   "xz",
+}
+
+func (c Compression) String() string {
+	return compressionNames[c]
+}
+
+var (
+	gzipMagic      = []byte{0x1f, 0x8b}
+	lz4LegacyMagic = []byte{0x02, 0x21, 0x4c, 0x18}
+	lz4FrameMagic  = []byte{0x04, 0x22, 0x4d, 0x18}
+	xzMagic        = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// DetectCompression returns the compression used for the data starting
+// with header, at least 6 bytes are needed to tell them all apart
+func DetectCompression(header []byte) (Compression, error) {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip, nil
+	case bytes.HasPrefix(header, lz4LegacyMagic), bytes.HasPrefix(header, lz4FrameMagic):
+		return Lz4, nil
+	case bytes.HasPrefix(header, xzMagic):
+		return Xz, nil
+	case bytes.HasPrefix(header, []byte(newcMagic)):
+		return None, nil
+	}
+	return None, fmt.Errorf("unknown ramdisk compression, header starts with % x", header)
+}
+
+// decompress returns a reader with the uncompressed contents of r
+func decompress(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case None:
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Lz4:
+		return cmdReader(r, "lz4", "-d", "-c"), nil
+	case Xz:
+		return cmdReader(r, "xz", "--decompress", "--stdout"), nil
+	}
+	return nil, fmt.Errorf("unsupported ramdisk compression %d", c)
+}
+
+// compress returns a writer compressing into w, it needs to be closed to
+// flush everything out to w
+func compress(c Compression, w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case Lz4:
+		// the kernel only unpacks the legacy lz4 format
+		return cmdWriter(w, "lz4", "-l", "-9", "-c")
+	case Xz:
+		// the kernel only verifies crc32 checksums
+		return cmdWriter(w, "xz", "--check=crc32", "--compress", "--stdout")
+	}
+	return nil, fmt.Errorf("unsupported ramdisk compression %d", c)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func cmdReader(r io.Reader, name string, args ...string) io.ReadCloser {
+	rpipe, wpipe := io.Pipe()
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = r
+	cmd.Stdout = wpipe
+
+	go func() {
+		err := cmd.Run()
+		wpipe.CloseWithError(err)
+	}()
+
+	return rpipe
+}
+
+// execWriter feeds a command through its stdin, Close waits for the
+// command to finish
+type execWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (w execWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.cmd.Wait()
+}
+
+func cmdWriter(w io.Writer, name string, args ...string) (io.WriteCloser, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return execWriter{stdin, cmd}, nil
+}
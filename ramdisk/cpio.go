@@ -0,0 +1,178 @@
+//
+// ramdisk - Tool to inspect and modify Android ramdisk cpio archives
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ramdisk
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	newcMagic      = "070701"
+	newcHeaderSize = 110
+	trailerName    = "TRAILER!!!"
+	// PATH_MAX, longer names can only come from a corrupt header
+	maxNameSize = 4096
+	// mkbootfs starts numbering inodes here
+	firstIno = 300000
+)
+
+// File types kept in the Mode of an Entry
+const (
+	ModeTypeMask  = 0170000
+	ModeSocket    = 0140000
+	ModeSymlink   = 0120000
+	ModeFile      = 0100000
+	ModeBlockDev  = 0060000
+	ModeDir       = 0040000
+	ModeCharDev   = 0020000
+	ModeFifo      = 0010000
+	ModePermsMask = 0007777
+)
+
+// Entry is a member of a newc cpio archive, Data holds the contents of
+// regular files and the target of symlinks
+type Entry struct {
+	Name      string
+	Ino       uint32
+	Mode      uint32
+	Uid, Gid  uint32
+	Nlink     uint32
+	Mtime     uint32
+	DevMajor  uint32
+	DevMinor  uint32
+	RdevMajor uint32
+	RdevMinor uint32
+	Data      []byte
+}
+
+// Type returns the file type bits of Mode
+func (e *Entry) Type() uint32 {
+	return e.Mode & ModeTypeMask
+}
+
+// readCpio parses the newc entries in r up to the trailer
+func readCpio(r io.Reader) (entries []*Entry, err error) {
+	br := bufio.NewReader(r)
+	var offset int64
+	hdr := make([]byte, newcHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errors.New("cpio archive is missing its trailer")
+			}
+			return nil, err
+		}
+		if string(hdr[:len(newcMagic)]) != newcMagic {
+			return nil, fmt.Errorf("bad cpio magic %q at offset %d", hdr[:len(newcMagic)], offset)
+		}
+		var fields [13]uint32
+		for i := range fields {
+			start := len(newcMagic) + i*8
+			v, err := strconv.ParseUint(string(hdr[start:start+8]), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad cpio header at offset %d: %s", offset, err)
+			}
+			fields[i] = uint32(v)
+		}
+		e := &Entry{
+			Ino:       fields[0],
+			Mode:      fields[1],
+			Uid:       fields[2],
+			Gid:       fields[3],
+			Nlink:     fields[4],
+			Mtime:     fields[5],
+			DevMajor:  fields[7],
+			DevMinor:  fields[8],
+			RdevMajor: fields[9],
+			RdevMinor: fields[10],
+		}
+		fileSize, nameSize := int64(fields[6]), int64(fields[11])
+		offset += newcHeaderSize
+
+		if nameSize == 0 {
+			return nil, fmt.Errorf("cpio entry at offset %d has no name", offset)
+		} else if nameSize > maxNameSize {
+			return nil, fmt.Errorf("cpio entry at offset %d has a %d bytes long name", offset, nameSize)
+		}
+		name := make([]byte, pad4(newcHeaderSize+nameSize)-newcHeaderSize)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, fmt.Errorf("cannot read cpio entry name at offset %d: %s", offset, err)
+		}
+		e.Name = string(bytes.TrimRight(name[:nameSize], "\x00"))
+		offset += int64(len(name))
+		if e.Name == trailerName {
+			return entries, nil
+		}
+
+		// the buffer grows with what is actually read so a bogus filesize
+		// cannot make us allocate up to 4GiB upfront
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, br, pad4(fileSize)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("cannot read %s from cpio archive: %s", e.Name, err)
+		}
+		e.Data = data.Bytes()[:fileSize]
+		offset += int64(data.Len())
+		entries = append(entries, e)
+	}
+}
+
+// writeCpio writes entries to w as a newc cpio archive followed by the
+// trailer, entries without an inode number are given a fresh one
+func writeCpio(w io.Writer, entries []*Entry) error {
+	ino := uint32(firstIno)
+	for _, e := range entries {
+		if e.Ino >= ino {
+			ino = e.Ino + 1
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	trailer := &Entry{Name: trailerName, Nlink: 1}
+	for _, e := range append(entries, trailer) {
+		h := *e
+		if h.Ino == 0 && e != trailer {
+			h.Ino = ino
+			ino++
+		}
+		if h.Nlink == 0 {
+			h.Nlink = 1
+		}
+		nameSize := int64(len(h.Name) + 1)
+		fmt.Fprintf(bw, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			newcMagic, h.Ino, h.Mode, h.Uid, h.Gid, h.Nlink, h.Mtime, len(h.Data),
+			h.DevMajor, h.DevMinor, h.RdevMajor, h.RdevMinor, nameSize, 0)
+		bw.WriteString(h.Name)
+		bw.Write(make([]byte, pad4(newcHeaderSize+nameSize)-newcHeaderSize-nameSize+1))
+		bw.Write(h.Data)
+		bw.Write(make([]byte, pad4(int64(len(h.Data)))-int64(len(h.Data))))
+	}
+	return bw.Flush()
+}
+
+// pad4 rounds n up to the 4 byte alignment newc uses
+func pad4(n int64) int64 {
+	return (n + 3) &^ 3
+}
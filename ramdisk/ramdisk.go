@@ -0,0 +1,249 @@
+//
+// ramdisk - Tool to inspect and modify Android ramdisk cpio archives
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ramdisk
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Archive holds the entries of a ramdisk and the compression to use when
+// writing it back out
+type Archive struct {
+	Compression Compression
+	entries     []*Entry
+}
+
+// Read decompresses and parses the ramdisk in r
+func Read(r io.Reader) (*Archive, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	c, err := DetectCompression(header)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := decompress(c, br)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	entries, err := readCpio(dr)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{Compression: c, entries: entries}, nil
+}
+
+// Open reads the ramdisk stored in filePath
+func Open(filePath string) (*Archive, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// cleanName turns name into the form used in the archive which has no
+// leading ./ or /
+func cleanName(name string) string {
+	return strings.TrimLeft(path.Clean("/"+name), "/")
+}
+
+// Entries returns the entries in the order they are stored
+func (a *Archive) Entries() []*Entry {
+	return a.entries
+}
+
+// Get returns the entry called name or nil if there is none
+func (a *Archive) Get(name string) *Entry {
+	name = cleanName(name)
+	for _, e := range a.entries {
+		if cleanName(e.Name) == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// ReadFile returns the contents of the regular file called name
+func (a *Archive) ReadFile(name string) ([]byte, error) {
+	e := a.Get(name)
+	if e == nil {
+		return nil, fmt.Errorf("%s does not exist in the ramdisk", name)
+	}
+	if e.Type() != ModeFile {
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+	return e.Data, nil
+}
+
+// Add stores e in the archive replacing any entry with the same name,
+// new entries go at the end
+func (a *Archive) Add(e Entry) {
+	e.Name = cleanName(e.Name)
+	if old := a.Get(e.Name); old != nil {
+		// keep the inode so hard links are not broken up
+		if e.Ino == 0 {
+			e.Ino = old.Ino
+		}
+		*old = e
+		return
+	}
+	a.entries = append(a.entries, &e)
+}
+
+// AddFile stores a regular file owned by root with the permissions in
+// perm, e.g.; 0750
+func (a *Archive) AddFile(name string, perm uint32, data []byte) {
+	a.Add(Entry{Name: name, Mode: ModeFile | perm&ModePermsMask, Data: data})
+}
+
+// AddDir stores a directory owned by root with the permissions in perm
+func (a *Archive) AddDir(name string, perm uint32) {
+	a.Add(Entry{Name: name, Mode: ModeDir | perm&ModePermsMask})
+}
+
+// AddSymlink stores a symlink called name pointing to target
+func (a *Archive) AddSymlink(name, target string) {
+	a.Add(Entry{Name: name, Mode: ModeSymlink | 0777, Data: []byte(target)})
+}
+
+// Delete removes name from the archive, removing a directory removes
+// everything below it
+func (a *Archive) Delete(name string) error {
+	name = cleanName(name)
+	var kept []*Entry
+	for _, e := range a.entries {
+		n := cleanName(e.Name)
+		if n != name && !strings.HasPrefix(n, name+"/") {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == len(a.entries) {
+		return fmt.Errorf("%s does not exist in the ramdisk", name)
+	}
+	a.entries = kept
+	return nil
+}
+
+// Extract unpacks the archive into dir, device nodes, fifos and sockets
+// are skipped as creating them requires privileges. Entries are never
+// written through a symlink so the archive cannot place files outside of
+// dir
+func (a *Archive) Extract(dir string) error {
+	for _, e := range a.entries {
+		name := cleanName(e.Name)
+		if err := checkNoSymlinks(dir, name); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		perm := os.FileMode(e.Mode & 0777)
+		switch e.Type() {
+		case ModeDir:
+			if err := os.MkdirAll(target, perm|0700); err != nil {
+				return err
+			}
+		case ModeFile:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(target, e.Data, perm); err != nil {
+				return err
+			}
+		case ModeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(string(e.Data), target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkNoSymlinks returns an error if any of the path elements of name
+// already in dir is a symlink
+func checkNoSymlinks(dir, name string) error {
+	p := dir
+	for _, elem := range strings.Split(name, "/") {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("cannot extract %s through the symlink %s", name, p)
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the archive to w compressed with Compression
+func (a *Archive) WriteTo(w io.Writer) (n int64, err error) {
+	cw := &countWriter{w: w}
+	zw, err := compress(a.Compression, cw)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeCpio(zw, a.entries); err != nil {
+		zw.Close()
+		return cw.n, err
+	}
+	err = zw.Close()
+	return cw.n, err
+}
+
+// WriteFile writes the archive to filePath compressed with Compression
+func (a *Archive) WriteFile(filePath string) (err error) {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	_, err = a.WriteTo(f)
+	return err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,213 @@
+//
+// ramdisk - Tool to inspect and modify Android ramdisk cpio archives
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ramdisk
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type RamdiskTestSuite struct {
+	tmpdir string
+}
+
+var _ = Suite(&RamdiskTestSuite{})
+
+func (s *RamdiskTestSuite) SetUpTest(c *C) {
+	s.tmpdir = c.MkDir()
+}
+
+// a newc archive holding init as created by mkbootfs
+const initCpio = "070701" +
+	"000493e0" + "000081e8" + "00000000" + "00000000" + "00000001" + "00000000" +
+	"00000003" + "00000000" + "00000000" + "00000000" + "00000000" + "00000005" + "00000000" +
+	"init\x00" + "\x00" + "hi\n" + "\x00" +
+	"070701" +
+	"00000000" + "00000000" + "00000000" + "00000000" + "00000001" + "00000000" +
+	"00000000" + "00000000" + "00000000" + "00000000" + "00000000" + "0000000b" + "00000000" +
+	"TRAILER!!!\x00" + "\x00\x00\x00"
+
+func (s *RamdiskTestSuite) TestReadNewc(c *C) {
+	a, err := Read(bytes.NewBufferString(initCpio))
+	c.Assert(err, IsNil)
+	c.Check(a.Compression, Equals, None)
+	c.Assert(a.Entries(), HasLen, 1)
+	e := a.Entries()[0]
+	c.Check(e.Name, Equals, "init")
+	c.Check(e.Ino, Equals, uint32(firstIno))
+	c.Check(e.Type(), Equals, uint32(ModeFile))
+	c.Check(e.Mode&ModePermsMask, Equals, uint32(0750))
+	c.Check(string(e.Data), Equals, "hi\n")
+
+	var buf bytes.Buffer
+	_, err = a.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Check(buf.String(), Equals, initCpio)
+}
+
+func (s *RamdiskTestSuite) TestReadInvalid(c *C) {
+	_, err := Read(bytes.NewBufferString("garbage"))
+	c.Check(err, NotNil)
+
+	// no trailer
+	_, err = Read(bytes.NewBufferString(initCpio[:128]))
+	c.Check(err, NotNil)
+}
+
+func (s *RamdiskTestSuite) TestReadBogusFileSize(c *C) {
+	// init claims to be 4GiB long
+	bogus := initCpio[:54] + "ffffffff" + initCpio[62:]
+	_, err := Read(bytes.NewBufferString(bogus))
+	c.Check(err, ErrorMatches, "cannot read init from cpio archive: unexpected EOF")
+}
+
+func (s *RamdiskTestSuite) TestReadBogusNameSize(c *C) {
+	bogus := initCpio[:94] + "ffffffff" + initCpio[102:]
+	_, err := Read(bytes.NewBufferString(bogus))
+	c.Check(err, ErrorMatches, "cpio entry at offset 110 has a 4294967295 bytes long name")
+}
+
+func (s *RamdiskTestSuite) TestDetectCompression(c *C) {
+	for header, expected := range map[string]Compression{
+		"\x1f\x8b\x08\x00\x00\x00": Gzip,
+		"\x02\x21\x4c\x18\x00\x00": Lz4,
+		"\x04\x22\x4d\x18\x00\x00": Lz4,
+		"\xfd7zXZ\x00":             Xz,
+		newcMagic:                  None,
+	} {
+		comp, err := DetectCompression([]byte(header))
+		c.Check(err, IsNil)
+		c.Check(comp, Equals, expected)
+	}
+	_, err := DetectCompression([]byte("BZh91AY"))
+	c.Check(err, NotNil)
+}
+
+func (s *RamdiskTestSuite) TestModifyGzip(c *C) {
+	a, err := Read(bytes.NewBufferString(initCpio))
+	c.Assert(err, IsNil)
+	a.Compression = Gzip
+	a.AddDir("etc", 0755)
+	a.AddFile("/etc/fstab.goldfish", 0640, []byte("/dev/block/vda / ext4 ro wait\n"))
+	a.AddSymlink("./fstab", "etc/fstab.goldfish")
+	a.Add(Entry{Name: "dev/console", Mode: ModeCharDev | 0600, Uid: 1000, Gid: 1000, RdevMajor: 5, RdevMinor: 1})
+	a.AddFile("init", 0755, []byte("#!/bin/sh\n"))
+	c.Assert(a.Delete("missing"), NotNil)
+
+	path := filepath.Join(s.tmpdir, "ramdisk.img")
+	c.Assert(a.WriteFile(path), IsNil)
+	raw, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(raw[:2], DeepEquals, gzipMagic)
+
+	b, err := Open(path)
+	c.Assert(err, IsNil)
+	c.Check(b.Compression, Equals, Gzip)
+	c.Assert(b.Entries(), HasLen, 5)
+	// replacing init keeps its place and inode
+	c.Check(b.Entries()[0].Name, Equals, "init")
+	c.Check(b.Entries()[0].Ino, Equals, uint32(firstIno))
+	c.Check(b.Entries()[0].Mode, Equals, uint32(ModeFile|0755))
+	fstab, err := b.ReadFile("etc/fstab.goldfish")
+	c.Assert(err, IsNil)
+	c.Check(string(fstab), Equals, "/dev/block/vda / ext4 ro wait\n")
+	c.Check(string(b.Get("fstab").Data), Equals, "etc/fstab.goldfish")
+	console := b.Get("/dev/console")
+	c.Assert(console, NotNil)
+	c.Check(console.Type(), Equals, uint32(ModeCharDev))
+	c.Check(console.Uid, Equals, uint32(1000))
+	c.Check(console.RdevMajor, Equals, uint32(5))
+	c.Check(console.RdevMinor, Equals, uint32(1))
+	_, err = b.ReadFile("dev/console")
+	c.Check(err, NotNil)
+
+	c.Assert(b.Delete("etc"), IsNil)
+	c.Check(b.Get("etc/fstab.goldfish"), IsNil)
+	c.Check(b.Entries(), HasLen, 3)
+}
+
+func (s *RamdiskTestSuite) TestExtract(c *C) {
+	a := &Archive{}
+	a.AddDir("sbin", 0750)
+	a.AddFile("sbin/adbd", 0750, []byte("adbd"))
+	a.AddSymlink("bin", "sbin")
+	a.Add(Entry{Name: "dev/null", Mode: ModeCharDev | 0666, RdevMajor: 1, RdevMinor: 3})
+	c.Assert(a.Extract(s.tmpdir), IsNil)
+
+	adbd, err := ioutil.ReadFile(filepath.Join(s.tmpdir, "bin", "adbd"))
+	c.Assert(err, IsNil)
+	c.Check(string(adbd), Equals, "adbd")
+	fi, err := os.Stat(filepath.Join(s.tmpdir, "sbin", "adbd"))
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().Perm(), Equals, os.FileMode(0750))
+	_, err = os.Lstat(filepath.Join(s.tmpdir, "dev", "null"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *RamdiskTestSuite) TestExtractThroughSymlink(c *C) {
+	outside := c.MkDir()
+	dir := filepath.Join(s.tmpdir, "root")
+
+	a := &Archive{}
+	a.AddSymlink("etc", outside)
+	a.AddFile("etc/passwd", 0644, []byte("root::0:0::/:/bin/sh\n"))
+	c.Check(a.Extract(dir), ErrorMatches, "cannot extract etc/passwd through the symlink .*/etc")
+	_, err := os.Lstat(filepath.Join(outside, "passwd"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// nor through a symlink with the same name as a later file
+	dir = filepath.Join(s.tmpdir, "root2")
+	a = &Archive{entries: []*Entry{
+		{Name: "passwd", Mode: ModeSymlink | 0777, Data: []byte(filepath.Join(outside, "passwd"))},
+		{Name: "passwd", Mode: ModeFile | 0644, Data: []byte("root")},
+	}}
+	c.Check(a.Extract(dir), ErrorMatches, "cannot extract passwd through the symlink .*/passwd")
+	_, err = os.Lstat(filepath.Join(outside, "passwd"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *RamdiskTestSuite) TestExternalCompressors(c *C) {
+	for comp, cmd := range map[Compression]string{Xz: "xz", Lz4: "lz4"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			c.Logf("%s not found, skipping", cmd)
+			continue
+		}
+		a, err := Read(bytes.NewBufferString(initCpio))
+		c.Assert(err, IsNil)
+		a.Compression = comp
+
+		var buf bytes.Buffer
+		_, err = a.WriteTo(&buf)
+		c.Assert(err, IsNil)
+		b, err := Read(&buf)
+		c.Assert(err, IsNil)
+		c.Check(b.Compression, Equals, comp)
+		data, err := b.ReadFile("init")
+		c.Assert(err, IsNil)
+		c.Check(string(data), Equals, "hi\n")
+	}
+}
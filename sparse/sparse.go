@@ -0,0 +1,224 @@
+//
+// sparse - Tool to convert to and from Android sparse images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package sparse
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const SPARSE_MAGIC = 0xed26ff3a
+
+// Chunk types
+const (
+	ChunkRaw      = 0xcac1
+	ChunkFill     = 0xcac2
+	ChunkDontCare = 0xcac3
+	ChunkCrc32    = 0xcac4
+)
+
+const (
+	majorVersion    = 1
+	fileHeaderSize  = 28
+	chunkHeaderSize = 12
+	// DefaultBlockSize is what img2simg and fastboot use
+	DefaultBlockSize = 4096
+)
+
+// Header is the sparse image file header
+type Header struct {
+	Magic           uint32
+	MajorVersion    uint16
+	MinorVersion    uint16
+	FileHeaderSize  uint16
+	ChunkHeaderSize uint16
+	BlockSize       uint32
+	TotalBlocks     uint32
+	TotalChunks     uint32
+	ImageChecksum   uint32
+}
+
+type chunkHeader struct {
+	Type      uint16
+	Reserved  uint16
+	Blocks    uint32
+	TotalSize uint32
+}
+
+// IsSparse returns true if header is the start of a sparse image
+func IsSparse(header []byte) bool {
+	return len(header) >= 4 && binary.LittleEndian.Uint32(header) == SPARSE_MAGIC
+}
+
+// Reader expands a sparse image into the raw image it describes as it is
+// read, DONT_CARE chunks read as zeros
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+	crc    hash.Hash32
+	// state of the chunk being expanded
+	chunk     chunkHeader
+	remaining int64
+	offset    int64
+	fill      [4]byte
+	chunks    uint32
+	blocks    uint32
+}
+
+// NewReader reads the sparse image header from r
+func NewReader(r io.Reader) (*Reader, error) {
+	sr := &Reader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	if err := binary.Read(sr.r, binary.LittleEndian, &sr.Header); err != nil {
+		return nil, err
+	}
+	hdr := sr.Header
+	if hdr.Magic != SPARSE_MAGIC {
+		return nil, errors.New("not an android sparse image")
+	}
+	if hdr.MajorVersion != majorVersion {
+		return nil, fmt.Errorf("unsupported sparse image version %d.%d", hdr.MajorVersion, hdr.MinorVersion)
+	}
+	if hdr.FileHeaderSize < fileHeaderSize || hdr.ChunkHeaderSize < chunkHeaderSize {
+		return nil, errors.New("invalid sparse image header sizes")
+	}
+	if hdr.BlockSize == 0 || hdr.BlockSize%4 != 0 {
+		return nil, fmt.Errorf("invalid sparse image block size %d", hdr.BlockSize)
+	}
+	if _, err := sr.r.Discard(int(hdr.FileHeaderSize - fileHeaderSize)); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// Size returns the size of the raw image
+func (sr *Reader) Size() int64 {
+	return int64(sr.Header.TotalBlocks) * int64(sr.Header.BlockSize)
+}
+
+// Read reads the raw image contents
+func (sr *Reader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for sr.remaining == 0 {
+		if err := sr.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > sr.remaining {
+		p = p[:sr.remaining]
+	}
+	switch sr.chunk.Type {
+	case ChunkRaw:
+		if n, err = sr.r.Read(p); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	case ChunkFill:
+		for n = range p {
+			p[n] = sr.fill[(sr.offset+int64(n))%4]
+		}
+		n++
+	case ChunkDontCare:
+		for n = range p {
+			p[n] = 0
+		}
+		n++
+	}
+	sr.crc.Write(p[:n])
+	sr.remaining -= int64(n)
+	sr.offset += int64(n)
+	return n, err
+}
+
+// nextChunk reads the next chunk header, it returns io.EOF once all the
+// chunks have been read and checks the image checksum if there is one
+func (sr *Reader) nextChunk() error {
+	hdr := sr.Header
+	if sr.chunks == hdr.TotalChunks {
+		if sr.blocks != hdr.TotalBlocks {
+			return fmt.Errorf("sparse image chunks hold %d blocks instead of %d", sr.blocks, hdr.TotalBlocks)
+		}
+		if hdr.ImageChecksum != 0 && hdr.ImageChecksum != sr.crc.Sum32() {
+			return fmt.Errorf("sparse image checksum %08x does not match its contents (%08x)", hdr.ImageChecksum, sr.crc.Sum32())
+		}
+		return io.EOF
+	}
+	if err := binary.Read(sr.r, binary.LittleEndian, &sr.chunk); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if _, err := sr.r.Discard(int(hdr.ChunkHeaderSize - chunkHeaderSize)); err != nil {
+		return err
+	}
+	sr.chunks++
+
+	c := sr.chunk
+	dataSize := int64(c.TotalSize) - int64(hdr.ChunkHeaderSize)
+	expected := map[uint16]int64{
+		ChunkRaw:      int64(c.Blocks) * int64(hdr.BlockSize),
+		ChunkFill:     4,
+		ChunkDontCare: 0,
+		ChunkCrc32:    4,
+	}
+	size, ok := expected[c.Type]
+	if !ok {
+		return fmt.Errorf("unknown sparse chunk type 0x%04x in chunk %d", c.Type, sr.chunks)
+	}
+	if dataSize != size {
+		return fmt.Errorf("sparse chunk %d of type 0x%04x has %d bytes of data instead of %d", sr.chunks, c.Type, dataSize, size)
+	}
+
+	switch c.Type {
+	case ChunkFill:
+		if _, err := io.ReadFull(sr.r, sr.fill[:]); err != nil {
+			return err
+		}
+	case ChunkCrc32:
+		var crc uint32
+		if err := binary.Read(sr.r, binary.LittleEndian, &crc); err != nil {
+			return err
+		}
+		if crc != sr.crc.Sum32() {
+			return fmt.Errorf("sparse image crc %08x does not match its contents (%08x)", crc, sr.crc.Sum32())
+		}
+		return nil
+	}
+	if uint64(sr.blocks)+uint64(c.Blocks) > uint64(hdr.TotalBlocks) {
+		return fmt.Errorf("sparse chunk %d goes past the %d blocks of the image", sr.chunks, hdr.TotalBlocks)
+	}
+	sr.blocks += c.Blocks
+	sr.remaining = int64(c.Blocks) * int64(hdr.BlockSize)
+	sr.offset = 0
+	return nil
+}
+
+// isFill returns the value block is filled with, if it is
+func isFill(block []byte) (uint32, bool) {
+	if !bytes.Equal(block[:len(block)-4], block[4:]) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(block), true
+}
@@ -0,0 +1,188 @@
+//
+// sparse - Tool to convert to and from Android sparse images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package sparse
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type SparseTestSuite struct{}
+
+var _ = Suite(&SparseTestSuite{})
+
+const blockSize = 4096
+
+// rawImage returns an image with a raw block, two 0xdeadbeef blocks,
+// three zero blocks and a partial raw block
+func rawImage() []byte {
+	var raw bytes.Buffer
+	raw.Write(bytes.Repeat([]byte("raw data"), blockSize/8))
+	fill := make([]byte, 4)
+	binary.LittleEndian.PutUint32(fill, 0xdeadbeef)
+	raw.Write(bytes.Repeat(fill, 2*blockSize/4))
+	raw.Write(make([]byte, 3*blockSize))
+	raw.WriteString("tail")
+	return raw.Bytes()
+}
+
+func expand(c *C, sparse []byte) []byte {
+	c.Assert(IsSparse(sparse), Equals, true)
+	r, err := NewReader(bytes.NewReader(sparse))
+	c.Assert(err, IsNil)
+	raw, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Check(int64(len(raw)), Equals, r.Size())
+	return raw
+}
+
+func (s *SparseTestSuite) TestScan(c *C) {
+	raw := rawImage()
+	img, err := Scan(bytes.NewReader(raw), int64(len(raw)), blockSize, false)
+	c.Assert(err, IsNil)
+	c.Check(img.TotalBlocks, Equals, uint32(7))
+	c.Check(img.Chunks, DeepEquals, []Chunk{
+		{Type: ChunkRaw, Block: 0, Blocks: 1},
+		{Type: ChunkFill, Block: 1, Blocks: 2, Fill: 0xdeadbeef},
+		{Type: ChunkFill, Block: 3, Blocks: 3},
+		{Type: ChunkRaw, Block: 6, Blocks: 1, data: 6 * blockSize},
+	})
+
+	img, err = Scan(bytes.NewReader(raw), int64(len(raw)), blockSize, true)
+	c.Assert(err, IsNil)
+	c.Check(img.Chunks[2], DeepEquals, Chunk{Type: ChunkDontCare, Block: 3, Blocks: 3})
+
+	_, err = Scan(bytes.NewReader(raw), int64(len(raw)), 1001, false)
+	c.Check(err, NotNil)
+}
+
+func (s *SparseTestSuite) TestRoundTrip(c *C) {
+	raw := rawImage()
+	padded := append(raw, make([]byte, 7*blockSize-len(raw))...)
+	for _, crc := range []bool{false, true} {
+		img, err := Scan(bytes.NewReader(raw), int64(len(raw)), blockSize, true)
+		c.Assert(err, IsNil)
+		img.Crc = crc
+
+		var sparse bytes.Buffer
+		n, err := img.WriteTo(&sparse)
+		c.Assert(err, IsNil)
+		c.Check(n, Equals, int64(sparse.Len()))
+		c.Check(img.Size(), Equals, n)
+		c.Check(expand(c, sparse.Bytes()), DeepEquals, padded)
+	}
+}
+
+func (s *SparseTestSuite) TestBadCrc(c *C) {
+	raw := rawImage()
+	img, err := Scan(bytes.NewReader(raw), int64(len(raw)), blockSize, false)
+	c.Assert(err, IsNil)
+	img.Crc = true
+	var buf bytes.Buffer
+	_, err = img.WriteTo(&buf)
+	c.Assert(err, IsNil)
+
+	sparse := buf.Bytes()
+	// corrupt the data of the first raw chunk
+	sparse[fileHeaderSize+chunkHeaderSize] ^= 0xff
+	r, err := NewReader(bytes.NewReader(sparse))
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Check(err, ErrorMatches, "sparse image crc .* does not match its contents .*")
+}
+
+func (s *SparseTestSuite) TestNotSparse(c *C) {
+	c.Check(IsSparse([]byte("raw")), Equals, false)
+	_, err := NewReader(bytes.NewReader(make([]byte, fileHeaderSize)))
+	c.Check(err, ErrorMatches, "not an android sparse image")
+}
+
+func (s *SparseTestSuite) TestParse(c *C) {
+	raw := rawImage()
+	img, err := Scan(bytes.NewReader(raw), int64(len(raw)), blockSize, true)
+	c.Assert(err, IsNil)
+	img.Crc = true
+	var sparse bytes.Buffer
+	_, err = img.WriteTo(&sparse)
+	c.Assert(err, IsNil)
+
+	parsed, err := Parse(bytes.NewReader(sparse.Bytes()), int64(sparse.Len()))
+	c.Assert(err, IsNil)
+	c.Check(parsed.Crc, Equals, true)
+	c.Check(parsed.TotalBlocks, Equals, img.TotalBlocks)
+	c.Assert(parsed.Chunks, HasLen, len(img.Chunks))
+	for i, chunk := range parsed.Chunks {
+		c.Check(chunk.Type, Equals, img.Chunks[i].Type)
+		c.Check(chunk.Blocks, Equals, img.Chunks[i].Blocks)
+		c.Check(chunk.Fill, Equals, img.Chunks[i].Fill)
+	}
+
+	var rewritten bytes.Buffer
+	_, err = parsed.WriteTo(&rewritten)
+	c.Assert(err, IsNil)
+	c.Check(rewritten.Bytes(), DeepEquals, sparse.Bytes())
+
+	_, err = Parse(bytes.NewReader(sparse.Bytes()), int64(sparse.Len()-10))
+	c.Check(err, NotNil)
+}
+
+func (s *SparseTestSuite) TestSplit(c *C) {
+	raw := bytes.Repeat([]byte("0123456789abcdef"), 10*blockSize/16)
+	var sparse bytes.Buffer
+	scanned, err := Scan(bytes.NewReader(raw), int64(len(raw)), blockSize, false)
+	c.Assert(err, IsNil)
+	_, err = scanned.WriteTo(&sparse)
+	c.Assert(err, IsNil)
+
+	img, err := Parse(bytes.NewReader(sparse.Bytes()), int64(sparse.Len()))
+	c.Assert(err, IsNil)
+	c.Assert(img.Chunks, HasLen, 1)
+
+	maxSize := int64(4*blockSize + 100)
+	parts, err := img.Split(maxSize)
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 3)
+
+	// flashing the parts one after the other gives back the image
+	flashed := make([]byte, len(raw))
+	for _, part := range parts {
+		c.Check(part.Size() <= maxSize, Equals, true)
+		var sparse bytes.Buffer
+		_, err := part.WriteTo(&sparse)
+		c.Assert(err, IsNil)
+		expanded := expand(c, sparse.Bytes())
+		c.Assert(expanded, HasLen, len(raw))
+		for _, chunk := range part.Chunks {
+			if chunk.Type == ChunkRaw {
+				start, end := chunk.Block*blockSize, (chunk.Block+chunk.Blocks)*blockSize
+				copy(flashed[start:end], expanded[start:end])
+			}
+		}
+	}
+	c.Check(flashed, DeepEquals, raw)
+
+	_, err = img.Split(blockSize)
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,359 @@
+//
+// sparse - Tool to convert to and from Android sparse images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package sparse
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Chunk describes a run of Blocks blocks starting at Block, Fill is the
+// value FILL chunks repeat
+type Chunk struct {
+	Type   uint16
+	Block  uint32
+	Blocks uint32
+	Fill   uint32
+	// offset of the data of RAW chunks in the source image
+	data int64
+}
+
+// Image is the sparse layout of a raw or sparse source image, the chunks
+// cover every block in order and the data for RAW chunks is read from the
+// source as the sparse image is written out
+type Image struct {
+	BlockSize   uint32
+	TotalBlocks uint32
+	Chunks      []Chunk
+	// Crc adds a CRC32 chunk at the end of the sparse image
+	Crc     bool
+	src     io.ReaderAt
+	srcSize int64
+}
+
+// Scan splits the size bytes of raw into chunks, blocks filled with a
+// repeating 32 bit value become FILL chunks and zeroed blocks become
+// DONT_CARE chunks if skipZeros is set, which leaves whatever was
+// there before on the device when flashed
+func Scan(raw io.ReaderAt, size int64, blockSize uint32, skipZeros bool) (*Image, error) {
+	if blockSize == 0 || blockSize%4 != 0 {
+		return nil, fmt.Errorf("invalid sparse image block size %d", blockSize)
+	}
+	totalBlocks := (size + int64(blockSize) - 1) / int64(blockSize)
+	if totalBlocks > math.MaxUint32 {
+		return nil, fmt.Errorf("%d bytes do not fit in a sparse image with %d byte blocks", size, blockSize)
+	}
+	img := &Image{BlockSize: blockSize, TotalBlocks: uint32(totalBlocks), src: raw, srcSize: size}
+	maxRawBlocks := uint32((math.MaxUint32 - chunkHeaderSize) / blockSize)
+
+	r := bufio.NewReaderSize(img.rawData(Chunk{Blocks: img.TotalBlocks}), 1<<20)
+	block := make([]byte, blockSize)
+	for i := uint32(0); i < img.TotalBlocks; i++ {
+		if _, err := io.ReadFull(r, block); err != nil {
+			return nil, err
+		}
+		c := Chunk{Type: ChunkRaw, Block: i, Blocks: 1, data: int64(i) * int64(blockSize)}
+		if fill, ok := isFill(block); ok {
+			c.Type, c.Fill, c.data = ChunkFill, fill, 0
+			if fill == 0 && skipZeros {
+				c.Type, c.Fill = ChunkDontCare, 0
+			}
+		}
+
+		if n := len(img.Chunks); n > 0 {
+			last := &img.Chunks[n-1]
+			if last.Type == c.Type && last.Fill == c.Fill && (c.Type != ChunkRaw || last.Blocks < maxRawBlocks) {
+				last.Blocks++
+				continue
+			}
+		}
+		img.Chunks = append(img.Chunks, c)
+	}
+	return img, nil
+}
+
+// Parse reads the layout of the sparse image in r so it can be split
+func Parse(r io.ReaderAt, size int64) (*Image, error) {
+	sr, err := NewReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	hdr := sr.Header
+	img := &Image{BlockSize: hdr.BlockSize, TotalBlocks: hdr.TotalBlocks, src: r, srcSize: size}
+
+	offset := int64(hdr.FileHeaderSize)
+	block := uint32(0)
+	for i := uint32(0); i < hdr.TotalChunks; i++ {
+		var ch chunkHeader
+		if err := binary.Read(io.NewSectionReader(r, offset, chunkHeaderSize), binary.LittleEndian, &ch); err != nil {
+			return nil, fmt.Errorf("cannot read sparse chunk %d: %v", i+1, err)
+		}
+		data := offset + int64(hdr.ChunkHeaderSize)
+		offset += int64(ch.TotalSize)
+		if offset > size || int64(ch.TotalSize) < int64(hdr.ChunkHeaderSize) {
+			return nil, fmt.Errorf("sparse chunk %d of %d bytes does not fit in the image", i+1, ch.TotalSize)
+		}
+
+		c := Chunk{Type: ch.Type, Block: block, Blocks: ch.Blocks}
+		switch ch.Type {
+		case ChunkRaw:
+			if offset-data != int64(ch.Blocks)*int64(hdr.BlockSize) {
+				return nil, fmt.Errorf("sparse chunk %d does not hold %d blocks", i+1, ch.Blocks)
+			}
+			c.data = data
+		case ChunkFill:
+			fill := make([]byte, 4)
+			if _, err := r.ReadAt(fill, data); err != nil {
+				return nil, err
+			}
+			c.Fill = binary.LittleEndian.Uint32(fill)
+		case ChunkDontCare:
+		case ChunkCrc32:
+			img.Crc = true
+			continue
+		default:
+			return nil, fmt.Errorf("unknown sparse chunk type 0x%04x in chunk %d", ch.Type, i+1)
+		}
+		block += ch.Blocks
+		img.Chunks = append(img.Chunks, c)
+	}
+	if block != hdr.TotalBlocks {
+		return nil, fmt.Errorf("sparse image chunks hold %d blocks instead of %d", block, hdr.TotalBlocks)
+	}
+	return img, nil
+}
+
+// rawData returns the contents of the RAW chunk c, the last block is
+// padded with zeros if the raw source image is not block aligned
+func (img *Image) rawData(c Chunk) io.Reader {
+	start := c.data
+	length := int64(c.Blocks) * int64(img.BlockSize)
+	available := img.srcSize - start
+	if available > length {
+		available = length
+	}
+	if available < 0 {
+		available = 0
+	}
+	return io.MultiReader(
+		io.NewSectionReader(img.src, start, available),
+		io.LimitReader(zeros{}, length-available))
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (img *Image) chunkSize(c Chunk) int64 {
+	switch c.Type {
+	case ChunkRaw:
+		return chunkHeaderSize + int64(c.Blocks)*int64(img.BlockSize)
+	case ChunkFill, ChunkCrc32:
+		return chunkHeaderSize + 4
+	}
+	return chunkHeaderSize
+}
+
+// Size returns the size of the sparse image
+func (img *Image) Size() int64 {
+	size := int64(fileHeaderSize)
+	for _, c := range img.Chunks {
+		size += img.chunkSize(c)
+	}
+	if img.Crc {
+		size += chunkHeaderSize + 4
+	}
+	return size
+}
+
+// WriteTo writes the sparse image to w
+func (img *Image) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	crc := crc32.NewIEEE()
+
+	hdr := Header{
+		Magic:           SPARSE_MAGIC,
+		MajorVersion:    majorVersion,
+		FileHeaderSize:  fileHeaderSize,
+		ChunkHeaderSize: chunkHeaderSize,
+		BlockSize:       img.BlockSize,
+		TotalBlocks:     img.TotalBlocks,
+		TotalChunks:     uint32(len(img.Chunks)),
+	}
+	if img.Crc {
+		hdr.TotalChunks++
+	}
+	if err := binary.Write(cw, binary.LittleEndian, hdr); err != nil {
+		return cw.n, err
+	}
+
+	next := uint32(0)
+	for _, c := range img.Chunks {
+		if c.Block != next {
+			return cw.n, fmt.Errorf("sparse chunk at block %d does not follow the previous one ending at %d", c.Block, next)
+		}
+		next += c.Blocks
+		ch := chunkHeader{Type: c.Type, Blocks: c.Blocks, TotalSize: uint32(img.chunkSize(c))}
+		if err := binary.Write(cw, binary.LittleEndian, ch); err != nil {
+			return cw.n, err
+		}
+
+		length := int64(c.Blocks) * int64(img.BlockSize)
+		switch c.Type {
+		case ChunkRaw:
+			var data io.Writer = cw
+			if img.Crc {
+				data = io.MultiWriter(cw, crc)
+			}
+			if _, err := io.Copy(data, img.rawData(c)); err != nil {
+				return cw.n, err
+			}
+		case ChunkFill:
+			if err := binary.Write(cw, binary.LittleEndian, c.Fill); err != nil {
+				return cw.n, err
+			}
+			if img.Crc {
+				pattern := make([]byte, 4)
+				binary.LittleEndian.PutUint32(pattern, c.Fill)
+				io.Copy(crc, io.LimitReader(repeatReader(pattern), length))
+			}
+		case ChunkDontCare:
+			if img.Crc {
+				io.Copy(crc, io.LimitReader(zeros{}, length))
+			}
+		default:
+			return cw.n, fmt.Errorf("cannot write sparse chunk of type 0x%04x", c.Type)
+		}
+	}
+	if next != img.TotalBlocks {
+		return cw.n, fmt.Errorf("sparse chunks cover %d blocks instead of %d", next, img.TotalBlocks)
+	}
+
+	if img.Crc {
+		ch := chunkHeader{Type: ChunkCrc32, TotalSize: chunkHeaderSize + 4}
+		if err := binary.Write(cw, binary.LittleEndian, ch); err != nil {
+			return cw.n, err
+		}
+		if err := binary.Write(cw, binary.LittleEndian, crc.Sum32()); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, bw.Flush()
+}
+
+// Split divides the image into images no bigger than maxSize that are
+// flashed one after the other, as fastboot does for images larger than
+// the max-download-size of the device, the blocks outside of each part
+// are covered with DONT_CARE chunks
+func (img *Image) Split(maxSize int64) ([]*Image, error) {
+	overhead := int64(fileHeaderSize + 2*chunkHeaderSize)
+	if img.Crc {
+		overhead += chunkHeaderSize + 4
+	}
+
+	var parts []*Image
+	part := img.part()
+	size := overhead
+	for _, c := range img.Chunks {
+		for {
+			if cs := img.chunkSize(c); size+cs <= maxSize {
+				part.Chunks = append(part.Chunks, c)
+				size += cs
+				break
+			}
+			if c.Type == ChunkRaw {
+				// fill what is left of this part with part of the chunk
+				if n := (maxSize - size - chunkHeaderSize) / int64(img.BlockSize); n > 0 {
+					head := c
+					head.Blocks = uint32(n)
+					part.Chunks = append(part.Chunks, head)
+					c.Block += head.Blocks
+					c.Blocks -= head.Blocks
+					c.data += int64(head.Blocks) * int64(img.BlockSize)
+				}
+			}
+			if len(part.Chunks) == 0 {
+				return nil, fmt.Errorf("a sparse chunk does not fit in %d bytes", maxSize)
+			}
+			parts = append(parts, part.pad())
+			part, size = img.part(), overhead
+		}
+	}
+	if len(part.Chunks) != 0 || len(parts) == 0 {
+		parts = append(parts, part.pad())
+	}
+	return parts, nil
+}
+
+func (img *Image) part() *Image {
+	return &Image{
+		BlockSize:   img.BlockSize,
+		TotalBlocks: img.TotalBlocks,
+		Crc:         img.Crc,
+		src:         img.src,
+		srcSize:     img.srcSize,
+	}
+}
+
+// pad covers the blocks before and after the chunks with DONT_CARE chunks
+func (img *Image) pad() *Image {
+	if len(img.Chunks) == 0 {
+		if img.TotalBlocks != 0 {
+			img.Chunks = []Chunk{{Type: ChunkDontCare, Blocks: img.TotalBlocks}}
+		}
+		return img
+	}
+	first, last := img.Chunks[0], img.Chunks[len(img.Chunks)-1]
+	if first.Block != 0 {
+		img.Chunks = append([]Chunk{{Type: ChunkDontCare, Blocks: first.Block}}, img.Chunks...)
+	}
+	if end := last.Block + last.Blocks; end != img.TotalBlocks {
+		img.Chunks = append(img.Chunks, Chunk{Type: ChunkDontCare, Block: end, Blocks: img.TotalBlocks - end})
+	}
+	return img
+}
+
+type repeatReader []byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r[i%len(r)]
+	}
+	return len(p), nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
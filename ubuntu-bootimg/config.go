@@ -0,0 +1,209 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"launchpad.net/goget-ubuntu-touch/bootimg"
+)
+
+// config holds the settings abootimg keeps in bootimg.cfg, keys abootimg
+// does not know about are only written out when they are set
+type config struct {
+	BootSize      uint64
+	PageSize      uint32
+	KernelAddr    uint32
+	RamdiskAddr   uint32
+	SecondAddr    uint32
+	TagsAddr      uint32
+	Name          string
+	Cmdline       string
+	HeaderVersion uint32
+	OSVersion     uint32
+	DtbAddr       uint64
+	// files extract writes next to the config for the optional sections
+	// and the data needed to pack the image back as it was, relative to
+	// the config
+	Second           string
+	RecoveryDtbo     string
+	Dtb              string
+	Signature        string
+	KernelMtkHeader  string
+	RamdiskMtkHeader string
+	Trailer          string
+}
+
+type configFile struct {
+	key  string
+	path *string
+}
+
+// files returns the keys of the files cfg refers to in the order they are
+// written
+func (cfg *config) files() []configFile {
+	return []configFile{
+		{"second", &cfg.Second},
+		{"recoverydtbo", &cfg.RecoveryDtbo},
+		{"dtb", &cfg.Dtb},
+		{"signature", &cfg.Signature},
+		{"kernelmtkheader", &cfg.KernelMtkHeader},
+		{"ramdiskmtkheader", &cfg.RamdiskMtkHeader},
+		{"trailer", &cfg.Trailer},
+	}
+}
+
+func newConfig(hdr bootimg.Header, bootSize int64) config {
+	return config{
+		BootSize:      uint64(bootSize),
+		PageSize:      hdr.PageSize,
+		KernelAddr:    hdr.KernelAddr,
+		RamdiskAddr:   hdr.RamdiskAddr,
+		SecondAddr:    hdr.SecondAddr,
+		TagsAddr:      hdr.TagsAddr,
+		Name:          hdr.Name,
+		Cmdline:       hdr.FullCmdline(),
+		HeaderVersion: hdr.HeaderVersion,
+		OSVersion:     hdr.OSVersion,
+		DtbAddr:       hdr.DtbAddr,
+	}
+}
+
+func (cfg config) write(w io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("bootsize = 0x%x", cfg.BootSize),
+		fmt.Sprintf("pagesize = 0x%x", cfg.PageSize),
+		fmt.Sprintf("kerneladdr = 0x%x", cfg.KernelAddr),
+		fmt.Sprintf("ramdiskaddr = 0x%x", cfg.RamdiskAddr),
+		fmt.Sprintf("secondaddr = 0x%x", cfg.SecondAddr),
+		fmt.Sprintf("tagsaddr = 0x%x", cfg.TagsAddr),
+		fmt.Sprintf("name = %s", cfg.Name),
+		fmt.Sprintf("cmdline = %s", cfg.Cmdline),
+	}
+	if cfg.HeaderVersion != 0 {
+		lines = append(lines, fmt.Sprintf("headerversion = %d", cfg.HeaderVersion))
+	}
+	if cfg.OSVersion != 0 {
+		lines = append(lines, fmt.Sprintf("osversion = 0x%x", cfg.OSVersion))
+	}
+	if cfg.DtbAddr != 0 {
+		lines = append(lines, fmt.Sprintf("dtbaddr = 0x%x", cfg.DtbAddr))
+	}
+	for _, f := range cfg.files() {
+		if *f.path != "" {
+			lines = append(lines, fmt.Sprintf("%s = %s", f.key, *f.path))
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func readConfig(r io.Reader) (cfg config, err error) {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return cfg, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		var number *uint32
+		switch key {
+		case "name":
+			cfg.Name = value
+			continue
+		case "cmdline":
+			cfg.Cmdline = value
+			continue
+		case "bootsize":
+			if cfg.BootSize, err = strconv.ParseUint(value, 0, 64); err != nil {
+				return cfg, fmt.Errorf("line %d: invalid %s: %s", n, key, err)
+			}
+			continue
+		case "dtbaddr":
+			if cfg.DtbAddr, err = strconv.ParseUint(value, 0, 64); err != nil {
+				return cfg, fmt.Errorf("line %d: invalid %s: %s", n, key, err)
+			}
+			continue
+		case "pagesize":
+			number = &cfg.PageSize
+		case "kerneladdr":
+			number = &cfg.KernelAddr
+		case "ramdiskaddr":
+			number = &cfg.RamdiskAddr
+		case "secondaddr":
+			number = &cfg.SecondAddr
+		case "tagsaddr":
+			number = &cfg.TagsAddr
+		case "headerversion":
+			number = &cfg.HeaderVersion
+		case "osversion":
+			number = &cfg.OSVersion
+		default:
+			if path := cfg.file(key); path != nil {
+				*path = value
+				continue
+			}
+			return cfg, fmt.Errorf("line %d: unknown key %q", n, key)
+		}
+		v, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("line %d: invalid %s: %s", n, key, err)
+		}
+		*number = uint32(v)
+	}
+	return cfg, scanner.Err()
+}
+
+// file returns where the file for key is kept in cfg or nil if key does
+// not refer to a file
+func (cfg *config) file(key string) *string {
+	for _, f := range cfg.files() {
+		if f.key == key {
+			return f.path
+		}
+	}
+	return nil
+}
+
+// builder returns a Builder laid out with the absolute addresses in cfg
+func (cfg config) builder(kernel, ramdisk []byte) *bootimg.Builder {
+	b := bootimg.NewBuilder(kernel, ramdisk)
+	b.Base = 0
+	b.KernelOffset = cfg.KernelAddr
+	b.RamdiskOffset = cfg.RamdiskAddr
+	b.SecondOffset = cfg.SecondAddr
+	b.TagsOffset = cfg.TagsAddr
+	b.DtbOffset = cfg.DtbAddr
+	if cfg.PageSize != 0 {
+		b.PageSize = cfg.PageSize
+	}
+	b.Name = cfg.Name
+	b.Cmdline = cfg.Cmdline
+	b.HeaderVersion = cfg.HeaderVersion
+	b.OSVersion = cfg.OSVersion
+	return b
+}
@@ -0,0 +1,97 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"testing"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/goget-ubuntu-touch/bootimg"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type ConfigTestSuite struct{}
+
+var _ = Suite(&ConfigTestSuite{})
+
+// as written by abootimg -x
+const abootimgCfg = `bootsize = 0x800000
+pagesize = 0x800
+kerneladdr = 0x10008000
+ramdiskaddr = 0x11000000
+secondaddr = 0x10f00000
+tagsaddr = 0x10000100
+name = 
+cmdline = console=ttyHSL0,115200,n8 androidboot.hardware=mako
+`
+
+func (s *ConfigTestSuite) TestReadAbootimg(c *C) {
+	cfg, err := readConfig(bytes.NewBufferString(abootimgCfg))
+	c.Assert(err, IsNil)
+	c.Check(cfg, DeepEquals, config{
+		BootSize:    0x800000,
+		PageSize:    0x800,
+		KernelAddr:  0x10008000,
+		RamdiskAddr: 0x11000000,
+		SecondAddr:  0x10f00000,
+		TagsAddr:    0x10000100,
+		Cmdline:     "console=ttyHSL0,115200,n8 androidboot.hardware=mako",
+	})
+
+	var buf bytes.Buffer
+	c.Assert(cfg.write(&buf), IsNil)
+	c.Check(buf.String(), Equals, abootimgCfg)
+}
+
+func (s *ConfigTestSuite) TestReadInvalid(c *C) {
+	_, err := readConfig(bytes.NewBufferString("pagesize = big\n"))
+	c.Check(err, ErrorMatches, "line 1: invalid pagesize: .*")
+
+	_, err = readConfig(bytes.NewBufferString("# comment\nfoo = bar\n"))
+	c.Check(err, ErrorMatches, `line 2: unknown key "foo"`)
+
+	_, err = readConfig(bytes.NewBufferString("name\n"))
+	c.Check(err, NotNil)
+}
+
+func (s *ConfigTestSuite) TestRoundTrip(c *C) {
+	b := bootimg.NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.HeaderVersion = 2
+	b.Name = "goldfish"
+	b.Cmdline = "console=ttyS0"
+	b.OSVersion = 7<<25 | 16<<4 | 6
+	// dtb_addr is the only 64 bit address
+	b.DtbOffset = 0x100000000
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	boot, err := bootimg.New(img)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(newConfig(boot.Info(), int64(len(img))).write(&buf), IsNil)
+	cfg, err := readConfig(&buf)
+	c.Assert(err, IsNil)
+
+	repacked, err := cfg.builder([]byte("kernel"), []byte("ramdisk")).Bytes()
+	c.Assert(err, IsNil)
+	c.Check(repacked, DeepEquals, img)
+}
@@ -0,0 +1,134 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type ExtractCmd struct {
+	Dir        string `short:"d" long:"dir" description:"Directory to extract to" default:"."`
+	Positional struct {
+		Image string `positional-arg-name:"boot.img" description:"Boot image to extract" required:"true"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var extractCmd ExtractCmd
+
+func init() {
+	parser.AddCommand("extract",
+		"Extracts all the sections of a boot image",
+		"Extracts all the sections of a boot image along with an abootimg compatible bootimg.cfg",
+		&extractCmd)
+}
+
+func (extractCmd *ExtractCmd) Execute(args []string) error {
+	boot, f, err := openImage(extractCmd.Positional.Image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(extractCmd.Dir, 0755); err != nil {
+		return err
+	}
+	cfg := newConfig(boot.Info(), fi.Size())
+
+	sections := []struct {
+		name  string
+		size  int64
+		write func(string) error
+		// where the file is recorded in the config
+		cfgPath *string
+	}{
+		{kernelFile, boot.Kernel().Size(), boot.WriteKernel, nil},
+		{ramdiskFile, boot.Ramdisk().Size(), boot.WriteRamdisk, nil},
+		{secondFile, boot.Second().Size(), boot.WriteSecond, &cfg.Second},
+		{dtboFile, boot.RecoveryDtbo().Size(), boot.WriteRecoveryDtbo, &cfg.RecoveryDtbo},
+		{dtbFile, boot.Dtb().Size(), boot.WriteDtb, &cfg.Dtb},
+		{signatureFile, boot.Signature().Size(), boot.WriteSignature, &cfg.Signature},
+	}
+	for _, s := range sections {
+		if s.size == 0 {
+			continue
+		}
+		path := filepath.Join(extractCmd.Dir, s.name)
+		if err := s.write(path); err != nil {
+			return err
+		}
+		fmt.Println("Extracted", path)
+		if s.cfgPath != nil {
+			*s.cfgPath = s.name
+		}
+	}
+
+	// what is needed to pack the image back as it was
+	if trailer := boot.Trailer(); trailer.Size() != 0 {
+		if err := extractCmd.writeFile(trailerFile, trailer); err != nil {
+			return err
+		}
+		cfg.Trailer = trailerFile
+	}
+	if mtk := boot.KernelMtkHeader(); mtk != nil {
+		if err := extractCmd.writeFile(kernelFile+mtkSuffix, bytes.NewReader(mtk.Bytes())); err != nil {
+			return err
+		}
+		cfg.KernelMtkHeader = kernelFile + mtkSuffix
+	}
+	if mtk := boot.RamdiskMtkHeader(); mtk != nil {
+		if err := extractCmd.writeFile(ramdiskFile+mtkSuffix, bytes.NewReader(mtk.Bytes())); err != nil {
+			return err
+		}
+		cfg.RamdiskMtkHeader = ramdiskFile + mtkSuffix
+	}
+
+	cfgFile, err := os.Create(filepath.Join(extractCmd.Dir, configName))
+	if err != nil {
+		return err
+	}
+	if err := cfg.write(cfgFile); err != nil {
+		cfgFile.Close()
+		return err
+	}
+	return cfgFile.Close()
+}
+
+func (extractCmd *ExtractCmd) writeFile(name string, r io.Reader) error {
+	path := filepath.Join(extractCmd.Dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println("Extracted", path)
+	return nil
+}
@@ -0,0 +1,138 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"launchpad.net/goget-ubuntu-touch/bootimg"
+)
+
+type InfoCmd struct {
+	Positional struct {
+		Image string `positional-arg-name:"boot.img" description:"Boot image to inspect" required:"true"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var infoCmd InfoCmd
+
+func init() {
+	parser.AddCommand("info",
+		"Shows the contents of a boot image",
+		"Shows the header fields, section sizes and kernel details of a boot image",
+		&infoCmd)
+}
+
+func (infoCmd *InfoCmd) Execute(args []string) error {
+	boot, f, err := openImage(infoCmd.Positional.Image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr := boot.Info()
+	fmt.Printf("Header version: %d\n", hdr.HeaderVersion)
+	fmt.Printf("Page size: %d\n", hdr.PageSize)
+	if hdr.Name != "" {
+		fmt.Printf("Name: %s\n", hdr.Name)
+	}
+	fmt.Printf("Cmdline: %s\n", hdr.FullCmdline())
+	if hdr.OSVersion != 0 {
+		fmt.Printf("OS version: %s (patch level %s)\n", hdr.Release(), hdr.PatchLevel())
+	}
+	if hdr.Version() < 3 {
+		fmt.Printf("Kernel: %d bytes at 0x%08x\n", hdr.KernelSize, hdr.KernelAddr)
+		fmt.Printf("Ramdisk: %d bytes at 0x%08x\n", hdr.RamdiskSize, hdr.RamdiskAddr)
+		fmt.Printf("Second stage: %d bytes at 0x%08x\n", hdr.SecondSize, hdr.SecondAddr)
+		fmt.Printf("Tags: 0x%08x\n", hdr.TagsAddr)
+	} else {
+		fmt.Printf("Kernel: %d bytes\n", hdr.KernelSize)
+		fmt.Printf("Ramdisk: %d bytes\n", hdr.RamdiskSize)
+	}
+	if hdr.Version() == 1 || hdr.Version() == 2 {
+		fmt.Printf("Recovery DTBO: %d bytes at offset %d\n", hdr.RecoveryDtboSize, hdr.RecoveryDtboOffset)
+	}
+	if hdr.Version() == 2 {
+		fmt.Printf("DTB: %d bytes at 0x%08x\n", hdr.DtbSize, hdr.DtbAddr)
+	}
+	if hdr.Version() == 4 {
+		fmt.Printf("Boot signature: %d bytes\n", hdr.SignatureSize)
+	}
+	if hdr.Version() < 3 {
+		if err := boot.VerifyChecksum(); err != nil {
+			fmt.Printf("Id: %x (does not match contents)\n", hdr.Id[:20])
+		} else {
+			fmt.Printf("Id: %x\n", hdr.Id[:20])
+		}
+	}
+
+	if mtk := boot.KernelMtkHeader(); mtk != nil {
+		fmt.Printf("Kernel MediaTek header: %s\n", mtk.Name)
+	}
+	if mtk := boot.RamdiskMtkHeader(); mtk != nil {
+		fmt.Printf("Ramdisk MediaTek header: %s\n", mtk.Name)
+	}
+	if boot.SEAndroid() {
+		fmt.Println("SEAndroid: enforcing")
+	}
+	footer, err := boot.AvbFooter()
+	if err != nil {
+		return err
+	}
+	if footer != nil {
+		printAvbFooter(footer)
+	}
+
+	kernel, err := ioutil.ReadAll(boot.Kernel())
+	if err != nil {
+		return err
+	}
+	info := bootimg.ParseKernel(kernel)
+	fmt.Printf("Kernel format: %s\n", info.Format)
+	if info.Version != "" {
+		fmt.Printf("Kernel version: %s\n", info.Version)
+	}
+	if len(info.Dtbs) != 0 {
+		fmt.Printf("Appended DTBs: %d\n", len(info.Dtbs))
+	}
+	return nil
+}
+
+func printAvbFooter(footer *bootimg.AvbFooter) {
+	vbmeta := footer.Vbmeta
+	fmt.Printf("AVB footer: version %d.%d, original image size %d\n", footer.VersionMajor, footer.VersionMinor, footer.OriginalImageSize)
+	fmt.Printf("AVB vbmeta: %d bytes at offset %d, algorithm %d, rollback index %d, %s\n",
+		footer.VbmetaSize, footer.VbmetaOffset, vbmeta.AlgorithmType, vbmeta.RollbackIndex, vbmeta.ReleaseString)
+	for _, d := range vbmeta.Descriptors {
+		switch d.Tag {
+		case bootimg.AvbDescriptorProperty:
+			fmt.Printf("  Property: %s = %s\n", d.Key, d.Value)
+		case bootimg.AvbDescriptorHash:
+			fmt.Printf("  Hash: %s %d bytes %s %x\n", d.PartitionName, d.ImageSize, d.HashAlgorithm, d.Digest)
+		case bootimg.AvbDescriptorHashtree:
+			fmt.Printf("  Hashtree: %s %d bytes %s %x\n", d.PartitionName, d.ImageSize, d.HashAlgorithm, d.Digest)
+		case bootimg.AvbDescriptorKernelCmdline:
+			fmt.Printf("  Kernel cmdline: %s\n", d.Cmdline)
+		case bootimg.AvbDescriptorChainPartition:
+			fmt.Printf("  Chain partition: %s\n", d.PartitionName)
+		default:
+			fmt.Printf("  Unknown descriptor %d: %d bytes\n", d.Tag, len(d.Data))
+		}
+	}
+}
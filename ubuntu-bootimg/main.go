@@ -0,0 +1,66 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"os"
+
+	flags "github.com/jessevdk/go-flags"
+
+	"launchpad.net/goget-ubuntu-touch/bootimg"
+)
+
+var parser = flags.NewParser(nil, flags.Default)
+
+// Names abootimg uses for the files it extracts
+const (
+	configName    = "bootimg.cfg"
+	kernelFile    = "zImage"
+	ramdiskFile   = "initrd.img"
+	secondFile    = "stage2.img"
+	dtboFile      = "recovery_dtbo.img"
+	dtbFile       = "dtb.img"
+	signatureFile = "boot_signature.img"
+	trailerFile   = "trailer.img"
+	mtkSuffix     = ".mtk"
+)
+
+func main() {
+	if _, err := parser.Parse(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// openImage opens the boot image in imagePath, the returned file needs
+// to remain open while boot is used
+func openImage(imagePath string) (boot bootimg.AndroidBootImg, f *os.File, err error) {
+	f, err = os.Open(imagePath)
+	if err != nil {
+		return boot, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return boot, nil, err
+	}
+	if boot, err = bootimg.Open(f, fi.Size()); err != nil {
+		f.Close()
+		return boot, nil, err
+	}
+	return boot, f, nil
+}
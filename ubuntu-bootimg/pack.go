@@ -0,0 +1,129 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/goget-ubuntu-touch/bootimg"
+)
+
+type PackCmd struct {
+	Config       string `short:"f" long:"config" description:"abootimg compatible configuration file" default:"bootimg.cfg"`
+	Kernel       string `short:"k" long:"kernel" description:"Kernel to add" default:"zImage"`
+	Ramdisk      string `short:"r" long:"ramdisk" description:"Ramdisk to add" default:"initrd.img"`
+	Second       string `short:"s" long:"second" description:"Second stage image to add"`
+	RecoveryDtbo string `long:"recovery-dtbo" description:"Recovery DTBO to add (header version 1 and 2)"`
+	Dtb          string `long:"dtb" description:"DTB to add (header version 2)"`
+	Signature    string `long:"signature" description:"Boot signature to add (header version 4)"`
+	KernelMtk    string `long:"kernel-mtk-header" description:"MediaTek header to wrap the kernel in"`
+	RamdiskMtk   string `long:"ramdisk-mtk-header" description:"MediaTek header to wrap the ramdisk in"`
+	Trailer      string `long:"trailer" description:"Data to store after the last section such as an AVB footer"`
+	Positional   struct {
+		Image string `positional-arg-name:"boot.img" description:"Boot image to create" required:"true"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var packCmd PackCmd
+
+func init() {
+	parser.AddCommand("pack",
+		"Creates a boot image",
+		"Creates a boot image from the sections and configuration file written by extract, the optional sections recorded in the configuration file are used unless given explicitly",
+		&packCmd)
+}
+
+func (packCmd *PackCmd) Execute(args []string) error {
+	cfgFile, err := os.Open(packCmd.Config)
+	if err != nil {
+		return err
+	}
+	defer cfgFile.Close()
+	cfg, err := readConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("%s: %s", packCmd.Config, err)
+	}
+
+	kernel, err := ioutil.ReadFile(packCmd.Kernel)
+	if err != nil {
+		return err
+	}
+	ramdisk, err := ioutil.ReadFile(packCmd.Ramdisk)
+	if err != nil {
+		return err
+	}
+	// sections that are not given explicitly are taken from the config
+	cfgDir := filepath.Dir(packCmd.Config)
+	sectionPath := func(flag, cfgPath string) string {
+		if flag != "" || cfgPath == "" {
+			return flag
+		}
+		return filepath.Join(cfgDir, cfgPath)
+	}
+
+	b := cfg.builder(kernel, ramdisk)
+	for path, data := range map[string]*[]byte{
+		sectionPath(packCmd.Second, cfg.Second):             &b.Second,
+		sectionPath(packCmd.RecoveryDtbo, cfg.RecoveryDtbo): &b.RecoveryDtbo,
+		sectionPath(packCmd.Dtb, cfg.Dtb):                   &b.Dtb,
+		sectionPath(packCmd.Signature, cfg.Signature):       &b.Signature,
+		sectionPath(packCmd.Trailer, cfg.Trailer):           &b.Trailer,
+	} {
+		if path == "" {
+			continue
+		}
+		if *data, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+	}
+
+	if b.KernelMtkHeader, err = readMtkHeader(sectionPath(packCmd.KernelMtk, cfg.KernelMtkHeader)); err != nil {
+		return err
+	}
+	if b.RamdiskMtkHeader, err = readMtkHeader(sectionPath(packCmd.RamdiskMtk, cfg.RamdiskMtkHeader)); err != nil {
+		return err
+	}
+
+	img, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	if cfg.BootSize != 0 && uint64(len(img)) > cfg.BootSize {
+		return fmt.Errorf("boot image is %d bytes which exceeds the configured bootsize of %d", len(img), cfg.BootSize)
+	}
+	return ioutil.WriteFile(packCmd.Positional.Image, img, 0644)
+}
+
+// readMtkHeader loads the MediaTek header extract saved to path, if any
+func readMtkHeader(path string) (*bootimg.MtkHeader, error) {
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mtk, err := bootimg.ParseMtkHeader(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return mtk, nil
+}
@@ -0,0 +1,68 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/goget-ubuntu-touch/bootimg"
+)
+
+type PackTestSuite struct{}
+
+var _ = Suite(&PackTestSuite{})
+
+func (s *PackTestSuite) TestExtractPackRoundTrip(c *C) {
+	dir := c.MkDir()
+	b := bootimg.NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.Second = []byte("second")
+	b.Trailer = []byte("AVBf")
+	var err error
+	b.KernelMtkHeader, err = bootimg.NewMtkHeader("KERNEL")
+	c.Assert(err, IsNil)
+	b.RamdiskMtkHeader, err = bootimg.NewMtkHeader("ROOTFS")
+	c.Assert(err, IsNil)
+	img, err := b.Bytes()
+	c.Assert(err, IsNil)
+	imgPath := filepath.Join(dir, "boot.img")
+	c.Assert(ioutil.WriteFile(imgPath, img, 0644), IsNil)
+
+	extract := ExtractCmd{Dir: filepath.Join(dir, "extracted")}
+	extract.Positional.Image = imgPath
+	c.Assert(extract.Execute(nil), IsNil)
+	cfg, err := ioutil.ReadFile(filepath.Join(extract.Dir, configName))
+	c.Assert(err, IsNil)
+	c.Check(bytes.Contains(cfg, []byte("\nkernelmtkheader = zImage.mtk\n")), Equals, true)
+	c.Check(bytes.Contains(cfg, []byte("\ntrailer = trailer.img\n")), Equals, true)
+
+	// nothing but the config and the kernel and ramdisk needs to be given
+	pack := PackCmd{
+		Config:  filepath.Join(extract.Dir, configName),
+		Kernel:  filepath.Join(extract.Dir, kernelFile),
+		Ramdisk: filepath.Join(extract.Dir, ramdiskFile),
+	}
+	pack.Positional.Image = filepath.Join(dir, "repacked.img")
+	c.Assert(pack.Execute(nil), IsNil)
+	repacked, err := ioutil.ReadFile(pack.Positional.Image)
+	c.Assert(err, IsNil)
+	c.Check(repacked, DeepEquals, img)
+}
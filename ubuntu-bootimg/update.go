@@ -0,0 +1,88 @@
+//
+// ubuntu-bootimg - Tool to inspect, extract and assemble Android boot images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"errors"
+	"io/ioutil"
+)
+
+type UpdateCmd struct {
+	Kernel       string `short:"k" long:"kernel" description:"Kernel to replace the current one with"`
+	Ramdisk      string `short:"r" long:"ramdisk" description:"Ramdisk to replace the current one with"`
+	Second       string `short:"s" long:"second" description:"Second stage image to replace the current one with"`
+	RecoveryDtbo string `long:"recovery-dtbo" description:"Recovery DTBO to replace the current one with"`
+	Dtb          string `long:"dtb" description:"DTB to replace the current one with"`
+	Output       string `short:"o" long:"output" description:"Write the updated image here instead of in place"`
+	Positional   struct {
+		Image string `positional-arg-name:"boot.img" description:"Boot image to update" required:"true"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+var updateCmd UpdateCmd
+
+func init() {
+	parser.AddCommand("update",
+		"Replaces sections of a boot image",
+		"Replaces sections of a boot image keeping the header and the rest of the sections as they are",
+		&updateCmd)
+}
+
+func (updateCmd *UpdateCmd) Execute(args []string) error {
+	boot, f, err := openImage(updateCmd.Positional.Image)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	replacements := []struct {
+		path    string
+		replace func([]byte) error
+	}{
+		{updateCmd.Kernel, boot.ReplaceKernel},
+		{updateCmd.Ramdisk, boot.ReplaceRamdisk},
+		{updateCmd.Second, boot.ReplaceSecond},
+		{updateCmd.RecoveryDtbo, boot.ReplaceRecoveryDtbo},
+		{updateCmd.Dtb, boot.ReplaceDtb},
+	}
+	updated := false
+	for _, r := range replacements {
+		if r.path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(r.path)
+		if err != nil {
+			return err
+		}
+		if err := r.replace(data); err != nil {
+			return err
+		}
+		updated = true
+	}
+	if !updated {
+		return errors.New("nothing to update, select a section to replace")
+	}
+
+	output := updateCmd.Output
+	if output == "" {
+		output = updateCmd.Positional.Image
+	}
+	// once a section is replaced boot no longer reads from the original
+	// file so it is safe to write over it
+	return boot.WriteImage(output)
+}
@@ -30,7 +30,7 @@ import (
 
 type FactoryResetCmd struct {
 	DeveloperMode bool   `long:"developer-mode" description:"Enables developer mode after the factory reset"`
-	Serial        string `long:"serial" description:"Serial of the device to operate"`
+	Serial        string `long:"serial" description:"Serial of the device to operate, or host:port of a device reached over the network"`
 }
 
 var factoryResetCmd FactoryResetCmd
@@ -57,9 +57,11 @@ func (factoryResetCmd *FactoryResetCmd) Execute(args []string) error {
 	if err != nil {
 		log.Fatal(err)
 	}
-	if factoryResetCmd.Serial != "" {
-		adb.SetSerial(factoryResetCmd.Serial)
+	device, err := devices.Select(factoryResetCmd.Serial)
+	if err != nil {
+		return err
 	}
+	adb.SetSerial(device.Serial)
 	if err := adb.Push(ubuntuCommands, "/cache/recovery/ubuntu_command"); err != nil {
 		return err
 	}
@@ -0,0 +1,69 @@
+//
+// ubuntu-device-do - Tool to send commands to an Ubuntu device
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"testing"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+	"launchpad.net/goget-ubuntu-touch/devices/devicestest"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type FactoryResetTestSuite struct {
+	fake    *devicestest.Fake
+	restore func()
+}
+
+var _ = Suite(&FactoryResetTestSuite{})
+
+func (s *FactoryResetTestSuite) SetUpTest(c *C) {
+	var err error
+	s.fake, err = devicestest.New(
+		devicestest.NewDevice("0123456789ABCDEF", "mako", devices.StateDevice),
+		devicestest.NewDevice("FLO0001", "flo", devices.StateBootloader),
+	)
+	c.Assert(err, IsNil)
+	s.restore = s.fake.Install()
+}
+
+func (s *FactoryResetTestSuite) TearDownTest(c *C) {
+	s.restore()
+	s.fake.Close()
+}
+
+func (s *FactoryResetTestSuite) TestFactoryReset(c *C) {
+	cmd := FactoryResetCmd{DeveloperMode: true, Serial: "0123456789ABCDEF"}
+	c.Assert(cmd.Execute(nil), IsNil)
+	c.Check(string(s.fake.File("0123456789ABCDEF", "/cache/recovery/ubuntu_command")), Equals,
+		"format data\nenable developer_mode\nunmount system\n")
+	c.Check(s.fake.State("0123456789ABCDEF"), Equals, devices.StateRecovery)
+	c.Check(s.fake.Calls(), DeepEquals, []string{
+		"adb -s 0123456789ABCDEF push /cache/recovery/ubuntu_command",
+		"adb -s 0123456789ABCDEF reboot recovery",
+	})
+}
+
+func (s *FactoryResetTestSuite) TestFactoryResetInBootloader(c *C) {
+	cmd := FactoryResetCmd{Serial: "FLO0001"}
+	c.Check(cmd.Execute(nil), ErrorMatches, `.*FLO0001.*`)
+	c.Check(s.fake.State("FLO0001"), Equals, devices.StateBootloader)
+}
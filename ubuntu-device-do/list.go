@@ -0,0 +1,51 @@
+//
+// ubuntu-device-do - Tool to send commands to an Ubuntu device
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type ListCmd struct{}
+
+var listCmd ListCmd
+
+func init() {
+	parser.AddCommand("list",
+		"Lists attached devices",
+		"Lists the devices attached over adb and fastboot along with their state",
+		&listCmd)
+}
+
+func (listCmd *ListCmd) Execute(args []string) error {
+	attached, err := devices.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tTRANSPORT\tSTATE\tPRODUCT\tMODEL\tUSB")
+	for _, dev := range attached {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			dev.Serial, dev.Transport, dev.State, dev.Product, dev.Model, dev.UsbPath)
+	}
+	return w.Flush()
+}
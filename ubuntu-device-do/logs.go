@@ -0,0 +1,77 @@
+//
+// ubuntu-device-do - Tool to send commands to an Ubuntu device
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"launchpad.net/goget-ubuntu-touch/devices"
+)
+
+type LogsCmd struct {
+	Source string `long:"source" default:"syslog" description:"Log to follow: syslog, kernel or logcat"`
+	Serial string `long:"serial" description:"Serial of the device to operate, or host:port of a device reached over the network"`
+}
+
+var logsCmd LogsCmd
+
+func init() {
+	parser.AddCommand("logs",
+		"Follows the logs of a device",
+		"Streams the syslog, kernel or logcat log of a device until interrupted",
+		&logsCmd)
+}
+
+func (logsCmd *LogsCmd) Execute(args []string) error {
+	source := devices.LogSource(logsCmd.Source)
+	switch source {
+	case devices.LogSyslog, devices.LogKernel, devices.LogLogcat:
+	default:
+		return fmt.Errorf("unknown log source %q, use syslog, kernel or logcat", logsCmd.Source)
+	}
+
+	device, err := devices.Select(logsCmd.Serial)
+	if err != nil {
+		return err
+	}
+	var adb devices.UbuntuDebugBridge
+	adb.SetSerial(device.Serial)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	logs, err := adb.Logs(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	if _, err := io.Copy(os.Stdout, logs); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
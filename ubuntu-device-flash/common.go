@@ -25,7 +25,7 @@ func getImage(deviceChannel ubuntuimage.DeviceChannel) (image ubuntuimage.Image,
 type Files struct{ FilePath, SigPath string }
 
 // bitDownloader downloads
-func bitDownloader(file ubuntuimage.File, files chan<- Files, server, downloadDir string) {
+func bitDownloader(file ubuntuimage.File, files chan<- Files, server, downloadDir string, progress ubuntuimage.Progress) {
 	// hack to circumvent https://code.google.com/p/go/issues/detail?id=1435
 	if syscall.Getuid() == 0 {
 		runtime.GOMAXPROCS(1)
@@ -40,7 +40,7 @@ func bitDownloader(file ubuntuimage.File, files chan<- Files, server, downloadDi
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = file.Download(downloadDir)
+	err = file.Download(downloadDir, progress)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -35,6 +35,9 @@ type arguments struct {
 	Server        string `long:"server" description:"Use a different image server" default:"https://system-image.ubuntu.com"`
 	CleanCache    bool   `long:"clean-cache" description:"Cleans up cache with all downloaded bits"`
 	TLSSkipVerify bool   `long:"tls-skip-verify" description:"Skip TLS certificate validation"`
+	ArchiveMaster string `long:"archive-master" description:"Keyring the image server signatures are verified against" default:"/usr/share/system-image/archive-master.tar.xz"`
+	MaxDownloads  int    `long:"max-downloads" description:"Maximum number of files downloaded at the same time" default:"4"`
+	Progress      string `long:"progress" description:"How download progress is reported: tty, quiet or json" default:"tty"`
 	Verbose       bool   `long:"verbose" short:"v" description:"More messages will be printed out"`
 }
 
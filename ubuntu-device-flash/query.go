@@ -21,6 +21,7 @@ package main
 // with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -42,6 +43,7 @@ type QueryCmd struct {
 	ShowImage    bool   `long:"show-image" description:"Show information for an image in the given channel"`
 	Channel      string `long:"channel" description:"Specify an alternate channel"`
 	Device       string `long:"device" description:"Specify the device to use as a base for querying" required:"true"`
+	Format       string `long:"format" description:"Output format for --list-images, text or json" default:"text"`
 }
 
 var queryCmd QueryCmd
@@ -50,6 +52,7 @@ func (queryCmd *QueryCmd) Execute(args []string) error {
 	if globalArgs.TLSSkipVerify {
 		ubuntuimage.TLSSkipVerify()
 	}
+	ubuntuimage.ArchiveMasterPath = globalArgs.ArchiveMaster
 
 	if queryCmd.ListChannels {
 		return queryCmd.printChannelList()
@@ -78,7 +81,22 @@ func (queryCmd *QueryCmd) printImageList() error {
 		return err
 	}
 
-	return deviceChannel.ListImageVersions()
+	images := deviceChannel.ListImageVersions()
+	switch queryCmd.Format {
+	case "text":
+		for _, image := range images {
+			fmt.Printf("%d: description='%s'\n", image.Version, image.Description)
+		}
+	case "json":
+		out, err := json.MarshalIndent(images, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	default:
+		return fmt.Errorf("unknown format %q, use text or json", queryCmd.Format)
+	}
+	return nil
 }
 
 func (queryCmd *QueryCmd) printChannelList() error {
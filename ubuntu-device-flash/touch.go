@@ -10,16 +10,19 @@ package main
 
 import (
 	"archive/tar"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"launchpad.net/goget-ubuntu-touch/bootimg"
 	"launchpad.net/goget-ubuntu-touch/devices"
 	"launchpad.net/goget-ubuntu-touch/ubuntuimage"
 )
@@ -34,7 +37,7 @@ func init() {
 type TouchCmd struct {
 	Bootstrap     bool   `long:"bootstrap" description:"bootstrap the system, do this from the bootloader"`
 	Wipe          bool   `long:"wipe" description:"Clear all data after flashing"`
-	Serial        string `long:"serial" description:"Serial of the device to operate"`
+	Serial        string `long:"serial" description:"Serial of the device to operate, or host:port of a device reached over the network"`
 	DeveloperMode bool   `long:"developer-mode" description:"Enables developer mode after the factory reset, this is meant for automation and makes the device insecure by default (requires --password)"`
 	AdbKeys       string `long:"adb-keys" description:"Specify a local adb keys files, instead of using default ~/.android/adbkey.pub (requires --developer-mode)"`
 	DeviceTarball string `long:"device-tarball" description:"Specify a local device tarball to override the one from the server (using official Ubuntu images with different device tarballs)"`
@@ -46,6 +49,7 @@ type TouchCmd struct {
 	RecoveryImage string `long:"recovery-image" description:"Specify the recovery image file to use when flashing, overriding the one from the device tarball (useful if the latter has no adb enabled)"`
 	fastboot      devices.Fastboot
 	adb           devices.UbuntuDebugBridge
+	out           io.Writer
 }
 
 var touchCmd TouchCmd
@@ -54,6 +58,13 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 	if globalArgs.TLSSkipVerify {
 		ubuntuimage.TLSSkipVerify()
 	}
+	ubuntuimage.ArchiveMasterPath = globalArgs.ArchiveMaster
+	ubuntuimage.SetMaxDownloads(globalArgs.MaxDownloads)
+	progress, out, err := ubuntuimage.NewCommandProgress(globalArgs.Progress, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	touchCmd.out = out
 
 	script := touchCmd.RunScript
 	if script != "" {
@@ -96,9 +107,9 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 			home := os.Getenv("HOME")
 			p, err := expandFile(filepath.Join(home, "/.android/adbkey.pub"))
 			if err != nil {
-				fmt.Println("WARNING: missing ~/.android/adbkey.pub, your device will not be preauthorised")
+				fmt.Fprintln(touchCmd.out, "WARNING: missing ~/.android/adbkey.pub, your device will not be preauthorised")
 			} else {
-				fmt.Println("no --adb-keys defined, using default ~/.android/adbkey.pub")
+				fmt.Fprintln(touchCmd.out, "no --adb-keys defined, using default ~/.android/adbkey.pub")
 				adbKeyPath = p
 			}
 		}
@@ -109,11 +120,11 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 	}
 
 	if touchCmd.Password != "" || touchCmd.DeveloperMode {
-		fmt.Println("WARNING --developer-mode and --password are dangerous as they remove security features from your device")
+		fmt.Fprintln(touchCmd.out, "WARNING --developer-mode and --password are dangerous as they remove security features from your device")
 	}
 
 	if touchCmd.AdbKeys != "" && touchCmd.DeveloperMode {
-		fmt.Println("WARNING: --adb-keys is dangerous, potentially authorising multiple cliets to connect to your device")
+		fmt.Fprintln(touchCmd.out, "WARNING: --adb-keys is dangerous, potentially authorising multiple cliets to connect to your device")
 	}
 
 	var deviceTarballPath string
@@ -151,6 +162,16 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 	}
 	log.Printf("Device is |%s|", touchCmd.Device)
 
+	// a locked bootloader would only refuse the recovery image later on
+	if touchCmd.Bootstrap && !globalArgs.DownloadOnly {
+		if err := touchCmd.fastboot.CheckUnlocked(); err != nil {
+			if _, ok := err.(devices.ErrLocked); ok {
+				return err
+			}
+			log.Print("Cannot determine if the bootloader is unlocked: ", err)
+		}
+	}
+
 	deviceChannel, err := channels.GetDeviceChannel(globalArgs.Server, touchCmd.Channel, touchCmd.Device)
 	if err != nil {
 		return err
@@ -182,12 +203,12 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 			image.Files[i].Signature = customTarballPath + ".asc"
 			useLocalTarball(image.Files[i], files)
 		} else {
-			go bitDownloader(file, files, globalArgs.Server, cacheDir)
+			go bitDownloader(file, files, globalArgs.Server, cacheDir, progress)
 		}
 	}
 
 	for _, file := range signFiles {
-		go bitDownloader(file, files, globalArgs.Server, cacheDir)
+		go bitDownloader(file, files, globalArgs.Server, cacheDir, progress)
 	}
 
 	if globalArgs.DownloadOnly {
@@ -226,18 +247,7 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 			}
 		}
 
-		if err := touchCmd.fastboot.Flash("recovery", recovery); err != nil {
-			return errors.New("can't flash recovery image")
-		}
-		if err := touchCmd.fastboot.Format("cache"); err != nil {
-			log.Print("Cache formatting was not successful, flashing may fail, " +
-				"check your partitions on device")
-		}
-
-		if err := touchCmd.fastboot.BootImage(recovery); err != nil {
-			return errors.New("Can't boot recovery image")
-		}
-		if err := touchCmd.adb.WaitForRecovery(); err != nil {
+		if err := touchCmd.bootRecovery(recovery); err != nil {
 			return err
 		}
 	}
@@ -275,7 +285,7 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 	if script != "" {
 		log.Printf("Preparing to run %s to finish the flashing process\n", script)
 		cmd := exec.Command(script)
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = touchCmd.out
 		err = cmd.Run()
 		if err != nil {
 			return err
@@ -292,6 +302,33 @@ func (touchCmd *TouchCmd) Execute(args []string) error {
 	return nil
 }
 
+// bootRecovery flashes and boots recovery from the bootloader and waits
+// for the device to come up in it
+func (touchCmd *TouchCmd) bootRecovery(recovery string) error {
+	if err := touchCmd.fastboot.Flash("recovery", recovery); err != nil {
+		return fmt.Errorf("can't flash recovery image: %s", err)
+	}
+	if err := touchCmd.fastboot.Format("cache"); err != nil {
+		log.Print("Cache formatting was not successful, flashing may fail, " +
+			"check your partitions on device")
+	}
+
+	if err := touchCmd.fastboot.BootImage(recovery); err != nil {
+		return fmt.Errorf("Can't boot recovery image: %s", err)
+	}
+	return touchCmd.adb.WaitForRecovery()
+}
+
+// fastbootSerial returns the serial fastboot reaches a device with, the
+// bootloader of devices on the network speaks fastboot over TCP
+func fastbootSerial(serial string) string {
+	if !devices.IsNetworkSerial(serial) {
+		return serial
+	}
+	host, _, _ := net.SplitHostPort(serial)
+	return "tcp:" + host
+}
+
 func (touchCmd *TouchCmd) setupDevice() (err error) {
 	if adb, err := devices.NewUbuntuDebugBridge(); err == nil {
 		touchCmd.adb = adb
@@ -299,9 +336,24 @@ func (touchCmd *TouchCmd) setupDevice() (err error) {
 		return err
 	}
 
+	// with several devices attached adb and fastboot would pick one at random
+	if touchCmd.Serial == "" {
+		if device, err := devices.Select(""); err == nil {
+			touchCmd.Serial = device.Serial
+		} else if _, ok := err.(devices.ErrMultipleDevices); ok {
+			return err
+		}
+	}
+
 	if touchCmd.Serial != "" {
 		touchCmd.adb.SetSerial(touchCmd.Serial)
-		touchCmd.fastboot.SetSerial(touchCmd.Serial)
+		touchCmd.fastboot.SetSerial(fastbootSerial(touchCmd.Serial))
+	}
+	// the bootloader cannot be reached over adb, recovery reconnects later
+	if devices.IsNetworkSerial(touchCmd.Serial) && !touchCmd.Bootstrap {
+		if _, err := devices.Connect(context.Background(), touchCmd.Serial); err != nil {
+			return err
+		}
 	}
 
 	if touchCmd.Device == "" {
@@ -337,7 +389,7 @@ func bitPusher(adb devices.UbuntuDebugBridge, files <-chan Files, done chan<- bo
 		log.Fatal("Target device cannot be reached over adb")
 	}
 	if _, err := adb.Shell("rm -rf /cache/recovery/*.xz /cache/recovery/*.xz.asc"); err != nil {
-		log.Fatal("Cannot cleanup /cache/recovery/ to ensure clean deployment", err)
+		log.Fatal("Cannot cleanup /cache/recovery/ to ensure clean deployment: ", err)
 	}
 	for {
 		file := <-files
@@ -401,12 +453,37 @@ func tryExtractRecovery(tarxz string) (recovery string, err error) {
 			if _, err := io.Copy(recoveryFile, tr); err != nil {
 				log.Fatal(err)
 			}
+			if err := checkRecovery(recoveryFile.Name()); err != nil {
+				os.Remove(recoveryFile.Name())
+				return "", err
+			}
 			return recoveryFile.Name(), nil
 		}
 	}
 	return "", errors.New("Recovery Partition not found")
 }
 
+// checkRecovery verifies recovery is an android boot image fastboot can boot
+func checkRecovery(recovery string) error {
+	f, err := os.Open(recovery)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	boot, err := bootimg.Open(f, fi.Size())
+	if err != nil {
+		return fmt.Errorf("invalid recovery image: %s", err)
+	}
+	if globalArgs.Verbose {
+		log.Println("Recovery image uses boot image header version", boot.Info().Version())
+	}
+	return nil
+}
+
 func xzReader(r io.Reader) io.ReadCloser {
 	rpipe, wpipe := io.Pipe()
 
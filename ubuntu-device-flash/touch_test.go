@@ -0,0 +1,80 @@
+//
+// ubuntu-device-flash - handles ubuntu disk images
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/devices"
+	"launchpad.net/goget-ubuntu-touch/devices/devicestest"
+)
+
+type TouchTestSuite struct {
+	fake     *devicestest.Fake
+	device   *devicestest.Device
+	restore  func()
+	recovery string
+}
+
+var _ = Suite(&TouchTestSuite{})
+
+func (s *TouchTestSuite) SetUpTest(c *C) {
+	s.device = devicestest.NewDevice("0123456789ABCDEF", "mako", devices.StateBootloader)
+	var err error
+	s.fake, err = devicestest.New(s.device)
+	c.Assert(err, IsNil)
+	s.restore = s.fake.Install()
+	s.recovery = filepath.Join(c.MkDir(), "recovery.img")
+	c.Assert(ioutil.WriteFile(s.recovery, []byte("ANDROID!"), 0644), IsNil)
+}
+
+func (s *TouchTestSuite) TearDownTest(c *C) {
+	s.restore()
+	s.fake.Close()
+}
+
+func (s *TouchTestSuite) TestSetupDeviceBootstrap(c *C) {
+	cmd := TouchCmd{Bootstrap: true}
+	c.Assert(cmd.setupDevice(), IsNil)
+	c.Check(cmd.Serial, Equals, "0123456789ABCDEF")
+	c.Check(cmd.Device, Equals, "mako")
+}
+
+func (s *TouchTestSuite) TestBootRecovery(c *C) {
+	cmd := TouchCmd{Bootstrap: true, Serial: "0123456789ABCDEF"}
+	c.Assert(cmd.setupDevice(), IsNil)
+	c.Assert(cmd.bootRecovery(s.recovery), IsNil)
+	c.Check(s.fake.State("0123456789ABCDEF"), Equals, devices.StateRecovery)
+	c.Check(s.fake.Flashed("0123456789ABCDEF", "recovery"), DeepEquals, []byte("ANDROID!"))
+	c.Check(s.fake.Calls(), DeepEquals, []string{
+		"fastboot -s 0123456789ABCDEF getvar product",
+		"fastboot -s 0123456789ABCDEF flash recovery " + s.recovery,
+		"fastboot -s 0123456789ABCDEF format cache",
+		"fastboot -s 0123456789ABCDEF boot " + s.recovery,
+	})
+}
+
+func (s *TouchTestSuite) TestBootRecoveryLocked(c *C) {
+	s.device.Fail["flash recovery"] = "'Partition flashing is not allowed'"
+	cmd := TouchCmd{Bootstrap: true, Serial: "0123456789ABCDEF"}
+	c.Assert(cmd.setupDevice(), IsNil)
+	c.Check(cmd.bootRecovery(s.recovery), ErrorMatches, "(?s)can't flash recovery image: .*Partition flashing is not allowed.*")
+	c.Check(s.fake.State("0123456789ABCDEF"), Equals, devices.StateBootloader)
+}
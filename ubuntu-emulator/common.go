@@ -22,7 +22,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -41,27 +40,80 @@ func getDeviceTar(files []string) (string, error) {
 
 func extractBoot(dataDir string, bootName string, ramdiskName string) error {
 	bootPath := filepath.Join(dataDir, bootName)
-	imgBytes, err := ioutil.ReadFile(bootPath)
+	bootFile, err := os.Open(bootPath)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Cannot read %s", bootPath))
 	}
-	boot, err := bootimg.New(imgBytes)
+	defer bootFile.Close()
+	bootInfo, err := bootFile.Stat()
+	if err != nil {
+		return err
+	}
+	boot, err := bootimg.Open(bootFile, bootInfo.Size())
 	if err != nil {
 		return err
 	}
 	ramdiskPath := filepath.Join(dataDir, ramdiskName)
-	if err := boot.WriteRamdisk(ramdiskPath); err != nil {
+	// Starting with header version 3 the vendor ramdisk lives in vendor_boot.img
+	// and is loaded ahead of the generic one
+	if version := boot.Info().Version(); version >= 3 {
+		vendorPath := filepath.Join(dataDir, vendorBootImage)
+		vendorFile, err := os.Open(vendorPath)
+		if err != nil {
+			return fmt.Errorf("Cannot read %s required by %s (header version %d)", vendorPath, bootName, version)
+		}
+		defer vendorFile.Close()
+		vendorInfo, err := vendorFile.Stat()
+		if err != nil {
+			return err
+		}
+		vendorBoot, err := bootimg.OpenVendor(vendorFile, vendorInfo.Size())
+		if err != nil {
+			return err
+		}
+		if err := vendorBoot.WriteCombinedRamdisk(&boot, ramdiskPath); err != nil {
+			return err
+		}
+	} else if err := boot.WriteRamdisk(ramdiskPath); err != nil {
 		return err
 	}
 	if ramdiskName == bootRamdisk {
 		kernelPath := filepath.Join(dataDir, kernelName)
-		if boot.WriteKernel(kernelPath); err != nil {
+		if err := boot.WriteKernel(kernelPath); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// recoveryBootImage returns the image in dataDir holding the recovery ramdisk,
+// devices using boot image header version 3 or later carry recovery in
+// boot.img instead of shipping a recovery.img
+func recoveryBootImage(dataDir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dataDir, recoveryImage)); !os.IsNotExist(err) {
+		return recoveryImage, nil
+	}
+	bootPath := filepath.Join(dataDir, bootImage)
+	bootFile, err := os.Open(bootPath)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read %s", bootPath)
+	}
+	defer bootFile.Close()
+	bootInfo, err := bootFile.Stat()
+	if err != nil {
+		return "", err
+	}
+	boot, err := bootimg.Open(bootFile, bootInfo.Size())
+	if err != nil {
+		return "", err
+	}
+	if version := boot.Info().Version(); version < 3 {
+		return "", fmt.Errorf("Cannot read %s, it is required by %s (header version %d)",
+			filepath.Join(dataDir, recoveryImage), bootImage, version)
+	}
+	return bootImage, nil
+}
+
 func instanceExists(path string) bool {
 	f, err := os.Stat(path)
 	if err == nil {
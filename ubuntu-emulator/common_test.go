@@ -0,0 +1,66 @@
+/*
+ * Copyright 2016 Canonical Ltd.
+ *
+ * This file is part of ubuntu-emulator.
+ *
+ * ubuntu-emulator is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * ubuntu-emulator is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+	"launchpad.net/goget-ubuntu-touch/bootimg"
+)
+
+var _ = Suite(&EmulatorCommonTestSuite{})
+
+type EmulatorCommonTestSuite struct {
+	dataDir string
+}
+
+func (s *EmulatorCommonTestSuite) SetUpTest(c *C) {
+	s.dataDir = c.MkDir()
+}
+
+func (s *EmulatorCommonTestSuite) writeBoot(c *C, headerVersion uint32) {
+	b := bootimg.NewBuilder([]byte("kernel"), []byte("ramdisk"))
+	b.HeaderVersion = headerVersion
+	c.Assert(b.WriteImage(filepath.Join(s.dataDir, bootImage)), IsNil)
+}
+
+func (s *EmulatorCommonTestSuite) TestRecoveryImage(c *C) {
+	s.writeBoot(c, 3)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dataDir, recoveryImage), nil, 0644), IsNil)
+	recovery, err := recoveryBootImage(s.dataDir)
+	c.Assert(err, IsNil)
+	c.Check(recovery, Equals, recoveryImage)
+}
+
+func (s *EmulatorCommonTestSuite) TestRecoveryInBootImage(c *C) {
+	s.writeBoot(c, 3)
+	recovery, err := recoveryBootImage(s.dataDir)
+	c.Assert(err, IsNil)
+	c.Check(recovery, Equals, bootImage)
+}
+
+func (s *EmulatorCommonTestSuite) TestMissingRecoveryImage(c *C) {
+	for _, version := range []uint32{0, 1, 2} {
+		s.writeBoot(c, version)
+		_, err := recoveryBootImage(s.dataDir)
+		c.Check(err, ErrorMatches, "Cannot read .*/recovery.img, it is required by boot.img .*")
+	}
+}
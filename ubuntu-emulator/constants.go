@@ -30,6 +30,7 @@ const (
 	bootRamdisk     = "ramdisk.img"
 	recoveryImage   = "recovery.img"
 	recoveryRamdisk = "recovery-ramdisk.img"
+	vendorBootImage = "vendor_boot.img"
 )
 
 var devices map[string]map[string]string
@@ -23,6 +23,7 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -36,14 +37,17 @@ import (
 )
 
 type CreateCmd struct {
-	Channel  string `long:"channel" description:"Select device channel"`
-	Server   string `long:"server" description:"Select image server"`
-	Revision int    `long:"revision" description:"Select revision"`
-	RawDisk  bool   `long:"use-raw-disk" description:"Use raw disks instead of qcow2"`
-	SDCard   bool   `long:"with-sdcard" description:"Create an external vfat sdcard"`
-	Arch     string `long:"arch" description:"Device architecture to use (i386 or armhf)"`
-	Password string `long:"password" description:"This sets up the default password for the phablet user" default:"0000"`
-	Locale   string `long:"locale" description:"Use a different locale than the default one (e.g.; --locale es_AR.utf8)"`
+	Channel       string `long:"channel" description:"Select device channel"`
+	Server        string `long:"server" description:"Select image server"`
+	Revision      int    `long:"revision" description:"Select revision"`
+	RawDisk       bool   `long:"use-raw-disk" description:"Use raw disks instead of qcow2"`
+	SDCard        bool   `long:"with-sdcard" description:"Create an external vfat sdcard"`
+	Arch          string `long:"arch" description:"Device architecture to use (i386 or armhf)"`
+	Password      string `long:"password" description:"This sets up the default password for the phablet user" default:"0000"`
+	Locale        string `long:"locale" description:"Use a different locale than the default one (e.g.; --locale es_AR.utf8)"`
+	Progress      string `long:"progress" description:"How download progress is reported: tty, quiet or json" default:"tty"`
+	ArchiveMaster string `long:"archive-master" description:"Keyring the image server signatures are verified against"`
+	out           io.Writer
 }
 
 var createCmd CreateCmd
@@ -93,6 +97,7 @@ func init() {
 	createCmd.Arch = defaultArch
 	createCmd.Channel = defaultChannel
 	createCmd.Server = defaultServer
+	createCmd.ArchiveMaster = ubuntuimage.ArchiveMasterPath
 	parser.AddCommand("create",
 		"Create new emulator instance named 'name'",
 		"Creates a new emulator instance name 'name' by downloading the necessary components "+
@@ -110,6 +115,12 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 		return err
 	}
 
+	progress, out, err := ubuntuimage.NewCommandProgress(createCmd.Progress, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	createCmd.out = out
+
 	var device string
 	if d, ok := devices[createCmd.Arch]; ok {
 		device = d["name"]
@@ -128,6 +139,7 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 		return err
 	}
 
+	ubuntuimage.ArchiveMasterPath = createCmd.ArchiveMaster
 	channels, err := ubuntuimage.NewChannels(createCmd.Server)
 	if err != nil {
 		return err
@@ -146,15 +158,15 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Creating \"%s\" from %s revision %d\n", instanceName, createCmd.Channel, image.Version)
-	fmt.Println("Downloading...")
-	files, _ := download(image)
+	fmt.Fprintf(createCmd.out, "Creating \"%s\" from %s revision %d\n", instanceName, createCmd.Channel, image.Version)
+	fmt.Fprintln(createCmd.out, "Downloading...")
+	files, _ := download(image, progress)
 	dataDir := getInstanceDataDir(instanceName)
 	if os.MkdirAll(dataDir, 0700) != nil {
 		return err
 	}
 
-	fmt.Println("Setting up...")
+	fmt.Fprintln(createCmd.out, "Setting up...")
 	//This image will later be copied into sdcard.img as system.img and will hold the Ubuntu rootfs
 	ubuntuImage := diskimage.New(filepath.Join(dataDir, "ubuntu-system.img"), "UBUNTU", 3)
 	//This image represents userdata, it will be marked with .writable_image and hold the
@@ -179,8 +191,13 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 		return err
 	}
 
-	// recovery.img must be in dataDir (Recovery Ramdisk)
-	if err = extractBoot(dataDir, recoveryImage, recoveryRamdisk); err != nil {
+	// recovery.img must be in dataDir (Recovery Ramdisk) unless boot.img
+	// carries it
+	recovery, err := recoveryBootImage(dataDir)
+	if err != nil {
+		return err
+	}
+	if err = extractBoot(dataDir, recovery, recoveryRamdisk); err != nil {
 		return err
 	}
 
@@ -189,7 +206,7 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 	}
 
 	if createCmd.RawDisk != true {
-		fmt.Println("Creating snapshots for disks...")
+		fmt.Fprintln(createCmd.out, "Creating snapshots for disks...")
 		for _, img := range []*diskimage.DiskImage{systemImage, sdcardImage} {
 			if err := img.ConvertQcow2(); err != nil {
 				return err
@@ -198,7 +215,7 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 	}
 
 	if createCmd.SDCard {
-		fmt.Println("Creating vfat sdcard...")
+		fmt.Fprintln(createCmd.out, "Creating vfat sdcard...")
 		sdcard := diskimage.New(filepath.Join(dataDir, "sdcardprime.img"), "SDCARD", 2)
 		if err := sdcard.CreateVFat(); err != nil {
 			return err
@@ -212,7 +229,7 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 		return err
 	}
 
-	fmt.Printf("Succesfully created emulator instance %s in %s\n", instanceName, dataDir)
+	fmt.Fprintf(createCmd.out, "Succesfully created emulator instance %s in %s\n", instanceName, dataDir)
 	return nil
 }
 
@@ -256,22 +273,22 @@ func (createCmd *CreateCmd) createSystem(ubuntuImage, sdcardImage *diskimage.Dis
 	}
 	if err := ubuntuImage.Provision(files); err != nil {
 		if err := ubuntuImage.Unmount(); err != nil {
-			fmt.Println("Unmount error:", err)
+			fmt.Fprintln(createCmd.out, "Unmount error:", err)
 		}
 		return err
 	}
 
-	fmt.Printf("Setting up a default password for phablet to: '%s'\n", createCmd.Password)
+	fmt.Fprintf(createCmd.out, "Setting up a default password for phablet to: '%s'\n", createCmd.Password)
 	if err := createCmd.setPassword(ubuntuImage.Mountpoint); err != nil {
 		if err := ubuntuImage.Unmount(); err != nil {
-			fmt.Println("Unmount error :", err)
+			fmt.Fprintln(createCmd.out, "Unmount error :", err)
 		}
 		return err
 	}
 
 	if err := createCmd.setLocale(ubuntuImage.Mountpoint); err != nil {
 		if err := ubuntuImage.Unmount(); err != nil {
-			fmt.Println("Unmount error :", err)
+			fmt.Fprintln(createCmd.out, "Unmount error :", err)
 		}
 		return err
 	}
@@ -390,12 +407,12 @@ func removeQemuStatic(chroot string) error {
 	return os.Remove(dst)
 }
 
-func download(image ubuntuimage.Image) (files []string, err error) {
+func download(image ubuntuimage.Image, progress ubuntuimage.Progress) (files []string, err error) {
 	cacheDir := ubuntuimage.GetCacheDir()
 	totalFiles := len(image.Files)
 	done := make(chan string, totalFiles)
 	for _, file := range image.Files {
-		go bitDownloader(file, done, createCmd.Server, cacheDir)
+		go bitDownloader(file, done, createCmd.Server, cacheDir, progress)
 	}
 	for i := 0; i < totalFiles; i++ {
 		files = append(files, <-done)
@@ -404,23 +421,23 @@ func download(image ubuntuimage.Image) (files []string, err error) {
 }
 
 // bitDownloader downloads
-func bitDownloader(file ubuntuimage.File, done chan<- string, server, downloadDir string) {
+func bitDownloader(file ubuntuimage.File, done chan<- string, server, downloadDir string, progress ubuntuimage.Progress) {
 	err := file.MakeRelativeToServer(server)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	// hack to circumvent https://code.google.com/p/go/issues/detail?id=1435
 	runtime.GOMAXPROCS(1)
 	runtime.LockOSThread()
 	if err := sysutils.DropPrivs(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	err = file.Download(downloadDir)
+	err = file.Download(downloadDir, progress)
 	if err != nil {
-		fmt.Printf("Cannot download %s%s: %s\n", file.Server, file.Path, err)
+		fmt.Fprintf(os.Stderr, "Cannot download %s%s: %s\n", file.Server, file.Path, err)
 		os.Exit(1)
 	}
 	filePath := filepath.Join(downloadDir, file.Path)
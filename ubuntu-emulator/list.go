@@ -23,10 +23,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+
+	"launchpad.net/goget-ubuntu-touch/bootimg"
 )
 
 type ListCmd struct {
-	// TODO Verbose bool   `long:"verbose" description:"Shows additional information from instances listed"`
+	Verbose bool `long:"verbose" description:"Shows additional information from instances listed"`
 }
 
 var listCmd ListCmd
@@ -53,7 +55,28 @@ func (listCmd *ListCmd) Execute(args []string) error {
 		} else {
 			fmt.Println(entry.Name())
 		}
-
+		if listCmd.Verbose {
+			printKernelInfo(filepath.Join(dataDir, entry.Name(), kernelName))
+		}
 	}
 	return nil
 }
+
+// printKernelInfo shows what the extracted kernel for an instance is
+func printKernelInfo(kernelPath string) {
+	kernel, err := ioutil.ReadFile(kernelPath)
+	if err != nil {
+		fmt.Println("\tKernel: unavailable")
+		return
+	}
+	info := bootimg.ParseKernel(kernel)
+	version := info.Version
+	if version == "" {
+		version = "unknown version"
+	}
+	fmt.Printf("\tKernel: %s (%s", version, info.Format)
+	if len(info.Dtbs) != 0 {
+		fmt.Printf(", %d appended DTBs", len(info.Dtbs))
+	}
+	fmt.Println(")")
+}
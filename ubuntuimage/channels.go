@@ -25,14 +25,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const (
 	channelsPath = "/channels.json"
 	indexName    = "index.json"
 	FULL_IMAGE   = "full"
+	DELTA_IMAGE  = "delta"
 )
 
 var client = &http.Client{}
@@ -46,19 +48,25 @@ func TLSSkipVerify() {
 	client = &http.Client{Transport: tr}
 }
 
+// NewChannels fetches the channels of server, channels.json is only parsed
+// once its signature was verified against the image signing keyring
 func NewChannels(server string) (channels Channels, err error) {
-	resp, err := client.Get(server + channelsPath)
+	keyrings, err := GetKeyrings(server)
 	if err != nil {
 		return channels, err
 	}
-	defer resp.Body.Close()
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&channels); err != nil {
+	data, err := fetchSigned(server+channelsPath, keyrings.ImageSigning)
+	if err != nil {
+		return channels, err
+	}
+	if err := json.Unmarshal(data, &channels); err != nil {
 		return channels, fmt.Errorf("Unable to parse channel information from %s", server)
 	}
 	return channels, nil
 }
 
+// GetDeviceChannel fetches the index of device in channel, the index may be
+// signed by the image signing keyring or the device signing keyring
 func (channels Channels) GetDeviceChannel(server, channel, device string) (deviceChannel DeviceChannel, err error) {
 	if _, found := channels[channel]; !found {
 		return deviceChannel, fmt.Errorf("Channel %s not found on server %s", channel, server)
@@ -66,17 +74,29 @@ func (channels Channels) GetDeviceChannel(server, channel, device string) (devic
 		return deviceChannel, fmt.Errorf("Device %s not found on server %s channel %s",
 			device, server, channel)
 	}
-	channelUri := server + channels[channel].Devices[device].Index
-	resp, err := client.Get(channelUri)
+	keyrings, err := GetKeyrings(server)
+	if err != nil {
+		return deviceChannel, err
+	}
+	deviceKeyring, err := keyrings.DeviceKeyring(server, device, channels[channel].Devices[device])
 	if err != nil {
 		return deviceChannel, err
 	}
-	defer resp.Body.Close()
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&deviceChannel)
+	signer := joinKeyrings(keyrings.ImageSigning, deviceKeyring)
+
+	channelUri := server + channels[channel].Devices[device].Index
+	data, err := fetchSigned(channelUri, signer)
 	if err != nil {
+		return deviceChannel, err
+	}
+	if err := json.Unmarshal(data, &deviceChannel); err != nil {
 		return deviceChannel, fmt.Errorf("Cannot parse channel information for device on %s", channelUri)
 	}
+	for i := range deviceChannel.Images {
+		for j := range deviceChannel.Images[i].Files {
+			deviceChannel.Images[i].Files[j].signer = signer
+		}
+	}
 	deviceChannel.Alias = channels[channel].Alias
 	order := func(i1, i2 *Image) bool {
 		return i1.Version > i2.Version
@@ -97,51 +117,32 @@ func (deviceChannel *DeviceChannel) GetImage(revision int) (image Image, err err
 	return image, fmt.Errorf("Failed to locate image %d", revision)
 }
 
-func (deviceChannel *DeviceChannel) ListImageVersions() (err error) {
-
-	jsonData := map[string]interface{}{}
-
-	resp, err := client.Get(deviceChannel.Url)
-	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode != 200 {
-		statusErr := errors.New(fmt.Sprintf("Invalid HTTP response: %d", resp.StatusCode))
-		return statusErr
-	}
-
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	err = json.Unmarshal(body, &jsonData)
-	if err != nil {
-		return err
+// ListImageVersions returns the full images in the channel from the oldest
+// to the newest, delta images are left out as they cannot be used to
+// perform an initial device flash
+func (deviceChannel *DeviceChannel) ListImageVersions() (images []Image) {
+	for i := len(deviceChannel.Images) - 1; i >= 0; i-- {
+		if deviceChannel.Images[i].Type == FULL_IMAGE {
+			images = append(images, deviceChannel.Images[i])
+		}
 	}
+	return images
+}
 
-	images := jsonData["images"].([]interface{})
-
-	for i := range images {
-		entry := images[i].(map[string]interface{})
-
-		imageType := entry["type"]
+// GeneratedTime parses GeneratedAt, e.g.; Thu Feb 20 10:10:47 UTC 2014
+func (global IndexGlobal) GeneratedTime() (time.Time, error) {
+	return time.Parse(time.UnixDate, global.GeneratedAt)
+}
 
-		if imageType != FULL_IMAGE {
-			// ignore delta images as they cannot be used to
-			// perform an initial device flash
-			continue
+// VersionDetails splits VersionDetail into the version of each component
+func (image Image) VersionDetails() map[string]string {
+	details := make(map[string]string)
+	for _, detail := range strings.Split(image.VersionDetail, ",") {
+		if kv := strings.SplitN(detail, "=", 2); len(kv) == 2 {
+			details[kv[0]] = kv[1]
 		}
-
-		fmt.Printf("%d: description='%s'\n",
-			int(entry["version"].(float64)),
-			entry["description"])
 	}
-
-	return nil
+	return details
 }
 
 func (deviceChannel *DeviceChannel) GetRelativeImage(revision int) (image Image, err error) {
@@ -166,3 +167,83 @@ func (deviceChannel *DeviceChannel) GetRelativeImage(revision int) (image Image,
 	}
 	return Image{}, err
 }
+
+// UpgradePath returns the images to apply in order to take a device on
+// version from to version to, a from of 0 means nothing is installed yet.
+// Delta images only apply on top of their base while full images apply
+// on top of anything, no image applies on top of a version older than its
+// MinVersion. The chain picked is the one with the smallest download and
+// then the fewest images.
+func (deviceChannel *DeviceChannel) UpgradePath(from, to int) ([]Image, error) {
+	if to < from {
+		return nil, fmt.Errorf("Cannot downgrade from version %d to %d", from, to)
+	}
+	if from == to {
+		return nil, nil
+	}
+
+	type step struct {
+		size  int64
+		steps int
+		prev  int
+		image Image
+		done  bool
+	}
+	cheaper := func(a, b *step) bool {
+		return a.size < b.size || (a.size == b.size && a.steps < b.steps)
+	}
+	best := map[int]*step{from: {}}
+	for {
+		// visit the cheapest version reached so far, the lowest one on ties
+		var current *step
+		version := 0
+		for v, s := range best {
+			if s.done {
+				continue
+			}
+			if current == nil || cheaper(s, current) || (!cheaper(current, s) && v < version) {
+				current, version = s, v
+			}
+		}
+		if current == nil || version == to {
+			break
+		}
+		current.done = true
+
+		for _, image := range deviceChannel.Images {
+			if image.Version <= version || image.Version > to || version < image.MinVersion {
+				continue
+			}
+			if image.Type != FULL_IMAGE && (image.Type != DELTA_IMAGE || image.Base != version) {
+				continue
+			}
+			next := &step{
+				size:  current.size + image.downloadSize(),
+				steps: current.steps + 1,
+				prev:  version,
+				image: image,
+			}
+			s, ok := best[image.Version]
+			if !ok || (!s.done && cheaper(next, s)) {
+				best[image.Version] = next
+			}
+		}
+	}
+
+	if _, ok := best[to]; !ok {
+		return nil, fmt.Errorf("Failed to find an upgrade path from version %d to %d", from, to)
+	}
+	var path []Image
+	for v := to; v != from; v = best[v].prev {
+		path = append([]Image{best[v].image}, path...)
+	}
+	return path, nil
+}
+
+// downloadSize is the size of all the files in image
+func (image Image) downloadSize() (size int64) {
+	for _, file := range image.Files {
+		size += file.Size
+	}
+	return size
+}
@@ -20,12 +20,12 @@ package ubuntuimage
 // with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	. "launchpad.net/gocheck"
-	"net/http"
-	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 const develChannelMako = `{
@@ -206,7 +206,7 @@ func Test(t *testing.T) { TestingT(t) }
 type DeviceChannelsSuite struct {
 	channels Channels
 	devices  map[string]Device
-	ts       *httptest.Server
+	ts       *testServer
 }
 
 var _ = Suite(&DeviceChannelsSuite{})
@@ -215,15 +215,16 @@ func (s *DeviceChannelsSuite) SetUpTest(c *C) {
 	s.devices = make(map[string]Device)
 	s.channels = make(map[string]Channel)
 	s.channels["trusty"] = Channel{
-		Devices: map[string]Device{"mako": Device{"/" + "trusty/mako/index.json"}}}
+		Devices: map[string]Device{"mako": Device{Index: "/" + "trusty/mako/index.json"}}}
 	s.channels["touch/trusty"] = Channel{
-		Devices: map[string]Device{"mako": Device{"/" + "touch/trusty/mako/index.json"}}}
+		Devices: map[string]Device{"mako": Device{Index: "/" + "touch/trusty/mako/index.json"}}}
 	s.channels["touch/devel"] = Channel{
-		Devices: map[string]Device{"mako": Device{"/" + "touch/devel/mako/index.json"}},
+		Devices: map[string]Device{"mako": Device{Index: "/" + "touch/devel/mako/index.json"}},
 		Alias:   "touch/trusty"}
-	s.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, develChannelMako)
-	}))
+	s.ts = newTestServer(c)
+	for _, channel := range s.channels {
+		s.ts.add(channel.Devices["mako"].Index, develChannelMako)
+	}
 }
 
 func (s *DeviceChannelsSuite) TearDownTest(c *C) {
@@ -258,11 +259,9 @@ func (s *DeviceChannelsSuite) TestChannelDeviceNotFoundInChannelWithSlash(c *C)
 }
 
 func (s *DeviceChannelsSuite) TestChannelInvalidDataForDevice(c *C) {
-	s.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "Invalid data")
-	}))
 	device := "mako"
 	channel := "touch/trusty"
+	s.ts.add(s.channels[channel].Devices[device].Index, "Invalid data")
 	expectedErr := fmt.Errorf("Cannot parse channel information for device on %s",
 		s.ts.URL+"/"+channel+"/"+device+"/index.json")
 	_, err := s.channels.GetDeviceChannel(s.ts.URL, channel, device)
@@ -305,9 +304,7 @@ func (s *DeviceChannelsSuite) TestGetLatestImageForChannel(c *C) {
 func (s *DeviceChannelsSuite) TestFailsGetLatestImageForChannelWithOnlyDeltas(c *C) {
 	device := "mako"
 	channel := "touch/devel"
-	s.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, develChannelMakoOnlyDelta)
-	}))
+	s.ts.add(s.channels[channel].Devices[device].Index, develChannelMakoOnlyDelta)
 	channelData, err := s.channels.GetDeviceChannel(s.ts.URL, channel, device)
 	c.Assert(err, IsNil)
 	c.Assert(channelData, NotNil)
@@ -378,15 +375,14 @@ func (s *DeviceChannelsSuite) TestFailGetSpecificImageForChannel(c *C) {
 }
 
 type ChannelsSuite struct {
-	ts *httptest.Server
+	ts *testServer
 }
 
 var _ = Suite(&ChannelsSuite{})
 
 func (s *ChannelsSuite) SetUpTest(c *C) {
-	s.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, channels)
-	}))
+	s.ts = newTestServer(c)
+	s.ts.add(channelsPath, channels)
 }
 
 func (s *ChannelsSuite) TearDownTest(c *C) {
@@ -407,10 +403,160 @@ func (s *ChannelsSuite) TestGetChannelsFromServer(c *C) {
 }
 
 func (s *ChannelsSuite) TestInvalidDataWhenGetChannelsFromServer(c *C) {
-	s.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, "Invalid data")
-	}))
+	s.ts.add(channelsPath, "Invalid data")
 	expectedErr := fmt.Errorf("Unable to parse channel information from %s", s.ts.URL)
 	_, err := NewChannels(s.ts.URL)
 	c.Assert(err, DeepEquals, expectedErr)
 }
+
+const upgradeChannel = `{
+    "images": [
+        {"type": "full", "version": 100, "files": [{"path": "/pool/full-100.tar.xz", "size": 300}]},
+        {"type": "delta", "version": 101, "base": 100, "files": [{"path": "/pool/delta-101.tar.xz", "size": 10}]},
+        {"type": "delta", "version": 102, "base": 101, "files": [{"path": "/pool/delta-102-101.tar.xz", "size": 10}]},
+        {"type": "delta", "version": 102, "base": 100, "files": [{"path": "/pool/delta-102-100.tar.xz", "size": 15}]},
+        {"type": "full", "version": 102, "files": [{"path": "/pool/full-102.tar.xz", "size": 300}]},
+        {"type": "delta", "version": 103, "base": 102, "files": [{"path": "/pool/delta-103.tar.xz", "size": 5}]}
+    ]
+}`
+
+type UpgradePathSuite struct {
+	deviceChannel DeviceChannel
+}
+
+var _ = Suite(&UpgradePathSuite{})
+
+func (s *UpgradePathSuite) SetUpTest(c *C) {
+	c.Assert(json.Unmarshal([]byte(upgradeChannel), &s.deviceChannel), IsNil)
+}
+
+func (s *UpgradePathSuite) checkPath(c *C, from, to int, expected ...string) {
+	path, err := s.deviceChannel.UpgradePath(from, to)
+	c.Assert(err, IsNil)
+	var files []string
+	for _, image := range path {
+		files = append(files, image.Files[0].Path)
+	}
+	c.Check(files, DeepEquals, expected)
+}
+
+func (s *UpgradePathSuite) TestPrefersSmallestDownload(c *C) {
+	s.checkPath(c, 100, 102, "/pool/delta-102-100.tar.xz")
+	s.checkPath(c, 100, 103, "/pool/delta-102-100.tar.xz", "/pool/delta-103.tar.xz")
+	s.checkPath(c, 101, 103, "/pool/delta-102-101.tar.xz", "/pool/delta-103.tar.xz")
+	s.checkPath(c, 100, 101, "/pool/delta-101.tar.xz")
+}
+
+func (s *UpgradePathSuite) TestFallsBackToFullImages(c *C) {
+	// nothing installed
+	s.checkPath(c, 0, 103, "/pool/full-102.tar.xz", "/pool/delta-103.tar.xz")
+	// 101 only exists as a delta on top of 100
+	s.checkPath(c, 0, 101, "/pool/full-100.tar.xz", "/pool/delta-101.tar.xz")
+	// no delta has 99 as a base
+	s.checkPath(c, 99, 102, "/pool/full-102.tar.xz")
+}
+
+func (s *UpgradePathSuite) TestMinVersionIsHonoured(c *C) {
+	for i := range s.deviceChannel.Images {
+		if s.deviceChannel.Images[i].Files[0].Path == "/pool/full-102.tar.xz" {
+			s.deviceChannel.Images[i].MinVersion = 100
+		}
+	}
+	s.checkPath(c, 99, 102, "/pool/full-100.tar.xz", "/pool/delta-102-100.tar.xz")
+	s.checkPath(c, 0, 103, "/pool/full-100.tar.xz", "/pool/delta-102-100.tar.xz", "/pool/delta-103.tar.xz")
+	// 100 is recent enough to take it
+	s.checkPath(c, 100, 102, "/pool/delta-102-100.tar.xz")
+}
+
+func (s *UpgradePathSuite) TestBaseIsModelled(c *C) {
+	path, err := s.deviceChannel.UpgradePath(101, 102)
+	c.Assert(err, IsNil)
+	c.Assert(path, HasLen, 1)
+	c.Check(path[0].Type, Equals, DELTA_IMAGE)
+	c.Check(path[0].Base, Equals, 101)
+}
+
+func (s *UpgradePathSuite) TestNoPath(c *C) {
+	path, err := s.deviceChannel.UpgradePath(103, 103)
+	c.Check(err, IsNil)
+	c.Check(path, HasLen, 0)
+
+	_, err = s.deviceChannel.UpgradePath(102, 100)
+	c.Check(err, ErrorMatches, "Cannot downgrade from version 102 to 100")
+
+	_, err = s.deviceChannel.UpgradePath(100, 104)
+	c.Check(err, ErrorMatches, "Failed to find an upgrade path from version 100 to 104")
+}
+
+const typedIndex = `{
+    "global": {
+        "generated_at": "Thu Feb 20 10:10:47 UTC 2014"
+    },
+    "images": [
+        {
+            "bootme": true,
+            "description": "ubuntu=20140206,device=20140115.1,version=166",
+            "files": [
+                {
+                    "checksum": "36deae060a01dea39bb6a42b4be963c19b88e1c600b405061d74cca7d241e34a",
+                    "order": 0,
+                    "path": "/pool/ubuntu.tar.xz",
+                    "signature": "/pool/ubuntu.tar.xz.asc",
+                    "size": 3292336680
+                }
+            ],
+            "minversion": 150,
+            "phased-percentage": 40,
+            "type": "full",
+            "version": 166,
+            "version_detail": "ubuntu=20140206,device=20140115.1,version=166"
+        },
+        {
+            "description": "ubuntu=20140205",
+            "files": [],
+            "type": "full",
+            "version": 150
+        },
+        {
+            "base": 150,
+            "description": "ubuntu=20140206",
+            "files": [],
+            "type": "delta",
+            "version": 166
+        }
+    ]
+}`
+
+func (s *DeviceChannelsSuite) TestTypedIndex(c *C) {
+	channel := "touch/trusty"
+	s.ts.add(s.channels[channel].Devices["mako"].Index, typedIndex)
+	deviceChannel, err := s.channels.GetDeviceChannel(s.ts.URL, channel, "mako")
+	c.Assert(err, IsNil)
+
+	generated, err := deviceChannel.Global.GeneratedTime()
+	c.Assert(err, IsNil)
+	c.Check(generated.Equal(time.Date(2014, 2, 20, 10, 10, 47, 0, time.UTC)), Equals, true)
+
+	image, err := deviceChannel.GetImage(166)
+	c.Assert(err, IsNil)
+	c.Check(image.Bootme, Equals, true)
+	c.Check(image.MinVersion, Equals, 150)
+	c.Check(image.PhasedPercentage, Equals, 40)
+	c.Check(image.VersionDetails(), DeepEquals, map[string]string{
+		"ubuntu": "20140206", "device": "20140115.1", "version": "166"})
+	c.Assert(image.Files, HasLen, 1)
+	c.Check(image.Files[0].Size, Equals, int64(3292336680))
+}
+
+func (s *DeviceChannelsSuite) TestListImageVersions(c *C) {
+	channel := "touch/trusty"
+	s.ts.add(s.channels[channel].Devices["mako"].Index, typedIndex)
+	deviceChannel, err := s.channels.GetDeviceChannel(s.ts.URL, channel, "mako")
+	c.Assert(err, IsNil)
+
+	images := deviceChannel.ListImageVersions()
+	c.Assert(images, HasLen, 2)
+	c.Check(images[0].Version, Equals, 150)
+	c.Check(images[1].Version, Equals, 166)
+	c.Check(images[1].Type, Equals, FULL_IMAGE)
+}
@@ -0,0 +1,186 @@
+//
+// Helpers to work with an Ubuntu image based Upgrade implementation
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ubuntuimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DownloadRetries is how many times a transfer is retried after a
+// transient error, the delay between attempts doubles every time
+var DownloadRetries = 5
+
+var retryDelay = 2 * time.Second
+
+const defaultMaxDownloads = 4
+
+// downloadSlots is shared by every Download to cap concurrent transfers
+var downloadSlots = struct {
+	sync.Mutex
+	c chan struct{}
+}{c: make(chan struct{}, defaultMaxDownloads)}
+
+// SetMaxDownloads sets how many files are transferred at the same time,
+// downloads already in progress keep counting against the previous limit
+func SetMaxDownloads(n int) {
+	if n < 1 {
+		n = 1
+	}
+	downloadSlots.Lock()
+	downloadSlots.c = make(chan struct{}, n)
+	downloadSlots.Unlock()
+}
+
+// acquireDownloadSlot blocks until a transfer can start, release hands the
+// slot back to the pool it was taken from
+func acquireDownloadSlot() (release func()) {
+	downloadSlots.Lock()
+	slots := downloadSlots.c
+	downloadSlots.Unlock()
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// withRetries runs transfer until it succeeds, fails with a permanent
+// error or runs out of retries
+func withRetries(uri string, transfer func() error) (err error) {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		if err = transfer(); err == nil || !transient(err) || attempt >= DownloadRetries {
+			return err
+		}
+		log.Printf("Download of %s failed, retrying in %s: %s", uri, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// errRange is returned when a server answers a resumed transfer with
+// another range than the one asked for
+type errRange struct {
+	uri          string
+	offset       int64
+	contentRange string
+}
+
+func (e errRange) Error() string {
+	return fmt.Sprintf("asked for %s from byte %d but got range %q", e.uri, e.offset, e.contentRange)
+}
+
+// transient reports if retrying could fix err, server errors and network
+// errors are transient while client errors and local I/O errors are not
+func transient(err error) bool {
+	switch err := err.(type) {
+	case errStatus:
+		return err.code >= 500
+	case errRange:
+		// the partial download was dropped so the retry starts over
+		return true
+	case *url.Error:
+		_, isNet := err.Err.(net.Error)
+		return isNet || err.Err == io.EOF || err.Err == io.ErrUnexpectedEOF
+	case net.Error:
+		return true
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// downloadTo fetches uri into path, what is already in path is kept and
+// only the remainder is requested. If observe is set it is called as each
+// transfer starts and gets a copy of what is written.
+func downloadTo(uri, path string, observe func(offset, size int64) io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return withRetries(uri, func() error {
+		return resume(uri, path, observe)
+	})
+}
+
+func resume(uri, path string, observe func(offset, size int64) io.Writer) error {
+	target, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	offset, err := target.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", offset)) {
+			// start over rather than stitching together mismatched ranges
+			if err := target.Truncate(0); err != nil {
+				return err
+			}
+			return errRange{uri, offset, contentRange}
+		}
+	case http.StatusOK:
+		// the server does not support ranges
+		if err := target.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = target.Seek(0, os.SEEK_SET); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset > 0 {
+			// already complete, the signature check catches anything else
+			return nil
+		}
+		return errStatus{uri, resp.StatusCode}
+	default:
+		return errStatus{uri, resp.StatusCode}
+	}
+
+	var w io.Writer = target
+	if observe != nil {
+		size := int64(-1)
+		if resp.ContentLength >= 0 {
+			size = offset + resp.ContentLength
+		}
+		w = io.MultiWriter(target, observe(offset, size))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,267 @@
+//
+// Helpers to work with an Ubuntu image based Upgrade implementation
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ubuntuimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type DownloadSuite struct {
+	ts          *testServer
+	file        File
+	downloadDir string
+	mu          sync.Mutex
+	requests    []string
+}
+
+var _ = Suite(&DownloadSuite{})
+
+const rootfs = "a rootfs tarball that takes a while to download"
+
+func (s *DownloadSuite) SetUpTest(c *C) {
+	retryDelay = time.Millisecond
+	s.requests = nil
+	s.ts = newTestServer(c)
+	s.ts.add("/pool/ubuntu.tar.xz", rootfs)
+	keyrings, err := GetKeyrings(s.ts.URL)
+	c.Assert(err, IsNil)
+	s.file = File{Server: s.ts.URL, Path: "/pool/ubuntu.tar.xz", Signature: "/pool/ubuntu.tar.xz.asc",
+		signer: keyrings.ImageSigning}
+	s.downloadDir = c.MkDir()
+}
+
+func (s *DownloadSuite) TearDownTest(c *C) {
+	s.ts.Close()
+	retryDelay = 2 * time.Second
+	SetMaxDownloads(defaultMaxDownloads)
+}
+
+// record logs the requests for the rootfs along with their range
+func (s *DownloadSuite) record(r *http.Request) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path == s.file.Path {
+		s.requests = append(s.requests, r.Header.Get("Range"))
+	}
+	return len(s.requests)
+}
+
+func (s *DownloadSuite) checkDownloaded(c *C) {
+	data, err := ioutil.ReadFile(filepath.Join(s.downloadDir, s.file.Path))
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, rootfs)
+	_, err = os.Stat(filepath.Join(s.downloadDir, s.file.Path+"_"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *DownloadSuite) TestResumesPartialDownload(c *C) {
+	partial := filepath.Join(s.downloadDir, s.file.Path+"_")
+	c.Assert(os.MkdirAll(filepath.Dir(partial), 0700), IsNil)
+	c.Assert(ioutil.WriteFile(partial, []byte(rootfs[:10]), 0644), IsNil)
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		s.record(r)
+		serveContent(w, r, data)
+	}
+
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.checkDownloaded(c)
+	c.Check(s.requests, DeepEquals, []string{"bytes=10-"})
+}
+
+func (s *DownloadSuite) TestResumesAfterDroppedConnection(c *C) {
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		if s.record(r) == 1 && r.URL.Path == s.file.Path {
+			// promise everything but hang up halfway through
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:20])
+			return
+		}
+		serveContent(w, r, data)
+	}
+
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.checkDownloaded(c)
+	c.Check(s.requests, DeepEquals, []string{"", "bytes=20-"})
+}
+
+func (s *DownloadSuite) TestRestartsWhenRangesAreIgnored(c *C) {
+	partial := filepath.Join(s.downloadDir, s.file.Path+"_")
+	c.Assert(os.MkdirAll(filepath.Dir(partial), 0700), IsNil)
+	c.Assert(ioutil.WriteFile(partial, []byte("garbage"), 0644), IsNil)
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		s.record(r)
+		w.Write(data)
+	}
+
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.checkDownloaded(c)
+}
+
+func (s *DownloadSuite) TestStartsOverOnMismatchedRange(c *C) {
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		if r.URL.Path != s.file.Path {
+			serveContent(w, r, data)
+			return
+		}
+		switch s.record(r) {
+		case 1:
+			// hang up halfway through
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:20])
+		case 2:
+			// and resume somewhere else than asked for
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 5-%d/%d", len(data)-1, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[5:])
+		default:
+			serveContent(w, r, data)
+		}
+	}
+
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.checkDownloaded(c)
+	c.Check(s.requests, DeepEquals, []string{"", "bytes=20-", ""})
+}
+
+func (s *DownloadSuite) TestSetMaxDownloadsWhileDownloading(c *C) {
+	release := acquireDownloadSlot()
+	SetMaxDownloads(1)
+	// the slot goes back to the pool it came from and does not block
+	done := make(chan struct{})
+	go func() {
+		release()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		c.Fatal("releasing a slot taken before SetMaxDownloads blocked")
+	}
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+}
+
+func (s *DownloadSuite) TestSignedFileWithoutChecksumIsCached(c *C) {
+	c.Assert(s.file.Checksum, Equals, "")
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		s.record(r)
+		serveContent(w, r, data)
+	}
+
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.checkDownloaded(c)
+	c.Check(s.requests, HasLen, 0)
+}
+
+func (s *DownloadSuite) TestRetriesServerErrors(c *C) {
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		if s.record(r) <= 2 && r.URL.Path == s.file.Path {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		serveContent(w, r, data)
+	}
+
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.checkDownloaded(c)
+	c.Check(s.requests, HasLen, 3)
+}
+
+func (s *DownloadSuite) TestGivesUpAfterRetries(c *C) {
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		if s.record(r) > 0 && r.URL.Path == s.file.Path {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		serveContent(w, r, data)
+	}
+	err := s.file.Download(s.downloadDir, nil)
+	c.Assert(err, ErrorMatches, "Got status code 502 for .*/pool/ubuntu.tar.xz")
+	c.Check(s.requests, HasLen, DownloadRetries+1)
+}
+
+func (s *DownloadSuite) TestClientErrorsAreNotRetried(c *C) {
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		if s.record(r) > 0 && r.URL.Path == s.file.Path {
+			http.NotFound(w, r)
+			return
+		}
+		serveContent(w, r, data)
+	}
+
+	err := s.file.Download(s.downloadDir, nil)
+	c.Assert(err, ErrorMatches, "Got status code 404 for .*")
+	c.Check(s.requests, HasLen, 1)
+}
+
+func (s *DownloadSuite) TestStalePartialIsReplaced(c *C) {
+	partial := filepath.Join(s.downloadDir, s.file.Path+"_")
+	c.Assert(os.MkdirAll(filepath.Dir(partial), 0700), IsNil)
+	c.Assert(ioutil.WriteFile(partial, []byte(strings.ToUpper(rootfs[:10])), 0644), IsNil)
+
+	c.Assert(s.file.Download(s.downloadDir, nil), IsNil)
+	s.checkDownloaded(c)
+}
+
+func (s *DownloadSuite) TestConcurrentDownloadsAreCapped(c *C) {
+	SetMaxDownloads(2)
+	var mu sync.Mutex
+	var active, peak int
+	s.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		serveContent(w, r, data)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}
+
+	var files []File
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		file := s.file
+		file.Path = "/pool/" + name + ".tar.xz"
+		file.Signature = file.Path + ".asc"
+		s.ts.add(file.Path, name)
+		files = append(files, file)
+	}
+	errs := make(chan error, len(files))
+	for _, file := range files {
+		go func(file File) {
+			errs <- file.Download(s.downloadDir, nil)
+		}(file)
+	}
+	for range files {
+		c.Check(<-errs, IsNil)
+	}
+	c.Check(peak, Equals, 2)
+}
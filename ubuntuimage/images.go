@@ -23,7 +23,6 @@ import (
 	"bufio"
 	"crypto/sha256"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -31,8 +30,6 @@ import (
 	"os"
 	"path/filepath"
 	"syscall"
-
-	"github.com/cheggaaa/pb"
 )
 
 func hashMatches(filePath, hash string) bool {
@@ -50,6 +47,16 @@ func hashMatches(filePath, hash string) bool {
 	return false
 }
 
+// ErrChecksum is returned when a file does not match the checksum the
+// index has for it
+type ErrChecksum struct {
+	Path string
+}
+
+func (e ErrChecksum) Error() string {
+	return fmt.Sprintf("sha256 checksum mismatch for %s", e.Path)
+}
+
 const commandsStart = `format system
 load_keyring image-master.tar.xz image-master.tar.xz.asc
 load_keyring image-signing.tar.xz image-signing.tar.xz.asc
@@ -124,9 +131,23 @@ func (file *File) MakeRelativeToServer(defaultServer string) error {
 	return nil
 }
 
-func (file File) Download(downloadDir string) (err error) {
-	//TODO Verify downloaded gpg agains image
+// Download fetches the file and its signature into downloadDir, the file
+// is only moved into place once its signature is verified. The transfer
+// is reported to progress which may be nil.
+func (file File) Download(downloadDir string, progress Progress) (err error) {
+	if progress == nil {
+		progress = NewQuietProgress()
+	}
+	defer func() {
+		if err != nil {
+			progress.Error(file.Path, err)
+		} else {
+			progress.Done(file.Path)
+		}
+	}()
+
 	path := filepath.Join(downloadDir, file.Path)
+	sigPath := filepath.Join(downloadDir, file.Signature)
 	// Create file lock to avoid multiple processes downloading the same file
 	lock, err := getLockFd(path)
 	if err != nil {
@@ -141,68 +162,93 @@ func (file File) Download(downloadDir string) (err error) {
 		syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
 	}()
 
-	if hashMatches(path, file.Checksum) {
-		return nil
+	signer, err := file.signerKeyring()
+	if err != nil {
+		return err
 	}
-	for _, f := range []string{file.Signature, file.Path} {
-		uri := file.Server + f
-		path := filepath.Join(downloadDir, f)
-		err := os.MkdirAll(filepath.Dir(path), 0700)
-		if err != nil {
-			return err
-		}
-		target, err := os.Create(path + "_")
-		if err != nil {
-			return err
-		}
-		defer func() {
-			target.Close()
-			if err == nil {
-				os.Rename(path+"_", path)
-			}
-		}()
-		err = download(uri, target)
-		if err != nil {
-			return err
+	// keyrings do not come from an index and have no checksum to compare
+	cached := file.Checksum == "" || hashMatches(path, file.Checksum)
+	if cached && verifyFile(signer, path, sigPath) == nil {
+		if fi, err := os.Stat(path); err == nil {
+			progress.Start(file.Path, fi.Size(), fi.Size())
 		}
+		return nil
 	}
-	return err
-}
 
-func download(uri string, writer io.Writer) (err error) {
-	resp, err := client.Get(uri)
+	release := acquireDownloadSlot()
+	defer release()
+
+	_, err = os.Stat(path + "_")
+	resumed := err == nil
+	err = file.downloadPartial(downloadDir, signer, progress)
+	if err != nil && resumed {
+		// what was left behind may belong to an older version of the file
+		err = file.downloadPartial(downloadDir, signer, progress)
+	}
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != 200 {
-		return errors.New(fmt.Sprintf("Got status code %d for %s", resp.StatusCode, uri))
+	if err := os.Rename(sigPath+"_", sigPath); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
-
-	// Only display a progress bar for files > 2K in size
-	// nb, ContentLength is -1 for *.asc files
-	if resp.ContentLength > 2048 {
-		pbar := pb.New(int(resp.ContentLength))
-		pbar.ShowSpeed = true
-		pbar.Units = pb.U_BYTES
-		pbar.Start()
+	return os.Rename(path+"_", path)
+}
 
-		mw := io.MultiWriter(writer, pbar)
-		_, err = io.Copy(mw, resp.Body)
-		pbar.Finish()
-	} else {
-		bufReader := bufio.NewReader(resp.Body)
-		bufWriter := bufio.NewWriter(writer)
-		defer func() {
-			if err == nil {
-				err = bufWriter.Flush()
-			}
-		}()
+// downloadPartial fetches the file and its signature next to their final
+// location, both are removed if the signature or the checksum do not
+// match so the next attempt starts from scratch
+func (file File) downloadPartial(downloadDir string, signer *Keyring, progress Progress) error {
+	path := filepath.Join(downloadDir, file.Path) + "_"
+	sigPath := filepath.Join(downloadDir, file.Signature) + "_"
+	if err := downloadTo(file.Server+file.Signature, sigPath, nil); err != nil {
+		return err
+	}
+	if err := downloadTo(file.Server+file.Path, path, func(offset, size int64) io.Writer {
+		progress.Start(file.Path, offset, size)
+		return progressWriter{file.Path, progress}
+	}); err != nil {
+		return err
+	}
+	if err := verifyFile(signer, path, sigPath); err != nil {
+		os.Remove(path)
+		os.Remove(sigPath)
+		return ErrSignature{file.Server + file.Path, err}
+	}
+	// a signature alone does not tell this is the file the index wants,
+	// e.g.; a mirror could serve an older signed rootfs
+	if file.Checksum != "" && !hashMatches(path, file.Checksum) {
+		os.Remove(path)
+		os.Remove(sigPath)
+		return ErrChecksum{file.Server + file.Path}
+	}
+	return nil
+}
 
-		_, err = bufReader.WriteTo(bufWriter)
+// signerKeyring returns the keyring file is expected to be signed with,
+// files not coming from an index are part of the keyring chain
+func (file File) signerKeyring() (*Keyring, error) {
+	if file.signer != nil {
+		return file.signer, nil
+	}
+	keyrings, err := GetKeyrings(file.Server)
+	if err != nil {
+		return nil, err
 	}
+	return keyrings.signerOf(file.Path), nil
+}
 
-	return err
+func verifyFile(signer *Keyring, path, sigPath string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sig, err := os.Open(sigPath)
+	if err != nil {
+		return err
+	}
+	defer sig.Close()
+	return signer.Verify(f, sig)
 }
 
 func GetCacheDir() (cacheDir string) {
@@ -0,0 +1,353 @@
+//
+// Helpers to work with an Ubuntu image based Upgrade implementation
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ubuntuimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// Types of the system-image keyrings, each one is signed by the keyring
+// preceding it in the trust chain
+const (
+	ArchiveMaster = "archive-master"
+	ImageMaster   = "image-master"
+	ImageSigning  = "image-signing"
+	DeviceSigning = "device-signing"
+	Blacklist     = "blacklist"
+)
+
+const (
+	imageMasterPath  = "/gpg/image-master.tar.xz"
+	imageSigningPath = "/gpg/image-signing.tar.xz"
+	blacklistPath    = "/gpg/blacklist.tar.xz"
+)
+
+// ArchiveMasterPath is the locally installed keyring the trust chain is
+// anchored to, nothing served by an image server can replace it
+var ArchiveMasterPath = "/usr/share/system-image/archive-master.tar.xz"
+
+// ErrSignature is returned when a file is not signed by a trusted key
+type ErrSignature struct {
+	Path string
+	err  error
+}
+
+func (e ErrSignature) Error() string {
+	return fmt.Sprintf("signature verification failed for %s: %s", e.Path, e.err)
+}
+
+// ErrKeyringExpired is returned when a keyring is used past its expiry
+type ErrKeyringExpired struct {
+	Type   string
+	Expiry time.Time
+}
+
+func (e ErrKeyringExpired) Error() string {
+	return fmt.Sprintf("%s keyring expired on %s", e.Type, e.Expiry.UTC().Format(time.RFC1123))
+}
+
+// Keyring holds the keys and the metadata of a system-image keyring
+// tarball
+type Keyring struct {
+	Type   string
+	Model  string
+	Expiry time.Time
+	Keys   openpgp.EntityList
+}
+
+type keyringJson struct {
+	Type   string `json:"type"`
+	Model  string `json:"model"`
+	Expiry int64  `json:"expiry"`
+}
+
+// ParseKeyring reads a keyring tarball holding keyring.gpg and
+// keyring.json
+func ParseKeyring(tarball []byte) (*Keyring, error) {
+	data, err := unxz(tarball)
+	if err != nil {
+		return nil, err
+	}
+	var gpg, meta []byte
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch path.Base(hdr.Name) {
+		case "keyring.gpg":
+			gpg, err = ioutil.ReadAll(tr)
+		case "keyring.json":
+			meta, err = ioutil.ReadAll(tr)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if gpg == nil || meta == nil {
+		return nil, fmt.Errorf("keyring tarball requires a keyring.gpg and a keyring.json")
+	}
+
+	var info keyringJson
+	if err := json.Unmarshal(meta, &info); err != nil {
+		return nil, fmt.Errorf("cannot parse keyring.json: %s", err)
+	}
+	keys, err := openpgp.ReadKeyRing(bytes.NewReader(gpg))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s keyring: %s", info.Type, err)
+	}
+	keyring := &Keyring{Type: info.Type, Model: info.Model, Keys: keys}
+	if info.Expiry != 0 {
+		keyring.Expiry = time.Unix(info.Expiry, 0)
+	}
+	return keyring, nil
+}
+
+// Expired reports if the keyring can no longer be used
+func (k *Keyring) Expired() bool {
+	return !k.Expiry.IsZero() && time.Now().After(k.Expiry)
+}
+
+// Verify checks the armored detached signature over signed was made by
+// one of the keys in the keyring
+func (k *Keyring) Verify(signed, signature io.Reader) error {
+	if k.Expired() {
+		return ErrKeyringExpired{k.Type, k.Expiry}
+	}
+	_, err := openpgp.CheckArmoredDetachedSignature(k.Keys, signed, signature)
+	return err
+}
+
+// without removes the keys found in blacklist
+func (k *Keyring) without(blacklist *Keyring) {
+	if blacklist == nil {
+		return
+	}
+	var keys openpgp.EntityList
+	for _, e := range k.Keys {
+		if !blacklisted(blacklist.Keys, e) {
+			keys = append(keys, e)
+		}
+	}
+	k.Keys = keys
+}
+
+func blacklisted(blacklist openpgp.EntityList, e *openpgp.Entity) bool {
+	for _, b := range blacklist {
+		if b.PrimaryKey.Fingerprint == e.PrimaryKey.Fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// joinKeyrings merges the keys of keyrings that sign the same files, the
+// expiry of each one was checked when it was loaded
+func joinKeyrings(keyrings ...*Keyring) *Keyring {
+	joined := &Keyring{Type: ImageSigning}
+	for _, k := range keyrings {
+		if k != nil {
+			joined.Keys = append(joined.Keys, k.Keys...)
+		}
+	}
+	return joined
+}
+
+// Keyrings is the trust chain of an image server rooted at the local
+// archive master keyring
+type Keyrings struct {
+	ArchiveMaster *Keyring
+	ImageMaster   *Keyring
+	ImageSigning  *Keyring
+	// Blacklist is nil when the server does not publish one
+	Blacklist *Keyring
+}
+
+var keyringCache = struct {
+	sync.Mutex
+	servers map[string]*Keyrings
+}{servers: make(map[string]*Keyrings)}
+
+// GetKeyrings walks the keyring chain of server verifying each keyring
+// with the one before it
+func GetKeyrings(server string) (*Keyrings, error) {
+	keyringCache.Lock()
+	defer keyringCache.Unlock()
+	if k, ok := keyringCache.servers[server]; ok {
+		return k, nil
+	}
+
+	archive, err := ioutil.ReadFile(ArchiveMasterPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot verify the signatures of %s without the archive-master keyring, %s is provided by system-image-common", server, ArchiveMasterPath)
+	} else if err != nil {
+		return nil, err
+	}
+	keyrings := &Keyrings{}
+	if keyrings.ArchiveMaster, err = checkKeyring(archive, ArchiveMaster); err != nil {
+		return nil, err
+	}
+	if keyrings.ImageMaster, err = fetchKeyring(server, imageMasterPath, ImageMaster, keyrings.ArchiveMaster); err != nil {
+		return nil, err
+	}
+	keyrings.Blacklist, err = fetchKeyring(server, blacklistPath, Blacklist, keyrings.ImageMaster)
+	if status, ok := err.(errStatus); ok && status.code == http.StatusNotFound {
+		keyrings.Blacklist = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if keyrings.ImageSigning, err = fetchKeyring(server, imageSigningPath, ImageSigning, keyrings.ImageMaster); err != nil {
+		return nil, err
+	}
+	keyrings.ImageSigning.without(keyrings.Blacklist)
+
+	keyringCache.servers[server] = keyrings
+	return keyrings, nil
+}
+
+// DeviceKeyring fetches the optional device signing keyring of device,
+// it returns nil if the device does not have one
+func (k *Keyrings) DeviceKeyring(server, model string, device Device) (*Keyring, error) {
+	if device.Keyring == nil {
+		return nil, nil
+	}
+	keyring, err := fetchKeyring(server, device.Keyring.Path, DeviceSigning, k.ImageSigning)
+	if err != nil {
+		return nil, err
+	}
+	if keyring.Model != "" && keyring.Model != model {
+		return nil, fmt.Errorf("%s keyring is for %s not %s", DeviceSigning, keyring.Model, model)
+	}
+	keyring.without(k.Blacklist)
+	return keyring, nil
+}
+
+// signerOf returns the keyring that signs the file at path
+func (k *Keyrings) signerOf(path string) *Keyring {
+	switch path {
+	case imageMasterPath:
+		return k.ArchiveMaster
+	case imageSigningPath, blacklistPath:
+		return k.ImageMaster
+	}
+	return k.ImageSigning
+}
+
+// fetchKeyring downloads the keyring at path and verifies it is signed
+// by signer
+func fetchKeyring(server, path, keyringType string, signer *Keyring) (*Keyring, error) {
+	tarball, err := fetchSigned(server+path, signer)
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := checkKeyring(tarball, keyringType)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return keyring, nil
+}
+
+func checkKeyring(tarball []byte, keyringType string) (*Keyring, error) {
+	keyring, err := ParseKeyring(tarball)
+	if err != nil {
+		return nil, err
+	}
+	if keyring.Type != keyringType {
+		return nil, fmt.Errorf("expected a %s keyring but got %s", keyringType, keyring.Type)
+	}
+	if keyring.Expired() {
+		return nil, ErrKeyringExpired{keyring.Type, keyring.Expiry}
+	}
+	return keyring, nil
+}
+
+// fetchSigned downloads uri and its detached signature and only returns
+// the contents if they were signed by signer
+func fetchSigned(uri string, signer *Keyring) ([]byte, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("no keyring to verify %s with", uri)
+	}
+	data, err := fetch(uri)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := fetch(uri + ".asc")
+	if err != nil {
+		return nil, err
+	}
+	if err := signer.Verify(bytes.NewReader(data), bytes.NewReader(signature)); err != nil {
+		return nil, ErrSignature{uri, err}
+	}
+	return data, nil
+}
+
+type errStatus struct {
+	uri  string
+	code int
+}
+
+func (e errStatus) Error() string {
+	return fmt.Sprintf("Got status code %d for %s", e.code, e.uri)
+}
+
+func fetch(uri string) (data []byte, err error) {
+	err = withRetries(uri, func() error {
+		resp, err := client.Get(uri)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errStatus{uri, resp.StatusCode}
+		}
+		data, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
+	return data, err
+}
+
+func unxz(data []byte) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("xz", "--decompress", "--stdout")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if e, ok := err.(*exec.Error); ok && e.Err == exec.ErrNotFound {
+		return nil, errors.New("cannot decompress keyring: xz is not installed, it is provided by xz-utils")
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot decompress keyring: %s %s", err, stderr.String())
+	}
+	return out, nil
+}
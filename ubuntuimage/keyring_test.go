@@ -0,0 +1,312 @@
+//
+// Helpers to work with an Ubuntu image based Upgrade implementation
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ubuntuimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	. "launchpad.net/gocheck"
+)
+
+var testKeys struct {
+	sync.Once
+	archive, master, signing, device, rogue *openpgp.Entity
+}
+
+// newTestKey generates small keys as they are only used for tests
+func newTestKey(c *C, name string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{RSABits: 1024})
+	c.Assert(err, IsNil)
+	return e
+}
+
+func makeKeyring(c *C, keyringType, model string, expiry time.Time, keys ...*openpgp.Entity) []byte {
+	var gpg bytes.Buffer
+	for _, k := range keys {
+		c.Assert(k.Serialize(&gpg), IsNil)
+	}
+	info := keyringJson{Type: keyringType, Model: model}
+	if !expiry.IsZero() {
+		info.Expiry = expiry.Unix()
+	}
+	meta, err := json.Marshal(info)
+	c.Assert(err, IsNil)
+
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for name, data := range map[string][]byte{"keyring.gpg": gpg.Bytes(), "keyring.json": meta} {
+		c.Assert(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}), IsNil)
+		_, err := tw.Write(data)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(tw.Close(), IsNil)
+
+	cmd := exec.Command("xz", "--compress", "--stdout")
+	cmd.Stdin = &tarball
+	xz, err := cmd.Output()
+	c.Assert(err, IsNil)
+	return xz
+}
+
+// testServer serves a signed keyring chain, every other file is signed
+// by signer unless a signature for it was set
+type testServer struct {
+	*httptest.Server
+	files  map[string][]byte
+	signer *openpgp.Entity
+	// serve writes data in response to r, tests replace it to simulate
+	// misbehaving mirrors
+	serve func(w http.ResponseWriter, r *http.Request, data []byte)
+}
+
+func newTestServer(c *C) *testServer {
+	testKeys.Do(func() {
+		testKeys.archive = newTestKey(c, "archive-master")
+		testKeys.master = newTestKey(c, "image-master")
+		testKeys.signing = newTestKey(c, "image-signing")
+		testKeys.device = newTestKey(c, "device-signing")
+		testKeys.rogue = newTestKey(c, "rogue")
+	})
+	resetKeyrings()
+
+	archive := filepath.Join(c.MkDir(), "archive-master.tar.xz")
+	c.Assert(ioutil.WriteFile(archive, makeKeyring(c, ArchiveMaster, "", time.Time{}, testKeys.archive), 0644), IsNil)
+	ArchiveMasterPath = archive
+
+	s := &testServer{files: make(map[string][]byte), signer: testKeys.signing, serve: serveContent}
+	s.addKeyring(imageMasterPath, makeKeyring(c, ImageMaster, "", time.Time{}, testKeys.master), testKeys.archive)
+	s.addKeyring(imageSigningPath, makeKeyring(c, ImageSigning, "", time.Time{}, testKeys.signing), testKeys.master)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, ok := s.files[r.URL.Path]; ok {
+			s.serve(w, r, data)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	return s
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request, data []byte) {
+	http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+}
+
+func (s *testServer) addKeyring(path string, tarball []byte, signer *openpgp.Entity) {
+	s.files[path] = tarball
+	s.files[path+".asc"] = signWith(signer, tarball)
+}
+
+// add serves data at path along with its signature
+func (s *testServer) add(path, data string) {
+	s.files[path] = []byte(data)
+	s.files[path+".asc"] = signWith(s.signer, []byte(data))
+}
+
+func signWith(signer *openpgp.Entity, data []byte) []byte {
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, signer, bytes.NewReader(data), nil); err != nil {
+		panic(err)
+	}
+	return sig.Bytes()
+}
+
+func resetKeyrings() {
+	keyringCache.Lock()
+	keyringCache.servers = make(map[string]*Keyrings)
+	keyringCache.Unlock()
+}
+
+type KeyringSuite struct {
+	ts *testServer
+}
+
+var _ = Suite(&KeyringSuite{})
+
+func (s *KeyringSuite) SetUpTest(c *C) {
+	s.ts = newTestServer(c)
+	s.ts.add(channelsPath, channels)
+}
+
+func (s *KeyringSuite) TearDownTest(c *C) {
+	s.ts.Close()
+}
+
+func (s *KeyringSuite) TestChain(c *C) {
+	keyrings, err := GetKeyrings(s.ts.URL)
+	c.Assert(err, IsNil)
+	c.Check(keyrings.ArchiveMaster.Type, Equals, ArchiveMaster)
+	c.Check(keyrings.ImageMaster.Type, Equals, ImageMaster)
+	c.Check(keyrings.ImageSigning.Type, Equals, ImageSigning)
+	c.Check(keyrings.Blacklist, IsNil)
+	c.Check(keyrings.ImageSigning.Keys, HasLen, 1)
+	c.Check(keyrings.signerOf(imageMasterPath), Equals, keyrings.ArchiveMaster)
+	c.Check(keyrings.signerOf(imageSigningPath), Equals, keyrings.ImageMaster)
+	c.Check(keyrings.signerOf("/pool/ubuntu.tar.xz"), Equals, keyrings.ImageSigning)
+
+	_, err = NewChannels(s.ts.URL)
+	c.Check(err, IsNil)
+}
+
+func (s *KeyringSuite) TestMissingArchiveMaster(c *C) {
+	ArchiveMasterPath = filepath.Join(c.MkDir(), "archive-master.tar.xz")
+	_, err := GetKeyrings(s.ts.URL)
+	c.Check(err, ErrorMatches, "cannot verify the signatures of .* without the archive-master keyring, .*/archive-master.tar.xz is provided by system-image-common")
+}
+
+func (s *KeyringSuite) TestImageMasterNotSignedByArchiveMaster(c *C) {
+	tarball := makeKeyring(c, ImageMaster, "", time.Time{}, testKeys.rogue)
+	s.ts.addKeyring(imageMasterPath, tarball, testKeys.rogue)
+	_, err := NewChannels(s.ts.URL)
+	c.Assert(err, FitsTypeOf, ErrSignature{})
+	c.Check(err.(ErrSignature).Path, Equals, s.ts.URL+imageMasterPath)
+}
+
+func (s *KeyringSuite) TestImageSigningSkipsImageMaster(c *C) {
+	// image-signing has to be signed by image-master, not archive-master
+	tarball := makeKeyring(c, ImageSigning, "", time.Time{}, testKeys.signing)
+	s.ts.addKeyring(imageSigningPath, tarball, testKeys.archive)
+	_, err := NewChannels(s.ts.URL)
+	c.Assert(err, FitsTypeOf, ErrSignature{})
+}
+
+func (s *KeyringSuite) TestWrongKeyringType(c *C) {
+	tarball := makeKeyring(c, ImageMaster, "", time.Time{}, testKeys.signing)
+	s.ts.addKeyring(imageSigningPath, tarball, testKeys.master)
+	_, err := NewChannels(s.ts.URL)
+	c.Assert(err, ErrorMatches, ".*expected a image-signing keyring but got image-master")
+}
+
+func (s *KeyringSuite) TestExpiredKeyring(c *C) {
+	expiry := time.Now().Add(-time.Hour)
+	tarball := makeKeyring(c, ImageSigning, "", expiry, testKeys.signing)
+	s.ts.addKeyring(imageSigningPath, tarball, testKeys.master)
+	_, err := NewChannels(s.ts.URL)
+	c.Assert(err, ErrorMatches, ".*image-signing keyring expired on .*")
+}
+
+func (s *KeyringSuite) TestBlacklistedSigningKey(c *C) {
+	tarball := makeKeyring(c, Blacklist, "", time.Time{}, testKeys.signing)
+	s.ts.addKeyring(blacklistPath, tarball, testKeys.master)
+	keyrings, err := GetKeyrings(s.ts.URL)
+	c.Assert(err, IsNil)
+	c.Check(keyrings.ImageSigning.Keys, HasLen, 0)
+
+	_, err = NewChannels(s.ts.URL)
+	c.Assert(err, FitsTypeOf, ErrSignature{})
+}
+
+func (s *KeyringSuite) TestTamperedChannels(c *C) {
+	s.ts.files[channelsPath] = []byte(strings.Replace(channels, "trusty", "evil", -1))
+	_, err := NewChannels(s.ts.URL)
+	c.Assert(err, FitsTypeOf, ErrSignature{})
+	c.Check(err.(ErrSignature).Path, Equals, s.ts.URL+channelsPath)
+}
+
+func (s *KeyringSuite) TestMissingSignature(c *C) {
+	delete(s.ts.files, channelsPath+".asc")
+	_, err := NewChannels(s.ts.URL)
+	c.Assert(err, ErrorMatches, "Got status code 404 for .*/channels.json.asc")
+}
+
+func (s *KeyringSuite) TestDeviceKeyring(c *C) {
+	const keyringPath = "/devel/mako/device-signing.tar.xz"
+	const deviceChannels = `{"devel": {"devices": {"mako": {"index": "/devel/mako/index.json",
+		"keyring": {"path": "/devel/mako/device-signing.tar.xz", "signature": "/devel/mako/device-signing.tar.xz.asc"}}}}}`
+	s.ts.add(channelsPath, deviceChannels)
+	s.ts.addKeyring(keyringPath, makeKeyring(c, DeviceSigning, "mako", time.Time{}, testKeys.device), testKeys.signing)
+	s.ts.signer = testKeys.device
+	s.ts.add("/devel/mako/index.json", develChannelMako)
+
+	channels, err := NewChannels(s.ts.URL)
+	c.Assert(err, IsNil)
+	c.Check(channels["devel"].Devices["mako"].Keyring.Path, Equals, keyringPath)
+	deviceChannel, err := channels.GetDeviceChannel(s.ts.URL, "devel", "mako")
+	c.Assert(err, IsNil)
+	c.Check(deviceChannel.Images, HasLen, 3)
+
+	// a device keyring for another model cannot sign for mako
+	s.ts.addKeyring(keyringPath, makeKeyring(c, DeviceSigning, "flo", time.Time{}, testKeys.device), testKeys.signing)
+	_, err = channels.GetDeviceChannel(s.ts.URL, "devel", "mako")
+	c.Check(err, ErrorMatches, "device-signing keyring is for flo not mako")
+}
+
+func (s *KeyringSuite) TestDownload(c *C) {
+	s.ts.add("/pool/ubuntu.tar.xz", "ubuntu")
+	keyrings, err := GetKeyrings(s.ts.URL)
+	c.Assert(err, IsNil)
+	downloadDir := c.MkDir()
+	file := File{Server: s.ts.URL, Path: "/pool/ubuntu.tar.xz", Signature: "/pool/ubuntu.tar.xz.asc",
+		signer: keyrings.ImageSigning}
+	c.Assert(file.Download(downloadDir, nil), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(downloadDir, file.Path))
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "ubuntu")
+
+	for _, f := range GetGPGFiles() {
+		f.Server = s.ts.URL
+		c.Check(f.Download(downloadDir, nil), IsNil)
+	}
+}
+
+func (s *KeyringSuite) TestDownloadRejectsSignedFileWithWrongChecksum(c *C) {
+	// an older rootfs, correctly signed
+	s.ts.add("/pool/ubuntu.tar.xz", "old ubuntu")
+	keyrings, err := GetKeyrings(s.ts.URL)
+	c.Assert(err, IsNil)
+	downloadDir := c.MkDir()
+	file := File{Server: s.ts.URL, Path: "/pool/ubuntu.tar.xz", Signature: "/pool/ubuntu.tar.xz.asc",
+		Checksum: fmt.Sprintf("%x", sha256.Sum256([]byte("ubuntu"))), signer: keyrings.ImageSigning}
+	err = file.Download(downloadDir, nil)
+	c.Assert(err, FitsTypeOf, ErrChecksum{})
+	c.Check(err, ErrorMatches, fmt.Sprintf("sha256 checksum mismatch for %s/pool/ubuntu.tar.xz", s.ts.URL))
+	for _, f := range []string{file.Path, file.Path + "_", file.Signature, file.Signature + "_"} {
+		_, err := os.Stat(filepath.Join(downloadDir, f))
+		c.Check(os.IsNotExist(err), Equals, true)
+	}
+
+	s.ts.add("/pool/ubuntu.tar.xz", "ubuntu")
+	c.Assert(file.Download(downloadDir, nil), IsNil)
+}
+
+func (s *KeyringSuite) TestDownloadRejectsCompromisedMirror(c *C) {
+	s.ts.add("/pool/ubuntu.tar.xz", "ubuntu")
+	s.ts.files["/pool/ubuntu.tar.xz"] = []byte("evil")
+	downloadDir := c.MkDir()
+	file := File{Server: s.ts.URL, Path: "/pool/ubuntu.tar.xz", Signature: "/pool/ubuntu.tar.xz.asc"}
+	err := file.Download(downloadDir, nil)
+	c.Assert(err, FitsTypeOf, ErrSignature{})
+	c.Check(err, ErrorMatches, fmt.Sprintf("signature verification failed for %s/pool/ubuntu.tar.xz: .*", s.ts.URL))
+	for _, f := range []string{file.Path, file.Path + "_", file.Signature, file.Signature + "_"} {
+		_, err := os.Stat(filepath.Join(downloadDir, f))
+		c.Check(os.IsNotExist(err), Equals, true)
+	}
+}
@@ -0,0 +1,286 @@
+//
+// Helpers to work with an Ubuntu image based Upgrade implementation
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ubuntuimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+)
+
+// Progress observes file downloads, the same Progress is shared by
+// concurrent downloads so implementations need to be safe for that.
+//
+// Start is sent every time a transfer of file begins, it starts at offset
+// when resuming and size is the full size of the file or -1 if unknown.
+// Bytes reports n more bytes were transferred, Done and Error are sent once
+// the file is in place or its download failed.
+type Progress interface {
+	Start(file string, offset, size int64)
+	Bytes(file string, n int64)
+	Done(file string)
+	Error(file string, err error)
+}
+
+// ProgressTotals aggregates the progress of every file seen by a Progress
+type ProgressTotals struct {
+	Files       int   `json:"files"`
+	Finished    int   `json:"finished"`
+	Failed      int   `json:"failed"`
+	Size        int64 `json:"size"`
+	Transferred int64 `json:"transferred"`
+}
+
+type fileTally struct {
+	transferred, size int64
+	finished, failed  bool
+}
+
+// tally keeps the totals for the Progress implementations
+type tally struct {
+	mu    sync.Mutex
+	files map[string]*fileTally
+}
+
+func (t *tally) start(file string, offset, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.files == nil {
+		t.files = make(map[string]*fileTally)
+	}
+	t.files[file] = &fileTally{transferred: offset, size: size}
+}
+
+func (t *tally) add(file string, n int64) (transferred, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.files[file]
+	if !ok {
+		return 0, -1
+	}
+	f.transferred += n
+	return f.transferred, f.size
+}
+
+func (t *tally) finish(file string, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.files == nil {
+		t.files = make(map[string]*fileTally)
+	}
+	f, ok := t.files[file]
+	if !ok {
+		// the download failed before the transfer started
+		f = &fileTally{size: -1}
+		t.files[file] = f
+	}
+	f.finished, f.failed = !failed, failed
+}
+
+// Totals returns the aggregated progress so far
+func (t *tally) Totals() (totals ProgressTotals) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	totals.Files = len(t.files)
+	for _, f := range t.files {
+		if f.finished {
+			totals.Finished++
+		} else if f.failed {
+			totals.Failed++
+		}
+		totals.Transferred += f.transferred
+		if f.size > 0 {
+			totals.Size += f.size
+		}
+	}
+	return totals
+}
+
+// QuietProgress only keeps track of the totals
+type QuietProgress struct {
+	tally
+}
+
+func NewQuietProgress() *QuietProgress {
+	return &QuietProgress{}
+}
+
+func (p *QuietProgress) Start(file string, offset, size int64) { p.start(file, offset, size) }
+func (p *QuietProgress) Bytes(file string, n int64)            { p.add(file, n) }
+func (p *QuietProgress) Done(file string)                      { p.finish(file, false) }
+func (p *QuietProgress) Error(file string, err error)          { p.finish(file, true) }
+
+// TTYProgress draws a progress bar on the terminal for each file over 2K
+type TTYProgress struct {
+	tally
+	bars map[string]*pb.ProgressBar
+}
+
+func NewTTYProgress() *TTYProgress {
+	return &TTYProgress{bars: make(map[string]*pb.ProgressBar)}
+}
+
+func (p *TTYProgress) Start(file string, offset, size int64) {
+	p.start(file, offset, size)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bar, ok := p.bars[file]; ok {
+		bar.Finish()
+		delete(p.bars, file)
+	}
+	// nb, the size is unknown for *.asc files
+	if size <= 2048 {
+		return
+	}
+	bar := pb.New(int(size))
+	bar.ShowSpeed = true
+	bar.Units = pb.U_BYTES
+	bar.Set(int(offset))
+	bar.Start()
+	p.bars[file] = bar
+}
+
+func (p *TTYProgress) Bytes(file string, n int64) {
+	p.add(file, n)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bar, ok := p.bars[file]; ok {
+		bar.Add(int(n))
+	}
+}
+
+func (p *TTYProgress) Done(file string) {
+	p.stop(file)
+	p.finish(file, false)
+}
+
+func (p *TTYProgress) Error(file string, err error) {
+	p.stop(file)
+	p.finish(file, true)
+}
+
+func (p *TTYProgress) stop(file string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if bar, ok := p.bars[file]; ok {
+		bar.Finish()
+		delete(p.bars, file)
+	}
+}
+
+// ProgressEvent is written by JSONProgress for every event
+type ProgressEvent struct {
+	Event       string         `json:"event"`
+	File        string         `json:"file"`
+	Transferred int64          `json:"transferred"`
+	Size        int64          `json:"size"`
+	Error       string         `json:"error,omitempty"`
+	Totals      ProgressTotals `json:"totals"`
+}
+
+// JSONProgress writes newline delimited ProgressEvents, bytes events are
+// written at most once per Interval for each file
+type JSONProgress struct {
+	tally
+	Interval time.Duration
+	w        io.Writer
+	wmu      sync.Mutex
+	last     map[string]time.Time
+}
+
+func NewJSONProgress(w io.Writer) *JSONProgress {
+	return &JSONProgress{Interval: time.Second, w: w, last: make(map[string]time.Time)}
+}
+
+func (p *JSONProgress) Start(file string, offset, size int64) {
+	p.start(file, offset, size)
+	p.write(ProgressEvent{Event: "start", File: file, Transferred: offset, Size: size})
+}
+
+func (p *JSONProgress) Bytes(file string, n int64) {
+	transferred, size := p.add(file, n)
+	p.wmu.Lock()
+	now := time.Now()
+	if now.Sub(p.last[file]) < p.Interval && transferred != size {
+		p.wmu.Unlock()
+		return
+	}
+	p.last[file] = now
+	p.wmu.Unlock()
+	p.write(ProgressEvent{Event: "bytes", File: file, Transferred: transferred, Size: size})
+}
+
+func (p *JSONProgress) Done(file string) {
+	p.finish(file, false)
+	transferred, size := p.add(file, 0)
+	p.write(ProgressEvent{Event: "done", File: file, Transferred: transferred, Size: size})
+}
+
+func (p *JSONProgress) Error(file string, err error) {
+	p.finish(file, true)
+	transferred, size := p.add(file, 0)
+	p.write(ProgressEvent{Event: "error", File: file, Transferred: transferred, Size: size, Error: err.Error()})
+}
+
+func (p *JSONProgress) write(event ProgressEvent) {
+	event.Totals = p.Totals()
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	json.NewEncoder(p.w).Encode(event)
+}
+
+// NewProgress returns the Progress for mode, which is one of tty, quiet
+// or json; json events are written to w
+func NewProgress(mode string, w io.Writer) (Progress, error) {
+	switch mode {
+	case "tty":
+		return NewTTYProgress(), nil
+	case "quiet":
+		return NewQuietProgress(), nil
+	case "json":
+		return NewJSONProgress(w), nil
+	}
+	return nil, fmt.Errorf("unknown progress mode %q, use tty, quiet or json", mode)
+}
+
+// NewCommandProgress returns the Progress for mode along with the writer
+// a command prints the rest of its output to, json events take stdout
+// over so they can be parsed and that output goes to stderr instead
+func NewCommandProgress(mode string, stdout, stderr io.Writer) (progress Progress, out io.Writer, err error) {
+	out = stdout
+	if mode == "json" {
+		out = stderr
+	}
+	progress, err = NewProgress(mode, stdout)
+	return progress, out, err
+}
+
+// progressWriter reports what is written to it as bytes of file
+type progressWriter struct {
+	file     string
+	progress Progress
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.progress.Bytes(w.file, int64(len(b)))
+	return len(b), nil
+}
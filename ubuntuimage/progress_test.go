@@ -0,0 +1,140 @@
+//
+// Helpers to work with an Ubuntu image based Upgrade implementation
+//
+// Copyright (c) 2016 Canonical Ltd.
+//
+package ubuntuimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	. "launchpad.net/gocheck"
+)
+
+// ProgressSuite downloads from the same server as DownloadSuite
+type ProgressSuite struct {
+	d DownloadSuite
+}
+
+var _ = Suite(&ProgressSuite{})
+
+func (s *ProgressSuite) SetUpTest(c *C) {
+	s.d.SetUpTest(c)
+}
+
+func (s *ProgressSuite) TearDownTest(c *C) {
+	s.d.TearDownTest(c)
+}
+
+func decodeEvents(c *C, data []byte) (events []ProgressEvent) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var event ProgressEvent
+		c.Assert(dec.Decode(&event), IsNil)
+		events = append(events, event)
+	}
+	return events
+}
+
+func (s *ProgressSuite) TestJSONEvents(c *C) {
+	var buf bytes.Buffer
+	progress := NewJSONProgress(&buf)
+	progress.Interval = 0
+	s.d.file.Checksum = fmt.Sprintf("%x", sha256.Sum256([]byte(rootfs)))
+
+	c.Assert(s.d.file.Download(s.d.downloadDir, progress), IsNil)
+	events := decodeEvents(c, buf.Bytes())
+	c.Assert(len(events) >= 3, Equals, true)
+	size := int64(len(rootfs))
+	c.Check(events[0], DeepEquals, ProgressEvent{Event: "start", File: s.d.file.Path, Size: size,
+		Totals: ProgressTotals{Files: 1, Size: size}})
+	last := events[len(events)-1]
+	c.Check(last, DeepEquals, ProgressEvent{Event: "done", File: s.d.file.Path, Transferred: size, Size: size,
+		Totals: ProgressTotals{Files: 1, Finished: 1, Size: size, Transferred: size}})
+	for _, event := range events[1 : len(events)-1] {
+		c.Check(event.Event, Equals, "bytes")
+	}
+
+	// a cached file is reported as done straight away
+	buf.Reset()
+	c.Assert(s.d.file.Download(s.d.downloadDir, progress), IsNil)
+	events = decodeEvents(c, buf.Bytes())
+	c.Assert(events, HasLen, 2)
+	c.Check(events[0].Event, Equals, "start")
+	c.Check(events[0].Transferred, Equals, size)
+	c.Check(events[1].Event, Equals, "done")
+	c.Check(events[1].Totals, Equals, ProgressTotals{Files: 1, Finished: 1, Size: size, Transferred: size})
+}
+
+func (s *ProgressSuite) TestJSONError(c *C) {
+	s.d.ts.serve = func(w http.ResponseWriter, r *http.Request, data []byte) {
+		http.NotFound(w, r)
+	}
+	var buf bytes.Buffer
+	c.Assert(s.d.file.Download(s.d.downloadDir, NewJSONProgress(&buf)), NotNil)
+	events := decodeEvents(c, buf.Bytes())
+	c.Assert(events, HasLen, 1)
+	c.Check(events[0].Event, Equals, "error")
+	c.Check(events[0].Error, Matches, "Got status code 404 for .*")
+	c.Check(events[0].Totals.Failed, Equals, 1)
+}
+
+func (s *ProgressSuite) TestResumedTotals(c *C) {
+	progress := NewQuietProgress()
+	progress.Start("a", 0, 100)
+	progress.Bytes("a", 30)
+	// a retry resumes at what was written so far
+	progress.Start("a", 30, 100)
+	progress.Bytes("a", 70)
+	progress.Done("a")
+	progress.Start("b", 0, -1)
+	progress.Bytes("b", 5)
+	progress.Error("b", errors.New("boom"))
+	c.Check(progress.Totals(), Equals, ProgressTotals{Files: 2, Finished: 1, Failed: 1, Size: 100, Transferred: 105})
+}
+
+func (s *ProgressSuite) TestNewProgress(c *C) {
+	for mode, expected := range map[string]Progress{
+		"tty":   &TTYProgress{},
+		"quiet": &QuietProgress{},
+		"json":  &JSONProgress{},
+	} {
+		progress, err := NewProgress(mode, &bytes.Buffer{})
+		c.Assert(err, IsNil)
+		c.Check(progress, FitsTypeOf, expected)
+	}
+	_, err := NewProgress("fancy", nil)
+	c.Check(err, ErrorMatches, `unknown progress mode "fancy", use tty, quiet or json`)
+}
+
+func (s *ProgressSuite) TestNewCommandProgress(c *C) {
+	var stdout, stderr bytes.Buffer
+	progress, out, err := NewCommandProgress("tty", &stdout, &stderr)
+	c.Assert(err, IsNil)
+	c.Check(progress, FitsTypeOf, &TTYProgress{})
+	c.Check(out, Equals, &stdout)
+
+	progress, out, err = NewCommandProgress("json", &stdout, &stderr)
+	c.Assert(err, IsNil)
+	c.Check(out, Equals, &stderr)
+	progress.Done("/pool/ubuntu.tar.xz")
+	c.Check(stdout.String(), Matches, `\{"event":"done".*\n`)
+	c.Check(stderr.Len(), Equals, 0)
+}
@@ -21,6 +21,8 @@ package ubuntuimage
 
 type Device struct {
 	Index string
+	// Keyring is the optional device-signing keyring for the device
+	Keyring *File `json:"keyring,omitempty"`
 }
 
 type Channel struct {
@@ -38,19 +40,43 @@ type ImageVersion struct {
 type ImageVersions map[int]ImageVersion
 
 type File struct {
-	Server                    string
-	Checksum, Path, Signature string
-	Size, Order               int
+	Server    string `json:"server,omitempty"`
+	Checksum  string `json:"checksum"`
+	Path      string `json:"path"`
+	Signature string `json:"signature"`
+	Size      int64  `json:"size"`
+	Order     int    `json:"order"`
+	// signer is the keyring the index listing the file was verified with
+	signer *Keyring
 }
 
+// Image is an entry of the images list in index.json
 type Image struct {
-	Description, Type string
-	Version           int
-	Files             []File
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Version     int    `json:"version"`
+	// VersionDetail lists the component versions, e.g.;
+	// ubuntu=20140206,device=20140115.1,version=166
+	VersionDetail string `json:"version_detail,omitempty"`
+	// Base is the version a delta image applies on top of
+	Base int `json:"base,omitempty"`
+	// MinVersion is the oldest version that can upgrade to this image
+	MinVersion int `json:"minversion,omitempty"`
+	// PhasedPercentage is the share of devices the image is rolled out to
+	PhasedPercentage int    `json:"phased-percentage,omitempty"`
+	Bootme           bool   `json:"bootme,omitempty"`
+	Files            []File `json:"files"`
 }
 
+// IndexGlobal holds the global section of index.json
+type IndexGlobal struct {
+	GeneratedAt string `json:"generated_at"`
+}
+
+// DeviceChannel is the index.json of a device in a channel
 type DeviceChannel struct {
-	Url    string
-	Alias  string
-	Images []Image
+	Url    string      `json:"-"`
+	Alias  string      `json:"-"`
+	Global IndexGlobal `json:"global"`
+	Images []Image     `json:"images"`
 }